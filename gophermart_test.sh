@@ -1,5 +1,5 @@
 #!/bin/bash
-          ./gophermarttest_darwin_amd64 \
+          CSRF_ENABLED=false ./gophermarttest_darwin_amd64 \
             -test.v -test.run=^TestGophermart$ \
             -gophermart-binary-path=cmd/gophermart/gophermart \
             -gophermart-host=localhost \
@@ -0,0 +1,331 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/rainset/gophermart/internal/storage"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	contextAuditDetailsKey = "audit_details"
+	adminListLimit         = 50
+)
+
+var adminOrderStatuses = []string{
+	storage.OrderStatusNew,
+	storage.OrderStatusProcessing,
+	storage.OrderStatusInvalid,
+	storage.OrderStatusProcessed,
+}
+
+type ResponseAdminUser struct {
+	ID        int     `json:"id"`
+	Login     string  `json:"login"`
+	Role      string  `json:"role"`
+	Frozen    bool    `json:"frozen"`
+	Current   float64 `json:"current"`
+	Withdrawn float64 `json:"withdrawn"`
+}
+
+func newResponseAdminUser(user storage.UserTable) ResponseAdminUser {
+	return ResponseAdminUser{
+		ID:        user.ID,
+		Login:     user.Login,
+		Role:      user.Role,
+		Frozen:    user.Frozen,
+		Current:   user.Balance,
+		Withdrawn: user.Withdrawn,
+	}
+}
+
+// setAuditDetails добавляет к записи журнала подробности действия.
+func setAuditDetails(c *gin.Context, details interface{}) {
+	b, err := json.Marshal(details)
+	if err == nil {
+		c.Set(contextAuditDetailsKey, string(b))
+	}
+}
+
+// AuditMiddleware записывает в журнал каждый запрос к административному API.
+func (a *App) AuditMiddleware(c *gin.Context) {
+	c.Next()
+
+	entry := storage.AuditEntry{
+		ActorID: a.currentUserID(c),
+		Action:  c.Request.Method + " " + c.FullPath(),
+		Target:  c.Param("number"),
+		Details: c.GetString(contextAuditDetailsKey),
+		Status:  c.Writer.Status(),
+	}
+	entry.TargetUserID, _ = strconv.Atoi(c.Param("id"))
+
+	err := a.s.CreateAuditEntry(entry)
+	if err != nil {
+		_ = c.Error(err)
+	}
+}
+
+// adminTargetUser загружает пользователя из параметра :id.
+func (a *App) adminTargetUser(c *gin.Context) (user storage.UserTable, ok bool) {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": http.StatusBadRequest})
+		return user, false
+	}
+	user, err = a.s.GetUserByID(userID)
+	if err != nil {
+		_ = c.Error(err)
+		if errors.Is(err, storage.ErrorUserNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"code": http.StatusNotFound})
+			return user, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"code": http.StatusInternalServerError})
+		return user, false
+	}
+	return user, true
+}
+
+func (a *App) AdminSearchUsersHandler(c *gin.Context) {
+	query := strings.TrimSpace(c.Query("login"))
+	if query == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"code": http.StatusBadRequest})
+		return
+	}
+	setAuditDetails(c, gin.H{"login": query})
+
+	users, err := a.s.SearchUsersByLogin(query, adminListLimit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": http.StatusInternalServerError})
+		return
+	}
+
+	result := make([]ResponseAdminUser, 0, len(users))
+	for _, v := range users {
+		result = append(result, newResponseAdminUser(v))
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+func (a *App) AdminGetUserHandler(c *gin.Context) {
+	user, ok := a.adminTargetUser(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, newResponseAdminUser(user))
+}
+
+func (a *App) AdminGetUserOrdersHandler(c *gin.Context) {
+	user, ok := a.adminTargetUser(c)
+	if !ok {
+		return
+	}
+
+	orders, ok := a.listOrders(c, user.ID)
+	if !ok {
+		return
+	}
+
+	result := newResponseOrders(orders)
+	if result == nil {
+		result = []ResponseOrderData{}
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+func (a *App) AdminGetUserWithdrawalsHandler(c *gin.Context) {
+	user, ok := a.adminTargetUser(c)
+	if !ok {
+		return
+	}
+
+	withdrawals, ok := a.listWithdrawals(c, user.ID)
+	if !ok {
+		return
+	}
+
+	result := newResponseWithdrawals(withdrawals)
+	if result == nil {
+		result = []ResponseWithdrawal{}
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+func (a *App) AdminAdjustBalanceHandler(c *gin.Context) {
+	user, ok := a.adminTargetUser(c)
+	if !ok {
+		return
+	}
+
+	clientData := struct {
+		Amount float64 `json:"amount"`
+		Reason string  `json:"reason"`
+	}{}
+
+	err := c.BindJSON(&clientData)
+	clientData.Reason = strings.TrimSpace(clientData.Reason)
+	if err != nil || clientData.Amount == 0 || clientData.Reason == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"code": http.StatusBadRequest})
+		return
+	}
+	setAuditDetails(c, clientData)
+
+	err = a.s.AdjustUserBalance(storage.BalanceAdjustment{
+		UserID:  user.ID,
+		ActorID: a.currentUserID(c),
+		Amount:  clientData.Amount,
+		Reason:  clientData.Reason,
+	})
+	if err != nil {
+		_ = c.Error(err)
+		if errors.Is(err, storage.ErrorUserBalanceWithdraw) {
+			c.JSON(http.StatusPaymentRequired, gin.H{"code": http.StatusPaymentRequired})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"code": http.StatusInternalServerError})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"code": http.StatusOK})
+}
+
+func (a *App) AdminSetOrderStatusHandler(c *gin.Context) {
+	clientData := struct {
+		Status string `json:"status"`
+		Reason string `json:"reason"`
+	}{}
+
+	err := c.BindJSON(&clientData)
+	if err != nil || !isAdminOrderStatus(clientData.Status) {
+		c.JSON(http.StatusBadRequest, gin.H{"code": http.StatusBadRequest})
+		return
+	}
+	setAuditDetails(c, clientData)
+
+	err = a.s.SetOrderStatus(c.Param("number"), clientData.Status)
+	if err != nil {
+		_ = c.Error(err)
+		if errors.Is(err, storage.ErrorOrderNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"code": http.StatusNotFound})
+			return
+		}
+		if errors.Is(err, storage.ErrorOrderFinal) {
+			c.JSON(http.StatusConflict, gin.H{"code": http.StatusConflict})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"code": http.StatusInternalServerError})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"code": http.StatusOK})
+}
+
+func isAdminOrderStatus(status string) bool {
+	for _, v := range adminOrderStatuses {
+		if v == status {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *App) adminSetUserFrozen(c *gin.Context, frozen bool) {
+	user, ok := a.adminTargetUser(c)
+	if !ok {
+		return
+	}
+
+	clientData := struct {
+		Reason string `json:"reason"`
+	}{}
+	_ = c.ShouldBindJSON(&clientData)
+	setAuditDetails(c, clientData)
+
+	if user.ID == a.currentUserID(c) {
+		c.JSON(http.StatusConflict, gin.H{"code": http.StatusConflict})
+		return
+	}
+
+	err := a.s.SetUserFrozen(user.ID, frozen)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": http.StatusInternalServerError})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"code": http.StatusOK})
+}
+
+func (a *App) AdminFreezeUserHandler(c *gin.Context) {
+	a.adminSetUserFrozen(c, true)
+}
+
+func (a *App) AdminUnfreezeUserHandler(c *gin.Context) {
+	a.adminSetUserFrozen(c, false)
+}
+
+func (a *App) AdminGetAuditLogHandler(c *gin.Context) {
+	targetUserID, _ := strconv.Atoi(c.Query("user_id"))
+
+	type ResponseAuditEntry struct {
+		ID           int    `json:"id"`
+		ActorID      int    `json:"actor_id"`
+		Action       string `json:"action"`
+		TargetUserID int    `json:"target_user_id,omitempty"`
+		Target       string `json:"target,omitempty"`
+		Details      string `json:"details,omitempty"`
+		Status       int    `json:"status"`
+		CreatedAt    string `json:"created_at"`
+	}
+
+	entries, err := a.s.GetAuditLog(targetUserID, adminListLimit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": http.StatusInternalServerError})
+		return
+	}
+
+	result := make([]ResponseAuditEntry, 0, len(entries))
+	for _, v := range entries {
+		result = append(result, ResponseAuditEntry{
+			ID:           v.ID,
+			ActorID:      v.ActorID,
+			Action:       v.Action,
+			TargetUserID: v.TargetUserID,
+			Target:       v.Target,
+			Details:      v.Details,
+			Status:       v.Status,
+			CreatedAt:    v.CreatedAt.Format(time.RFC3339),
+		})
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// AdminRefundWithdrawalHandler возвращает пользователю баллы за списание без ограничения по времени.
+func (a *App) AdminRefundWithdrawalHandler(c *gin.Context) {
+	user, ok := a.adminTargetUser(c)
+	if !ok {
+		return
+	}
+
+	clientData := struct {
+		Reason string `json:"reason"`
+	}{}
+
+	err := c.BindJSON(&clientData)
+	clientData.Reason = strings.TrimSpace(clientData.Reason)
+	if err != nil || clientData.Reason == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"code": http.StatusBadRequest})
+		return
+	}
+	setAuditDetails(c, clientData)
+
+	a.cancelWithdrawal(c, storage.WithdrawalCancel{
+		UserID:      user.ID,
+		OrderNumber: c.Param("number"),
+		ActorID:     a.currentUserID(c),
+		Reason:      clientData.Reason,
+	})
+}
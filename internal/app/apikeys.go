@@ -0,0 +1,230 @@
+package app
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/rainset/gophermart/internal/storage"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ScopeOrdersRead   = "orders:read"
+	ScopeOrdersWrite  = "orders:write"
+	ScopeBalanceRead  = "balance:read"
+	ScopeBalanceWrite = "balance:write"
+	ScopeWebhooks     = "webhooks"
+)
+
+const (
+	apiKeyPrefix     = "gm_"
+	apiKeyHeader     = "X-API-Key"
+	contextAPIKeyKey = "api_key"
+)
+
+var apiKeyScopes = []string{ScopeOrdersRead, ScopeOrdersWrite, ScopeBalanceRead, ScopeBalanceWrite, ScopeWebhooks}
+
+func isValidScope(scope string) bool {
+	for _, v := range apiKeyScopes {
+		if v == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// requestAPIKey достаёт ключ из заголовка Authorization: Bearer или X-API-Key.
+func requestAPIKey(c *gin.Context) string {
+	if key := c.GetHeader(apiKeyHeader); key != "" {
+		return key
+	}
+	auth := c.GetHeader("Authorization")
+	if len(auth) > 7 && strings.EqualFold(auth[:7], "Bearer ") {
+		return strings.TrimSpace(auth[7:])
+	}
+	return ""
+}
+
+// currentAPIKey возвращает ключ, которым аутентифицирован запрос.
+func (a *App) currentAPIKey(c *gin.Context) (storage.APIKey, bool) {
+	v, ok := c.Get(contextAPIKeyKey)
+	if !ok {
+		return storage.APIKey{}, false
+	}
+	key, ok := v.(storage.APIKey)
+	return key, ok
+}
+
+// APIKeyMiddleware аутентифицирует запросы партнёров по API-ключу.
+// Запросы без ключа пропускаются дальше к AuthMiddleware.
+func (a *App) APIKeyMiddleware(c *gin.Context) {
+	secret := requestAPIKey(c)
+	if secret == "" {
+		c.Next()
+		return
+	}
+
+	key, err := a.s.UseAPIKey(secret)
+	if err != nil {
+		_ = c.Error(err)
+		if errors.Is(err, storage.ErrorAPIKeyNotFound) {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"code": http.StatusUnauthorized})
+			return
+		}
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"code": http.StatusInternalServerError})
+		return
+	}
+
+	user, err := a.s.GetUserByID(key.UserID)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"code": http.StatusUnauthorized})
+		return
+	}
+	if user.Frozen {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"code": http.StatusForbidden})
+		return
+	}
+
+	c.Set(contextUserKey, user)
+	c.Set(contextAPIKeyKey, key)
+	c.Next()
+}
+
+// RequireScope проверяет право API-ключа на операцию. На запросы с cookie-сессией не влияет.
+func (a *App) RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key, ok := a.currentAPIKey(c)
+		if !ok {
+			c.Next()
+			return
+		}
+		for _, v := range key.Scopes {
+			if v == scope {
+				c.Next()
+				return
+			}
+		}
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"code": http.StatusForbidden})
+	}
+}
+
+// SessionOnly закрывает маршрут для API-ключей: управлять учётной записью можно только из сессии.
+func (a *App) SessionOnly(c *gin.Context) {
+	if _, ok := a.currentAPIKey(c); ok {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"code": http.StatusForbidden})
+		return
+	}
+	c.Next()
+}
+
+type ResponseAPIKey struct {
+	ID         int      `json:"id"`
+	Name       string   `json:"name"`
+	Prefix     string   `json:"prefix"`
+	Scopes     []string `json:"scopes"`
+	CreatedAt  string   `json:"created_at"`
+	LastUsedAt string   `json:"last_used_at,omitempty"`
+	Key        string   `json:"key,omitempty"`
+}
+
+func newResponseAPIKey(key storage.APIKey) ResponseAPIKey {
+	result := ResponseAPIKey{
+		ID:        key.ID,
+		Name:      key.Name,
+		Prefix:    key.Prefix,
+		Scopes:    key.Scopes,
+		CreatedAt: key.CreatedAt.Format(time.RFC3339),
+	}
+	if key.LastUsedAt != nil {
+		result.LastUsedAt = key.LastUsedAt.Format(time.RFC3339)
+	}
+	return result
+}
+
+func (a *App) CreateAPIKeyHandler(c *gin.Context) {
+	sessionUserID := a.currentUserID(c)
+
+	clientData := struct {
+		Name   string   `json:"name"`
+		Scopes []string `json:"scopes"`
+	}{}
+
+	err := c.BindJSON(&clientData)
+	clientData.Name = strings.TrimSpace(clientData.Name)
+	if err != nil || clientData.Name == "" || len(clientData.Scopes) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"code": http.StatusBadRequest})
+		return
+	}
+	for _, scope := range clientData.Scopes {
+		if !isValidScope(scope) {
+			c.JSON(http.StatusBadRequest, gin.H{"code": http.StatusBadRequest, "scope": scope})
+			return
+		}
+	}
+
+	token, err := generateToken(20)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": http.StatusInternalServerError})
+		return
+	}
+	secret := apiKeyPrefix + token
+
+	key := storage.APIKey{
+		UserID:    sessionUserID,
+		Name:      clientData.Name,
+		Prefix:    secret[:len(apiKeyPrefix)+8],
+		Scopes:    clientData.Scopes,
+		CreatedAt: time.Now().UTC(),
+	}
+	key.ID, err = a.s.CreateAPIKey(key, secret)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": http.StatusInternalServerError})
+		return
+	}
+
+	// секрет показывается только один раз
+	result := newResponseAPIKey(key)
+	result.Key = secret
+	c.JSON(http.StatusCreated, result)
+}
+
+func (a *App) GetAPIKeysHandler(c *gin.Context) {
+	sessionUserID := a.currentUserID(c)
+
+	keys, err := a.s.GetAPIKeysByUserID(sessionUserID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": http.StatusInternalServerError})
+		return
+	}
+
+	result := make([]ResponseAPIKey, 0, len(keys))
+	for _, v := range keys {
+		result = append(result, newResponseAPIKey(v))
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+func (a *App) RevokeAPIKeyHandler(c *gin.Context) {
+	sessionUserID := a.currentUserID(c)
+
+	keyID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": http.StatusBadRequest})
+		return
+	}
+
+	err = a.s.RevokeAPIKey(sessionUserID, keyID)
+	if err != nil {
+		_ = c.Error(err)
+		if errors.Is(err, storage.ErrorAPIKeyNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"code": http.StatusNotFound})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"code": http.StatusInternalServerError})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"code": http.StatusOK})
+}
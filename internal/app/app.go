@@ -5,7 +5,12 @@ import (
 	"errors"
 	"fmt"
 	"github.com/gin-gonic/gin"
+	"github.com/rainset/gophermart/internal/notify"
+	"github.com/rainset/gophermart/internal/oidc"
+	"github.com/rainset/gophermart/internal/openapi"
+	"github.com/rainset/gophermart/internal/policy"
 	"github.com/rainset/gophermart/internal/storage"
+	"github.com/rainset/gophermart/internal/webhook"
 	"io"
 	"log"
 	"net/http"
@@ -15,17 +20,45 @@ import (
 )
 
 type App struct {
-	mu     sync.Mutex
-	Config Config
-	Router *gin.Engine
-	s      storage.Interface
+	mu       sync.Mutex
+	Config   Config
+	Router   *gin.Engine
+	Notifier notify.Notifier
+	s        storage.Interface
+	policy   *policy.Policy
+	oidc     *oidc.Provider
+	events   *orderEventHub
+	webhooks *webhook.Client
+	spec     *openapi.Spec
 }
 
 func New(storage storage.Interface, c Config) *App {
-	return &App{
-		s:      storage,
-		Config: c,
+	p, err := policy.New(c.Policy)
+	if err != nil {
+		panic(err)
+	}
+	spec, err := openapi.Load()
+	if err != nil {
+		panic(err)
+	}
+	a := &App{
+		s:        storage,
+		Config:   c,
+		Notifier: notify.New(c.NotifierFile),
+		policy:   p,
+		events:   newOrderEventHub(),
+		webhooks: webhook.NewClient(c.WebhookTimeout, c.WebhookAllowPrivate),
+		spec:     spec,
 	}
+	if c.OIDC.Issuer != "" {
+		a.oidc = oidc.NewProvider(oidc.Config{
+			Issuer:       c.OIDC.Issuer,
+			ClientID:     c.OIDC.ClientID,
+			ClientSecret: c.OIDC.ClientSecret,
+			RedirectURL:  c.OIDC.RedirectURL,
+		}, nil)
+	}
+	return a
 }
 
 func (a *App) GetOrderStatusRequest(orderNumber string) (err error) {
@@ -62,6 +95,16 @@ func (a *App) GetOrderStatusRequest(orderNumber string) (err error) {
 			Status:  responseBody.Status,
 			Accrual: responseBody.Accrual,
 		}
+		// срок сгорания отсчитывается с момента, когда баллы стали доступны
+		availableAt := time.Now().UTC()
+		if a.Config.AccrualPendingPeriod > 0 {
+			availableAt = availableAt.Add(a.Config.AccrualPendingPeriod)
+			order.AccrualAvailableAt = &availableAt
+		}
+		if a.Config.PointsTTL > 0 {
+			expiresAt := availableAt.Add(a.Config.PointsTTL)
+			order.AccrualExpiresAt = &expiresAt
+		}
 
 		errDb := a.s.UpdateOrderByNumber(orderNumber, order)
 		if errDb != nil {
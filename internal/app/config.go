@@ -1,10 +1,49 @@
 package app
 
+import (
+	"github.com/rainset/gophermart/internal/policy"
+	"github.com/rainset/gophermart/internal/secrets"
+	"time"
+)
+
 type Config struct {
 	ServerAddress        string
 	DatabaseDsn          string
 	AccrualSystemAddress string
-	SecretKey            string
+	SessionKeys          []secrets.KeyPair // первая пара подписывает новые cookie, остальные принимаются при ротации
 	SessionName          string
 	SessionMaxAge        int
+	SessionCookie        CookieConfig
+	CSRFEnabled          bool // проверять CSRF-токен в изменяющих запросах с cookie-сессией
+
+	Policy           policy.Config // ограничения на логин и пароль
+	PasswordResetTTL time.Duration
+	NotifierFile     string // файл для уведомлений, пусто — уведомления пишутся в лог
+
+	OIDC OIDCConfig // вход через OpenID Connect, пустой Issuer — выключен
+
+	OrderBatchMaxSize int           // максимум номеров в POST /api/user/orders/batch
+	StreamHeartbeat   time.Duration // интервал комментариев-пингов в потоке событий
+
+	WebhookTimeout      time.Duration // таймаут одной попытки доставки
+	WebhookMaxAttempts  int           // после стольких неудачных попыток доставка помечается failed
+	WebhookAllowPrivate bool          // разрешить вебхуки на localhost и внутренние адреса
+
+	OpenAPIValidation        bool // проверять запросы по спецификации OpenAPI
+	OpenAPIValidateResponses bool // сверять со спецификацией и ответы, для тестов
+
+	WithdrawalCancelWindow time.Duration // сколько после списания пользователь может его отменить, 0 — отмена только через администратора
+	HoldTTL                time.Duration // время жизни резерва баллов, после него баллы возвращаются
+
+	PointsTTL          time.Duration // срок жизни начисленных баллов, 0 — не сгорают
+	PointsExpiringSoon time.Duration // за сколько до сгорания баллы попадают в expiring_soon
+
+	AccrualPendingPeriod time.Duration // сколько начисление ждёт в pending, прежде чем его можно тратить, 0 — сразу
+	ClawbackPolicy       ClawbackPolicy
+
+	TOTPIssuer            string
+	TOTPWithdrawThreshold float64       // списания больше порога требуют код 2FA, 0 — без ограничения
+	TOTPMaxFailures       int           // неверных кодов подряд, после которых второй шаг входа закрывается на TOTPLockout
+	TOTPLockout           time.Duration // блокировка второго шага и окно, в котором считаются неверные коды
+	TOTPSecretKey         []byte        // ключ AES-256 для секретов TOTP в базе, пусто — хранятся открыто
 }
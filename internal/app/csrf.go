@@ -0,0 +1,105 @@
+package app
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strings"
+)
+
+const (
+	csrfSessionKey = "csrf_token"
+	csrfHeader     = "X-CSRF-Token"
+	csrfFormField  = "csrf_token"
+)
+
+// CookieConfig — атрибуты сессионной cookie.
+type CookieConfig struct {
+	Path     string
+	Domain   string
+	Secure   bool
+	HttpOnly bool
+	SameSite http.SameSite
+}
+
+func ParseSameSite(s string) (http.SameSite, error) {
+	switch strings.ToLower(s) {
+	case "", "default":
+		return http.SameSiteDefaultMode, nil
+	case "lax":
+		return http.SameSiteLaxMode, nil
+	case "strict":
+		return http.SameSiteStrictMode, nil
+	case "none":
+		return http.SameSiteNoneMode, nil
+	}
+	return 0, fmt.Errorf("unknown SameSite mode %q", s)
+}
+
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return false
+}
+
+// setCSRFToken выдаёт новый токен сессии и возвращает его в заголовке ответа.
+// Сессию сохраняет вызывающий.
+func (a *App) setCSRFToken(c *gin.Context, session sessions.Session) string {
+	token, err := generateToken(32)
+	if err != nil {
+		return ""
+	}
+	session.Set(csrfSessionKey, token)
+	c.Header(csrfHeader, token)
+	return token
+}
+
+// CSRFMiddleware защищает изменяющие запросы с cookie-сессией токеном синхронизации:
+// токен хранится в сессии и должен прийти в заголовке X-CSRF-Token (или поле формы csrf_token).
+// Запросы с API-ключом не проверяются — браузер не подставляет его сам.
+func (a *App) CSRFMiddleware(c *gin.Context) {
+	if !a.Config.CSRFEnabled {
+		c.Next()
+		return
+	}
+	if _, ok := a.currentAPIKey(c); ok {
+		c.Next()
+		return
+	}
+
+	session := sessions.Default(c)
+	token, _ := session.Get(csrfSessionKey).(string)
+	if token == "" {
+		token = a.setCSRFToken(c, session)
+		_ = session.Save()
+	} else {
+		c.Header(csrfHeader, token)
+	}
+
+	if !isSafeMethod(c.Request.Method) {
+		sent := c.GetHeader(csrfHeader)
+		if sent == "" {
+			sent = c.PostForm(csrfFormField)
+		}
+		if token == "" || subtle.ConstantTimeCompare([]byte(sent), []byte(token)) != 1 {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"code": http.StatusForbidden, "error": "csrf token mismatch"})
+			return
+		}
+	}
+
+	c.Next()
+}
+
+func (a *App) GetCSRFTokenHandler(c *gin.Context) {
+	session := sessions.Default(c)
+	token, _ := session.Get(csrfSessionKey).(string)
+	if token == "" {
+		token = a.setCSRFToken(c, session)
+		_ = session.Save()
+	}
+	c.JSON(http.StatusOK, gin.H{"csrf_token": token})
+}
@@ -0,0 +1,147 @@
+package app
+
+import (
+	"encoding/csv"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/rainset/gophermart/internal/pdf"
+	"github.com/rainset/gophermart/internal/storage"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const exportFlushEvery = 100
+
+func exportFilename(format string) string {
+	return fmt.Sprintf("attachment; filename=\"gophermart-%s.%s\"", time.Now().UTC().Format("20060102"), format)
+}
+
+func formatAmount(v float64) string {
+	return strconv.FormatFloat(v, 'f', 2, 64)
+}
+
+// ExportHandler выгружает историю заказов, начислений и списаний в CSV или PDF.
+func (a *App) ExportHandler(c *gin.Context) {
+	sessionUserID := a.currentUserID(c)
+
+	from, to, ok := statementPeriod(c)
+	if !ok {
+		return
+	}
+
+	switch c.DefaultQuery("format", "csv") {
+	case "csv":
+		a.exportCSV(c, sessionUserID, from, to)
+	case "pdf":
+		a.exportPDF(c, sessionUserID, from, to)
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"code": http.StatusBadRequest})
+	}
+}
+
+// exportCSV пишет строки по мере чтения из базы. После первой строки статус ответа
+// уже не поменять, поэтому ошибка в середине выгрузки только обрывает поток.
+func (a *App) exportCSV(c *gin.Context, userID int, from, to *time.Time) {
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", exportFilename("csv"))
+	c.Status(http.StatusOK)
+
+	w := csv.NewWriter(c.Writer)
+	_ = w.Write([]string{"date", "type", "order", "status", "amount", "reason"})
+
+	n := 0
+	err := a.s.ExportHistory(userID, from, to, func(row storage.ExportRow) error {
+		err := w.Write([]string{row.At.Format(time.RFC3339), row.Type, row.Order, row.Status, formatAmount(row.Amount), row.Reason})
+		if err != nil {
+			return err
+		}
+		if n++; n%exportFlushEvery == 0 {
+			w.Flush()
+			c.Writer.Flush()
+			return w.Error()
+		}
+		return nil
+	})
+	if err != nil {
+		log.Println("export csv: ", err)
+		return
+	}
+	w.Flush()
+}
+
+func (a *App) exportPDF(c *gin.Context, userID int, from, to *time.Time) {
+	user, err := a.s.GetUserByID(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": http.StatusInternalServerError})
+		return
+	}
+
+	period := "all time"
+	if from != nil || to != nil {
+		period = ""
+		if from != nil {
+			period += "from " + from.Format("2006-01-02 ")
+		}
+		if to != nil {
+			period += "to " + to.Format("2006-01-02")
+		}
+	}
+
+	doc := pdf.New()
+	doc.Line("Gophermart account history")
+	doc.Line("User: %s", user.Login)
+	doc.Line("Period: %s", period)
+	doc.Line("Generated: %s", time.Now().UTC().Format(time.RFC3339))
+	doc.Line("")
+	doc.Line("%-20s %-10s %-20s %-10s %12s  %s", "Date", "Type", "Order", "Status", "Amount", "Reason")
+
+	var orders int
+	var accrued, withdrawn, adjusted, expired, returned float64
+	err = a.s.ExportHistory(userID, from, to, func(row storage.ExportRow) error {
+		doc.Line("%-20s %-10s %-20s %-10s %12s  %s", row.At.Format("2006-01-02 15:04:05"), row.Type, row.Order, row.Status,
+			formatAmount(row.Amount), row.Reason)
+		switch row.Type {
+		case storage.ExportRowOrder:
+			orders++
+		case storage.ExportRowAccrual:
+			accrued += row.Amount
+		case storage.ExportRowWithdrawal, storage.ExportRowRefund:
+			withdrawn -= row.Amount
+		case storage.ExportRowAdjustment:
+			adjusted += row.Amount
+		case storage.ExportRowExpiry:
+			expired -= row.Amount
+		case storage.ExportRowReturn, storage.ExportRowRecovery:
+			returned -= row.Amount
+		}
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": http.StatusInternalServerError})
+		return
+	}
+
+	doc.Line("")
+	doc.Line("Orders uploaded: %d", orders)
+	doc.Line("Total accrued:   %12s", formatAmount(accrued))
+	doc.Line("Total withdrawn: %12s", formatAmount(withdrawn))
+	if adjusted != 0 {
+		doc.Line("Adjustments:     %12s", formatAmount(adjusted))
+	}
+	if expired != 0 {
+		doc.Line("Expired:         %12s", formatAmount(expired))
+	}
+	if returned != 0 {
+		doc.Line("Returned:        %12s", formatAmount(returned))
+	}
+	doc.Line("Net change:      %12s", formatAmount(accrued-withdrawn+adjusted-expired-returned))
+
+	c.Header("Content-Type", "application/pdf")
+	c.Header("Content-Disposition", exportFilename("pdf"))
+	c.Status(http.StatusOK)
+	if _, err = doc.WriteTo(c.Writer); err != nil {
+		log.Println("export pdf: ", err)
+	}
+}
@@ -6,51 +6,305 @@ import (
 	"github.com/gin-contrib/sessions/cookie"
 	"github.com/gin-gonic/contrib/gzip"
 	"github.com/gin-gonic/gin"
+	"github.com/rainset/gophermart/internal/luhn"
+	"github.com/rainset/gophermart/internal/policy"
+	"github.com/rainset/gophermart/internal/secrets"
 	"github.com/rainset/gophermart/internal/storage"
 	"log"
 	"net/http"
-	"strconv"
+	"strings"
 	"time"
 )
 
 func (a *App) NewRouter() *gin.Engine {
 	r := gin.Default()
-	r.Use(gzip.Gzip(gzip.DefaultCompression))
-
-	store := cookie.NewStore([]byte(a.Config.SecretKey))
-	store.Options(sessions.Options{MaxAge: a.Config.SessionMaxAge})
+	r.Use(gzipExceptStreams(gzip.Gzip(gzip.DefaultCompression)))
+
+	store := cookie.NewStore(secrets.CookieKeys(a.Config.SessionKeys)...)
+	store.Options(sessions.Options{
+		Path:     a.Config.SessionCookie.Path,
+		Domain:   a.Config.SessionCookie.Domain,
+		MaxAge:   a.Config.SessionMaxAge,
+		Secure:   a.Config.SessionCookie.Secure,
+		HttpOnly: a.Config.SessionCookie.HttpOnly,
+		SameSite: a.Config.SessionCookie.SameSite,
+	})
 	r.Use(sessions.Sessions(a.Config.SessionName, store))
 
 	r.Use(gin.CustomRecovery(func(c *gin.Context, recovered interface{}) {
+		if isAPIv2(c) {
+			writeProblem(c, http.StatusInternalServerError, nil)
+			c.Abort()
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"code": http.StatusInternalServerError})
 		c.AbortWithStatus(http.StatusInternalServerError)
 	}))
 
-	r.POST("/api/user/register", a.UserRegisterHandler)
-	r.POST("/api/user/login", a.UserLoginHandler)
-
-	r.POST("/api/user/orders", a.AuthMiddleware, a.CreateUserOrderHandler)
-	r.GET("/api/user/orders", a.AuthMiddleware, a.GetUserOrdersHandler)
+	// v1 остаётся в прежнем виде для совместимости, v2 — те же маршруты с ошибками в формате RFC 7807
+	v1 := r.Group("/api")
+	v2 := r.Group(apiV2Prefix, a.ProblemMiddleware)
+	if a.Config.OpenAPIValidation || a.Config.OpenAPIValidateResponses {
+		v1.Use(a.OpenAPIMiddleware)
+		v2.Use(a.OpenAPIMiddleware)
+	}
+	a.mountAPI(v1)
+	a.mountAPI(v2)
 
-	r.GET("/api/user/balance", a.AuthMiddleware, a.GetUserBalanceHandler)
-	r.POST("/api/user/balance/withdraw", a.AuthMiddleware, a.CreateUserWithdrawHandler)
-	r.GET("/api/user/withdrawals", a.AuthMiddleware, a.GetUserWithdrawalsHandler)
+	if a.oidc != nil && a.Config.OIDC.TestIdP {
+		a.mountTestIdP(r)
+	}
 
 	r.NoRoute(func(c *gin.Context) {
-		c.JSON(http.StatusInternalServerError, gin.H{"code": http.StatusInternalServerError})
+		if strings.HasPrefix(c.Request.URL.Path, apiV2Prefix+"/") {
+			writeProblem(c, http.StatusNotFound, nil)
+			return
+		}
+		c.JSON(http.StatusNotFound, gin.H{"code": http.StatusNotFound})
 	})
+
+	missing, undocumented := a.checkOpenAPISync(r)
+	for _, v := range missing {
+		log.Printf("openapi: %s is documented but not routed", v)
+	}
+	for _, v := range undocumented {
+		log.Printf("openapi: %s is routed but not documented", v)
+	}
 	return r
 }
 
+func (a *App) mountAPI(api *gin.RouterGroup) {
+	api.GET("/openapi.json", a.OpenAPIHandler)
+
+	api.POST("/user/register", a.UserRegisterHandler)
+	api.POST("/user/login", a.UserLoginHandler)
+	api.POST("/user/login/2fa", a.UserLogin2FAHandler)
+
+	if a.oidc != nil {
+		api.GET("/user/oidc/login", a.OIDCLoginHandler)
+		api.GET("/user/oidc/callback", a.OIDCCallbackHandler)
+	}
+
+	api.POST("/user/password/reset", a.RequestPasswordResetHandler)
+	api.POST("/user/password/reset/confirm", a.ConfirmPasswordResetHandler)
+
+	user := api.Group("/user", a.APIKeyMiddleware, a.AuthMiddleware, a.CSRFMiddleware)
+	user.POST("/orders", a.RequireScope(ScopeOrdersWrite), a.CreateUserOrderHandler)
+	user.POST("/orders/batch", a.RequireScope(ScopeOrdersWrite), a.CreateUserOrdersBatchHandler)
+	user.GET("/orders", a.RequireScope(ScopeOrdersRead), a.GetUserOrdersHandler)
+	user.GET("/orders/stream", a.RequireScope(ScopeOrdersRead), a.StreamUserOrdersHandler)
+	user.GET("/orders/:number", a.RequireScope(ScopeOrdersRead), a.GetUserOrderHandler)
+
+	user.GET("/balance", a.RequireScope(ScopeBalanceRead), a.GetUserBalanceHandler)
+	user.GET("/balance/statement", a.RequireScope(ScopeBalanceRead), a.GetUserStatementHandler)
+	user.POST("/balance/withdraw", a.RequireScope(ScopeBalanceWrite), a.CreateUserWithdrawHandler)
+	user.GET("/withdrawals", a.RequireScope(ScopeBalanceRead), a.GetUserWithdrawalsHandler)
+	user.GET("/balance/withdrawals", a.RequireScope(ScopeBalanceRead), a.GetUserWithdrawalsHandler)
+	user.POST("/withdrawals/:number/cancel", a.RequireScope(ScopeBalanceWrite), a.CancelUserWithdrawHandler)
+
+	user.POST("/balance/holds", a.RequireScope(ScopeBalanceWrite), a.CreateHoldHandler)
+	user.GET("/balance/holds", a.RequireScope(ScopeBalanceRead), a.GetHoldsHandler)
+	user.POST("/balance/holds/:id/capture", a.RequireScope(ScopeBalanceWrite), a.CaptureHoldHandler)
+	user.POST("/balance/holds/:id/void", a.RequireScope(ScopeBalanceWrite), a.VoidHoldHandler)
+	user.GET("/export", a.RequireScope(ScopeOrdersRead), a.RequireScope(ScopeBalanceRead), a.ExportHandler)
+
+	user.POST("/webhooks", a.RequireScope(ScopeWebhooks), a.CreateWebhookHandler)
+	user.GET("/webhooks", a.RequireScope(ScopeWebhooks), a.GetWebhooksHandler)
+	user.DELETE("/webhooks/:id", a.RequireScope(ScopeWebhooks), a.DeleteWebhookHandler)
+	user.GET("/webhooks/:id/deliveries", a.RequireScope(ScopeWebhooks), a.GetWebhookDeliveriesHandler)
+
+	account := user.Group("", a.SessionOnly)
+	account.GET("/csrf", a.GetCSRFTokenHandler)
+	account.GET("/security/logins", a.GetLoginHistoryHandler)
+	account.POST("/password", a.ChangePasswordHandler)
+
+	account.POST("/2fa/enroll", a.Enroll2FAHandler)
+	account.POST("/2fa/confirm", a.Confirm2FAHandler)
+	account.POST("/2fa/disable", a.Disable2FAHandler)
+
+	account.POST("/api-keys", a.CreateAPIKeyHandler)
+	account.GET("/api-keys", a.GetAPIKeysHandler)
+	account.DELETE("/api-keys/:id", a.RevokeAPIKeyHandler)
+
+	admin := api.Group("/admin", a.AuthMiddleware, a.CSRFMiddleware, a.AuditMiddleware)
+	admin.GET("/users", a.RequirePermission(PermissionUsersRead), a.AdminSearchUsersHandler)
+	admin.GET("/users/:id", a.RequirePermission(PermissionUsersRead), a.AdminGetUserHandler)
+	admin.GET("/users/:id/orders", a.RequirePermission(PermissionUsersRead), a.AdminGetUserOrdersHandler)
+	admin.GET("/users/:id/withdrawals", a.RequirePermission(PermissionUsersRead), a.AdminGetUserWithdrawalsHandler)
+	admin.POST("/users/:id/balance", a.RequirePermission(PermissionBalanceAdjust), a.AdminAdjustBalanceHandler)
+	admin.POST("/users/:id/withdrawals/:number/refund", a.RequirePermission(PermissionBalanceAdjust), a.AdminRefundWithdrawalHandler)
+	admin.POST("/users/:id/freeze", a.RequirePermission(PermissionUsersWrite), a.AdminFreezeUserHandler)
+	admin.POST("/users/:id/unfreeze", a.RequirePermission(PermissionUsersWrite), a.AdminUnfreezeUserHandler)
+	admin.PUT("/users/:id/role", a.RequirePermission(PermissionRolesManage), a.AdminSetUserRoleHandler)
+	admin.PUT("/orders/:number/status", a.RequirePermission(PermissionOrdersManage), a.AdminSetOrderStatusHandler)
+	admin.POST("/orders/:number/return", a.RequirePermission(PermissionOrdersReturn), a.AdminReturnOrderHandler)
+	admin.GET("/audit", a.RequirePermission(PermissionAuditRead), a.AdminGetAuditLogHandler)
+}
+
+type ResponseOrderData struct {
+	Number     string  `json:"number"`
+	Status     string  `json:"status"`
+	Accrual    float64 `json:"accrual,omitempty"`
+	Returned   float64 `json:"returned,omitempty"`
+	UploadedAt string  `json:"uploaded_at"`
+}
+
+func newResponseOrders(orders []storage.OrderTable) (result []ResponseOrderData) {
+	for _, v := range orders {
+		preparedOrder := ResponseOrderData{
+			Number:     v.Number,
+			Status:     v.Status,
+			Accrual:    v.Accrual,
+			Returned:   v.Returned,
+			UploadedAt: v.UploadedAt.Format(time.RFC3339),
+		}
+		result = append(result, preparedOrder)
+	}
+	return result
+}
+
+type ResponseOrderStatusEvent struct {
+	Status    string  `json:"status"`
+	Accrual   float64 `json:"accrual,omitempty"`
+	Source    string  `json:"source"`
+	CreatedAt string  `json:"created_at"`
+}
+
+type ResponseOrderDetail struct {
+	ResponseOrderData
+	ProcessedAt string                     `json:"processed_at,omitempty"`
+	History     []ResponseOrderStatusEvent `json:"history"`
+}
+
+func newResponseOrderDetail(order storage.OrderTable, events []storage.OrderStatusEvent) ResponseOrderDetail {
+	result := ResponseOrderDetail{
+		ResponseOrderData: newResponseOrders([]storage.OrderTable{order})[0],
+		History:           make([]ResponseOrderStatusEvent, 0, len(events)),
+	}
+	if order.ProcessedAt != nil {
+		result.ProcessedAt = order.ProcessedAt.Format(time.RFC3339)
+	}
+	for _, v := range events {
+		result.History = append(result.History, ResponseOrderStatusEvent{
+			Status:    v.Status,
+			Accrual:   v.Accrual,
+			Source:    v.Source,
+			CreatedAt: v.CreatedAt.Format(time.RFC3339),
+		})
+	}
+	return result
+}
+
+// ResponseBalance — баланс пользователя. current совпадает с available и остаётся для совместимости,
+// pending — начисления, которые ещё нельзя тратить, debt — долг по возвратам.
+type ResponseBalance struct {
+	Current      float64                  `json:"current"`
+	Withdrawn    float64                  `json:"withdrawn"`
+	Available    float64                  `json:"available"`
+	Held         float64                  `json:"held"`
+	Pending      float64                  `json:"pending"`
+	Debt         float64                  `json:"debt,omitempty"`
+	ExpiringSoon []ResponseExpiringPoints `json:"expiring_soon,omitempty"`
+}
+
+type ResponseExpiringPoints struct {
+	Amount    float64 `json:"amount"`
+	Order     string  `json:"order"`
+	ExpiresAt string  `json:"expires_at"`
+}
+
+func newResponseBalance(balance storage.UserBalance) ResponseBalance {
+	return ResponseBalance{
+		Current:   balance.Balance,
+		Withdrawn: balance.Withdrawn,
+		Available: balance.Balance,
+		Held:      balance.Held,
+		Pending:   balance.Pending,
+		Debt:      balance.Debt,
+	}
+}
+
+type ResponseWithdrawal struct {
+	OrderNumber  string  `json:"order"`
+	Sum          float64 `json:"sum"`
+	Status       string  `json:"status"`
+	ProcessedAt  string  `json:"processed_at"`
+	CancelledAt  string  `json:"cancelled_at,omitempty"`
+	CancelReason string  `json:"cancel_reason,omitempty"`
+}
+
+func newResponseWithdrawal(withdrawal storage.WithdrawalTable) ResponseWithdrawal {
+	result := ResponseWithdrawal{
+		OrderNumber:  withdrawal.OrderNumber,
+		Sum:          withdrawal.Sum,
+		Status:       withdrawal.Status,
+		ProcessedAt:  withdrawal.ProcessedAt.Format(time.RFC3339),
+		CancelReason: withdrawal.CancelReason,
+	}
+	if withdrawal.CancelledAt != nil {
+		result.CancelledAt = withdrawal.CancelledAt.Format(time.RFC3339)
+	}
+	return result
+}
+
+func newResponseWithdrawals(withdrawals []storage.WithdrawalTable) (result []ResponseWithdrawal) {
+	for _, v := range withdrawals {
+		result = append(result, newResponseWithdrawal(v))
+	}
+	return result
+}
+
 func (a *App) AuthMiddleware(c *gin.Context) {
+	// запрос уже аутентифицирован API-ключом
+	if _, ok := c.Get(contextUserKey); ok {
+		c.Next()
+		return
+	}
+
 	session := sessions.Default(c)
-	sessionHash := session.Get(a.Config.SessionName)
-	if sessionHash == nil {
+	sessionUserID, ok := session.Get(a.Config.SessionName).(int)
+	if !ok {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"code": http.StatusUnauthorized})
+		return
+	}
+
+	user, err := a.s.GetUserByID(sessionUserID)
+	if err != nil {
+		if errors.Is(err, storage.ErrorUserNotFound) {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"code": http.StatusUnauthorized})
+			return
+		}
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"code": http.StatusInternalServerError})
+		return
+	}
+	// после смены пароля старые сессии недействительны
+	sessionVersion, _ := session.Get(sessionVersionKey).(int)
+	if sessionVersion != user.SessionVersion {
+		session.Clear()
+		_ = session.Save()
 		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"code": http.StatusUnauthorized})
+		return
 	}
+	if user.Frozen {
+		_ = c.Error(storage.ErrorUserFrozen)
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"code": http.StatusForbidden})
+		return
+	}
+	c.Set(contextUserKey, user)
+
 	c.Next()
 }
 
+func (a *App) openSession(c *gin.Context, userID, sessionVersion int) {
+	session := sessions.Default(c)
+	session.Set(a.Config.SessionName, userID)
+	session.Set(sessionVersionKey, sessionVersion)
+	if a.Config.CSRFEnabled {
+		a.setCSRFToken(c, session)
+	}
+	_ = session.Save()
+}
+
 func (a *App) UserRegisterHandler(c *gin.Context) {
 
 	clientData := struct {
@@ -65,12 +319,22 @@ func (a *App) UserRegisterHandler(c *gin.Context) {
 		return
 	}
 
+	clientData.Login = policy.NormalizeLogin(clientData.Login)
+	violations := a.policy.ValidateLogin(clientData.Login)
+	violations = append(violations, a.policy.ValidatePassword(clientData.Password, clientData.Login)...)
+	if len(violations) > 0 {
+		_ = c.Error(ErrorCredentialsPolicy)
+		c.JSON(http.StatusBadRequest, gin.H{"code": http.StatusBadRequest, "errors": violations})
+		return
+	}
+
 	preparedUser := storage.UserTable{
 		Login:    clientData.Login,
 		Password: clientData.Password,
 	}
 	userID, err := a.s.CreateUser(preparedUser)
 	if err != nil {
+		_ = c.Error(err)
 		if errors.Is(err, storage.ErrorUserAlreadyExists) {
 			c.JSON(http.StatusConflict, gin.H{"code": http.StatusConflict})
 			return
@@ -80,9 +344,8 @@ func (a *App) UserRegisterHandler(c *gin.Context) {
 	}
 
 	if userID > 0 {
-		session := sessions.Default(c)
-		session.Set(a.Config.SessionName, userID)
-		_ = session.Save()
+		a.openSession(c, userID, 0)
+		a.recordLogin(c, userID, clientData.Login, storage.LoginMethodRegister, true, "")
 	}
 
 	c.JSON(http.StatusOK, gin.H{"code": http.StatusOK})
@@ -102,9 +365,13 @@ func (a *App) UserLoginHandler(c *gin.Context) {
 		return
 	}
 
+	clientData.Login = policy.NormalizeLogin(clientData.Login)
 	userID, err := a.s.GetUserIDByCredentials(clientData.Login, clientData.Password)
 	if err != nil {
+		_ = c.Error(err)
 		if errors.Is(err, storage.ErrorUserCredentials) {
+			knownUserID, _ := a.s.GetUserIDByLogin(clientData.Login)
+			a.recordLogin(c, knownUserID, clientData.Login, storage.LoginMethodPassword, false, "invalid_credentials")
 			c.JSON(http.StatusUnauthorized, gin.H{"code": http.StatusUnauthorized})
 			return
 		}
@@ -112,37 +379,34 @@ func (a *App) UserLoginHandler(c *gin.Context) {
 		return
 	}
 
-	if userID > 0 {
-		session := sessions.Default(c)
-		session.Set(a.Config.SessionName, userID)
-		_ = session.Save()
-	}
-
-	c.JSON(http.StatusOK, gin.H{"code": http.StatusOK})
+	a.completeLogin(c, userID, storage.LoginMethodPassword)
 }
 
 func (a *App) CreateUserOrderHandler(c *gin.Context) {
 
-	session := sessions.Default(c)
-	sessionUserID := session.Get(a.Config.SessionName).(int)
+	sessionUserID := a.currentUserID(c)
 
-	var requestOrderNumber int
-	err := c.BindJSON(&requestOrderNumber)
+	requestOrderNumber, err := readOrderNumber(c)
 	if err != nil {
+		_ = c.Error(err)
+		if errors.Is(err, ErrorRequestTooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"code": http.StatusRequestEntityTooLarge})
+			return
+		}
 		c.JSON(http.StatusBadRequest, gin.H{"code": 1})
 		return
 	}
 
-	requestOrderNumberStr := strconv.Itoa(requestOrderNumber)
-	isValidOrderNumber := ValidateLuhn(requestOrderNumber)
+	isValidOrderNumber := luhn.Validate(requestOrderNumber)
 	if !isValidOrderNumber {
+		_ = c.Error(ErrorOrderNumberInvalid)
 		c.JSON(http.StatusUnprocessableEntity, gin.H{"code": http.StatusUnprocessableEntity})
 		return
 	}
 
 	orderData := storage.OrderTable{
 		UserID: sessionUserID,
-		Number: requestOrderNumberStr,
+		Number: requestOrderNumber,
 		Status: storage.OrderStatusNew,
 	}
 
@@ -150,12 +414,13 @@ func (a *App) CreateUserOrderHandler(c *gin.Context) {
 
 	if err != nil {
 		if errors.Is(err, storage.ErrorOrderAlreadyExists) {
-			order, _ := a.s.GetOrderByNumber(requestOrderNumberStr)
+			order, _ := a.s.GetOrderByNumber(requestOrderNumber)
 			if sessionUserID == order.UserID { // заказ есть у текущего пользователя
 				c.JSON(http.StatusOK, gin.H{"code": http.StatusOK})
 				c.Abort()
 				return
 			} else { // заказ есть у другого пользователя
+				_ = c.Error(ErrorOrderConflict)
 				c.JSON(http.StatusConflict, gin.H{"code": http.StatusConflict})
 				c.Abort()
 				return
@@ -167,43 +432,56 @@ func (a *App) CreateUserOrderHandler(c *gin.Context) {
 }
 
 func (a *App) GetUserOrdersHandler(c *gin.Context) {
-	session := sessions.Default(c)
-	sessionUserID := session.Get(a.Config.SessionName).(int)
+	sessionUserID := a.currentUserID(c)
 
-	type ResponseOrderData struct {
-		Number     string  `json:"number"`
-		Status     string  `json:"status"`
-		Accrual    float64 `json:"accrual,omitempty"`
-		UploadedAt string  `json:"uploaded_at"`
-	}
-
-	orders, err := a.s.GetOrdersByUserID(sessionUserID)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"code": http.StatusInternalServerError})
+	orders, ok := a.listOrders(c, sessionUserID)
+	if !ok {
 		return
 	}
-	var result []ResponseOrderData
-	for _, v := range orders {
-		preparedOrder := ResponseOrderData{
-			Number:     v.Number,
-			Status:     v.Status,
-			Accrual:    v.Accrual,
-			UploadedAt: v.UploadedAt.Format(time.RFC3339),
-		}
-		result = append(result, preparedOrder)
-	}
+	result := newResponseOrders(orders)
 	if len(result) > 0 {
 		c.JSON(http.StatusOK, result)
 		return
+	} else if isAPIv2(c) { // v2 отвечает пустым списком вместо 204
+		c.JSON(http.StatusOK, []ResponseOrderData{})
+		return
 	} else {
 		c.JSON(http.StatusNoContent, "")
 		return
 	}
 }
 
+func (a *App) GetUserOrderHandler(c *gin.Context) {
+	sessionUserID := a.currentUserID(c)
+
+	order, err := a.s.GetOrderByNumber(c.Param("number"))
+	if err != nil {
+		_ = c.Error(err)
+		if errors.Is(err, storage.ErrorOrderNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"code": http.StatusNotFound})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"code": http.StatusInternalServerError})
+		return
+	}
+	// чужой заказ неотличим от несуществующего
+	if order.UserID != sessionUserID {
+		_ = c.Error(storage.ErrorOrderNotFound)
+		c.JSON(http.StatusNotFound, gin.H{"code": http.StatusNotFound})
+		return
+	}
+
+	events, err := a.s.GetOrderStatusHistory(order.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": http.StatusInternalServerError})
+		return
+	}
+
+	c.JSON(http.StatusOK, newResponseOrderDetail(order, events))
+}
+
 func (a *App) GetUserBalanceHandler(c *gin.Context) {
-	session := sessions.Default(c)
-	sessionUserID := session.Get(a.Config.SessionName).(int)
+	sessionUserID := a.currentUserID(c)
 	userBalance, err := a.s.GetUserBalance(sessionUserID)
 
 	if err != nil {
@@ -211,18 +489,35 @@ func (a *App) GetUserBalanceHandler(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"current": userBalance.Balance, "withdrawn": userBalance.Withdrawn})
+	result := newResponseBalance(userBalance)
+	if a.Config.PointsTTL > 0 {
+		lots, err := a.s.GetExpiringPoints(sessionUserID, time.Now().UTC().Add(a.Config.PointsExpiringSoon))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"code": http.StatusInternalServerError})
+			return
+		}
+		result.ExpiringSoon = make([]ResponseExpiringPoints, 0, len(lots))
+		for _, v := range lots {
+			result.ExpiringSoon = append(result.ExpiringSoon, ResponseExpiringPoints{
+				Amount:    v.Remaining,
+				Order:     v.OrderNumber,
+				ExpiresAt: v.ExpiresAt.Format(time.RFC3339),
+			})
+		}
+	}
+
+	c.JSON(http.StatusOK, result)
 
 }
 
 func (a *App) CreateUserWithdrawHandler(c *gin.Context) {
 
-	session := sessions.Default(c)
-	sessionUserID := session.Get(a.Config.SessionName).(int)
+	sessionUserID := a.currentUserID(c)
 
 	clientData := struct {
 		OrderNumber string  `json:"order"`
 		Sum         float64 `json:"sum"`
+		TOTPCode    string  `json:"totp_code"`
 	}{}
 
 	err := c.BindJSON(&clientData)
@@ -232,16 +527,21 @@ func (a *App) CreateUserWithdrawHandler(c *gin.Context) {
 		return
 	}
 
-	orderNumberInt, _ := strconv.Atoi(clientData.OrderNumber)
-	isValidOrderNumber := ValidateLuhn(orderNumberInt)
+	isValidOrderNumber := luhn.Validate(clientData.OrderNumber)
 	if !isValidOrderNumber {
+		_ = c.Error(ErrorOrderNumberInvalid)
 		c.JSON(http.StatusBadRequest, gin.H{"code": http.StatusBadRequest})
 		return
 	}
 
+	if !a.requireWithdrawTOTP(c, sessionUserID, clientData.Sum, clientData.TOTPCode) {
+		return
+	}
+
 	err = a.s.CreateUserWithdraw(sessionUserID, clientData.OrderNumber, clientData.Sum)
 
 	if err != nil {
+		_ = c.Error(err)
 		if errors.Is(err, storage.ErrorOrderNotFound) {
 			c.JSON(http.StatusUnprocessableEntity, gin.H{"code": http.StatusUnprocessableEntity})
 			return
@@ -260,29 +560,20 @@ func (a *App) CreateUserWithdrawHandler(c *gin.Context) {
 
 func (a *App) GetUserWithdrawalsHandler(c *gin.Context) {
 
-	session := sessions.Default(c)
-	sessionUserID := session.Get(a.Config.SessionName).(int)
+	sessionUserID := a.currentUserID(c)
 
-	type ResponseWithrawn struct {
-		OrderNumber string  `json:"order"`
-		Sum         float64 `json:"sum"`
-		ProcessedAt string  `json:"processed_at"`
+	withdrawals, ok := a.listWithdrawals(c, sessionUserID)
+	if !ok {
+		return
 	}
 
-	withdrawals, _ := a.s.GetWithdrawListByUserID(sessionUserID)
-
-	var result []ResponseWithrawn
-	for _, v := range withdrawals {
-		preparedOrder := ResponseWithrawn{
-			OrderNumber: v.OrderNumber,
-			Sum:         v.Sum,
-			ProcessedAt: v.ProcessedAt.Format(time.RFC3339),
-		}
-		result = append(result, preparedOrder)
-	}
+	result := newResponseWithdrawals(withdrawals)
 	if len(result) > 0 {
 		c.JSON(http.StatusOK, result)
 		return
+	} else if isAPIv2(c) {
+		c.JSON(http.StatusOK, []ResponseWithdrawal{})
+		return
 	} else {
 		c.AbortWithStatus(http.StatusNoContent)
 		return
@@ -1,26 +1,137 @@
 package app
 
-// Алгоритм_Луна
-func checksum(number int) int {
-	var luhn int
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"io"
+	"strings"
+)
 
-	for i := 0; number > 0; i++ {
-		cur := number % 10
+const (
+	maxOrderNumberBody = 4 << 10
+	maxOrderBatchBody  = 1 << 20
+)
 
-		if i%2 == 0 { // even
-			cur = cur * 2
-			if cur > 9 {
-				cur = cur%10 + cur/10
-			}
+var (
+	ErrorOrderNumberFormat = errors.New("order number must be a string of digits")
+	ErrorRequestTooLarge   = errors.New("request body is too large")
+)
+
+// readLimitedBody читает тело запроса не длиннее limit байт. Более длинное тело не обрезается,
+// а отклоняется целиком: обрезанный хвост изменил бы данные клиента.
+func readLimitedBody(c *gin.Context, limit int64) ([]byte, error) {
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > limit {
+		return nil, ErrorRequestTooLarge
+	}
+	return body, nil
+}
+
+// readOrderNumber читает номер заказа из тела запроса. Основной формат — text/plain,
+// для совместимости принимается JSON-число или JSON-строка. Номер возвращается как есть,
+// без преобразования в число, поэтому ведущие нули сохраняются. Тело длиннее
+// maxOrderNumberBody отклоняется с ErrorRequestTooLarge.
+func readOrderNumber(c *gin.Context) (string, error) {
+	body, err := readLimitedBody(c, maxOrderNumberBody)
+	if err != nil {
+		return "", err
+	}
+
+	number := strings.TrimSpace(string(body))
+	if c.ContentType() == gin.MIMEJSON {
+		decoder := json.NewDecoder(bytes.NewReader(body))
+		decoder.UseNumber()
+		var v interface{}
+		if err = decoder.Decode(&v); err != nil {
+			return "", err
+		}
+		switch n := v.(type) {
+		case json.Number:
+			number = n.String()
+		case string:
+			number = strings.TrimSpace(n)
+		default:
+			return "", ErrorOrderNumberFormat
+		}
+	}
+
+	for _, r := range number {
+		if r < '0' || r > '9' {
+			return "", ErrorOrderNumberFormat
 		}
-		luhn += cur
-		number = number / 10
 	}
-	return luhn % 10
+	if number == "" {
+		return "", ErrorOrderNumberFormat
+	}
+	return number, nil
 }
 
-// Valid check number is valid or not based on Luhn algorithm
+// readOrderNumbers читает список номеров из JSON-массива, CSV или текста по номеру в строке.
+// Значения не проверяются, пустые строки пропускаются. Тело длиннее maxOrderBatchBody
+// отклоняется целиком с ErrorRequestTooLarge, чтобы последний номер не разрезало пополам.
+func readOrderNumbers(c *gin.Context) (numbers []string, err error) {
+	b, err := readLimitedBody(c, maxOrderBatchBody)
+	if err != nil {
+		return nil, err
+	}
+	body := bytes.NewReader(b)
+
+	switch c.ContentType() {
+	case gin.MIMEJSON:
+		decoder := json.NewDecoder(body)
+		decoder.UseNumber()
+		var values []interface{}
+		if err = decoder.Decode(&values); err != nil {
+			return nil, err
+		}
+		for _, v := range values {
+			switch n := v.(type) {
+			case json.Number:
+				numbers = append(numbers, n.String())
+			case string:
+				numbers = append(numbers, strings.TrimSpace(n))
+			default:
+				numbers = append(numbers, fmt.Sprint(v))
+			}
+		}
+	case "text/csv":
+		reader := csv.NewReader(body)
+		reader.FieldsPerRecord = -1
+		reader.TrimLeadingSpace = true
+		records, err := reader.ReadAll()
+		if err != nil {
+			return nil, err
+		}
+		for i, record := range records {
+			// строка заголовка без единой цифры пропускается
+			if i == 0 && !strings.ContainsAny(strings.Join(record, ""), "0123456789") {
+				continue
+			}
+			for _, field := range record {
+				if field = strings.TrimSpace(field); field != "" {
+					numbers = append(numbers, field)
+				}
+			}
+		}
+	default:
+		scanner := bufio.NewScanner(body)
+		for scanner.Scan() {
+			if line := strings.TrimSpace(scanner.Text()); line != "" {
+				numbers = append(numbers, line)
+			}
+		}
+		if err = scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
 
-func ValidateLuhn(number int) bool {
-	return (number%10+checksum(number/10))%10 == 0
+	return numbers, nil
 }
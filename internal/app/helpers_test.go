@@ -0,0 +1,86 @@
+package app
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newBodyContext(contentType, body string) *gin.Context {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("POST", "/", strings.NewReader(body))
+	c.Request.Header.Set("Content-Type", contentType)
+	return c
+}
+
+func TestReadOrderNumber(t *testing.T) {
+	// номер, который после обрезки по лимиту превратился бы в другой валидный номер
+	long := strings.Repeat("0", maxOrderNumberBody-11) + "12345678903" + "7"
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		want        string
+		wantErr     error
+	}{
+		{"plain", "text/plain", "12345678903", "12345678903", nil},
+		{"plain with spaces", "text/plain", " 12345678903\n", "12345678903", nil},
+		{"leading zeros kept", "text/plain", "0012345678903", "0012345678903", nil},
+		{"json number", "application/json", "12345678903", "12345678903", nil},
+		{"json string", "application/json", `"12345678903"`, "12345678903", nil},
+		{"json object", "application/json", `{"number":"12345678903"}`, "", ErrorOrderNumberFormat},
+		{"letters", "text/plain", "1234abc", "", ErrorOrderNumberFormat},
+		{"empty", "text/plain", "", "", ErrorOrderNumberFormat},
+		{"at the limit", "text/plain", long[1:], long[1:], nil},
+		{"over the limit", "text/plain", long, "", ErrorRequestTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readOrderNumber(newBodyContext(tt.contentType, tt.body))
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("readOrderNumber() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("readOrderNumber() = %q, %v, want %q", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadOrderNumbers(t *testing.T) {
+	// тело, у которого лимит пришёлся бы на середину последнего номера
+	tail := "\n12345678903"
+	overflow := strings.Repeat("\n", maxOrderBatchBody-len(tail)+5) + tail
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		want        []string
+		wantErr     error
+	}{
+		{"lines", "text/plain", "12345678903\n\n 9278923470 \n", []string{"12345678903", "9278923470"}, nil},
+		{"json", "application/json", `[12345678903, "9278923470", true]`, []string{"12345678903", "9278923470", "true"}, nil},
+		{"csv with header", "text/csv", "number\n12345678903\n9278923470,\n", []string{"12345678903", "9278923470"}, nil},
+		{"at the limit", "text/plain", overflow[5:], []string{"12345678903"}, nil},
+		{"over the limit", "text/plain", overflow, nil, ErrorRequestTooLarge},
+		{"json over the limit", "application/json", "[" + strings.Repeat(" ", maxOrderBatchBody) + "]", nil, ErrorRequestTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readOrderNumbers(newBodyContext(tt.contentType, tt.body))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("readOrderNumbers() error = %v, want %v", err, tt.wantErr)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("readOrderNumbers() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
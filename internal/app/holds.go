@@ -0,0 +1,184 @@
+package app
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/rainset/gophermart/internal/luhn"
+	"github.com/rainset/gophermart/internal/storage"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	holdListLimit      = 100
+	holdExpiryInterval = time.Minute
+	defaultHoldTTL     = 15 * time.Minute
+)
+
+type ResponseHold struct {
+	ID          int     `json:"id"`
+	OrderNumber string  `json:"order"`
+	Sum         float64 `json:"sum"`
+	Status      string  `json:"status"`
+	CapturedSum float64 `json:"captured_sum,omitempty"`
+	CreatedAt   string  `json:"created_at"`
+	ExpiresAt   string  `json:"expires_at"`
+	ClosedAt    string  `json:"closed_at,omitempty"`
+}
+
+func newResponseHold(hold storage.Hold) ResponseHold {
+	result := ResponseHold{
+		ID:          hold.ID,
+		OrderNumber: hold.OrderNumber,
+		Sum:         hold.Sum,
+		Status:      hold.Status,
+		CapturedSum: hold.CapturedSum,
+		CreatedAt:   hold.CreatedAt.Format(time.RFC3339),
+		ExpiresAt:   hold.ExpiresAt.Format(time.RFC3339),
+	}
+	if hold.ClosedAt != nil {
+		result.ClosedAt = hold.ClosedAt.Format(time.RFC3339)
+	}
+	return result
+}
+
+// respondHoldError отвечает на ошибку операции с резервом.
+func respondHoldError(c *gin.Context, err error) {
+	_ = c.Error(err)
+	switch {
+	case errors.Is(err, storage.ErrorHoldNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"code": http.StatusNotFound})
+	case errors.Is(err, storage.ErrorHoldClosed), errors.Is(err, storage.ErrorHoldExpired):
+		c.JSON(http.StatusConflict, gin.H{"code": http.StatusConflict})
+	case errors.Is(err, storage.ErrorHoldAmount):
+		c.JSON(http.StatusBadRequest, gin.H{"code": http.StatusBadRequest})
+	case errors.Is(err, storage.ErrorUserBalanceWithdraw):
+		c.JSON(http.StatusPaymentRequired, gin.H{"code": http.StatusPaymentRequired})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"code": http.StatusInternalServerError})
+	}
+}
+
+// CreateHoldHandler резервирует баллы в начале оплаты. Резерв действует Config.HoldTTL.
+func (a *App) CreateHoldHandler(c *gin.Context) {
+	sessionUserID := a.currentUserID(c)
+
+	clientData := struct {
+		OrderNumber string  `json:"order"`
+		Sum         float64 `json:"sum"`
+		TOTPCode    string  `json:"totp_code"`
+	}{}
+
+	err := c.BindJSON(&clientData)
+	if err != nil || clientData.Sum <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"code": http.StatusBadRequest})
+		return
+	}
+	if !luhn.Validate(clientData.OrderNumber) {
+		_ = c.Error(ErrorOrderNumberInvalid)
+		c.JSON(http.StatusBadRequest, gin.H{"code": http.StatusBadRequest})
+		return
+	}
+
+	// код 2FA проверяется при резервировании: подтверждение оплаты идёт без участия пользователя
+	if !a.requireWithdrawTOTP(c, sessionUserID, clientData.Sum, clientData.TOTPCode) {
+		return
+	}
+
+	ttl := a.Config.HoldTTL
+	if ttl <= 0 {
+		ttl = defaultHoldTTL
+	}
+	hold, err := a.s.CreateHold(storage.Hold{
+		UserID:      sessionUserID,
+		OrderNumber: clientData.OrderNumber,
+		Sum:         clientData.Sum,
+		ExpiresAt:   time.Now().UTC().Add(ttl),
+	})
+	if err != nil {
+		respondHoldError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, newResponseHold(hold))
+}
+
+func (a *App) GetHoldsHandler(c *gin.Context) {
+	sessionUserID := a.currentUserID(c)
+
+	holds, err := a.s.GetHoldsByUserID(sessionUserID, holdListLimit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": http.StatusInternalServerError})
+		return
+	}
+
+	result := make([]ResponseHold, 0, len(holds))
+	for _, v := range holds {
+		result = append(result, newResponseHold(v))
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// CaptureHoldHandler превращает резерв в списание. Необязательная sum меньше резерва
+// списывает только её, остаток возвращается на баланс.
+func (a *App) CaptureHoldHandler(c *gin.Context) {
+	sessionUserID := a.currentUserID(c)
+
+	holdID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": http.StatusBadRequest})
+		return
+	}
+
+	clientData := struct {
+		Sum float64 `json:"sum"`
+	}{}
+	// тело необязательно
+	if err = c.ShouldBindJSON(&clientData); err != nil && !errors.Is(err, io.EOF) {
+		_ = c.Error(err).SetType(gin.ErrorTypeBind)
+		c.JSON(http.StatusBadRequest, gin.H{"code": http.StatusBadRequest})
+		return
+	}
+
+	hold, err := a.s.CaptureHold(sessionUserID, holdID, clientData.Sum)
+	if err != nil {
+		respondHoldError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, newResponseHold(hold))
+}
+
+func (a *App) VoidHoldHandler(c *gin.Context) {
+	sessionUserID := a.currentUserID(c)
+
+	holdID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": http.StatusBadRequest})
+		return
+	}
+
+	hold, err := a.s.VoidHold(sessionUserID, holdID)
+	if err != nil {
+		respondHoldError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, newResponseHold(hold))
+}
+
+// HoldExpiryServer раз в минуту возвращает баллы по истёкшим резервам.
+func (a *App) HoldExpiryServer() {
+	for {
+		expired, err := a.s.ExpireHolds()
+		if err != nil {
+			log.Println("hold expiry: ", err)
+		} else if expired > 0 {
+			log.Printf("hold expiry: %d holds released", expired)
+		}
+		time.Sleep(holdExpiryInterval)
+	}
+}
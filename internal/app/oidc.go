@@ -0,0 +1,189 @@
+package app
+
+import (
+	"errors"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/rainset/gophermart/internal/oidc"
+	"github.com/rainset/gophermart/internal/oidc/testidp"
+	"github.com/rainset/gophermart/internal/policy"
+	"github.com/rainset/gophermart/internal/storage"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	sessionOIDCState    = "oidc_state"
+	sessionOIDCNonce    = "oidc_nonce"
+	sessionOIDCVerifier = "oidc_verifier"
+	sessionOIDCAt       = "oidc_at"
+	oidcFlowTTL         = 10 * time.Minute
+	oidcLoginAttempts   = 3
+)
+
+type OIDCConfig struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	TestIdP      bool // поднять встроенный тестовый провайдер по адресу Issuer
+}
+
+// mountTestIdP монтирует встроенный тестовый провайдер по пути из Issuer.
+func (a *App) mountTestIdP(r *gin.Engine) {
+	issuer, err := url.Parse(a.Config.OIDC.Issuer)
+	if err != nil || issuer.Path == "" || issuer.Path == "/" {
+		log.Println("OIDC: test IdP needs an issuer with a path, ex:[http://localhost:8080/testidp]")
+		return
+	}
+	idp, err := testidp.New(a.Config.OIDC.Issuer, a.Config.OIDC.ClientID, a.Config.OIDC.ClientSecret)
+	if err != nil {
+		log.Println("OIDC: test IdP: ", err)
+		return
+	}
+	r.Any(issuer.Path+"/*path", gin.WrapH(http.StripPrefix(issuer.Path, idp)))
+	log.Printf("OIDC: test IdP is served at %s", a.Config.OIDC.Issuer)
+}
+
+func (a *App) OIDCLoginHandler(c *gin.Context) {
+	state, err := oidc.RandomString(16)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": http.StatusInternalServerError})
+		return
+	}
+	nonce, err := oidc.RandomString(16)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": http.StatusInternalServerError})
+		return
+	}
+	verifier, challenge, err := oidc.NewPKCE()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": http.StatusInternalServerError})
+		return
+	}
+
+	authURL, err := a.oidc.AuthCodeURL(c.Request.Context(), state, nonce, challenge)
+	if err != nil {
+		log.Println("OIDC: ", err)
+		c.JSON(http.StatusBadGateway, gin.H{"code": http.StatusBadGateway})
+		return
+	}
+
+	session := sessions.Default(c)
+	session.Set(sessionOIDCState, state)
+	session.Set(sessionOIDCNonce, nonce)
+	session.Set(sessionOIDCVerifier, verifier)
+	session.Set(sessionOIDCAt, time.Now().Unix())
+	_ = session.Save()
+
+	c.Redirect(http.StatusFound, authURL)
+}
+
+func (a *App) OIDCCallbackHandler(c *gin.Context) {
+	session := sessions.Default(c)
+	state, _ := session.Get(sessionOIDCState).(string)
+	nonce, _ := session.Get(sessionOIDCNonce).(string)
+	verifier, _ := session.Get(sessionOIDCVerifier).(string)
+	startedAt, _ := session.Get(sessionOIDCAt).(int64)
+
+	session.Delete(sessionOIDCState)
+	session.Delete(sessionOIDCNonce)
+	session.Delete(sessionOIDCVerifier)
+	session.Delete(sessionOIDCAt)
+	_ = session.Save()
+
+	if state == "" || c.Query("state") != state || time.Since(time.Unix(startedAt, 0)) > oidcFlowTTL {
+		c.JSON(http.StatusBadRequest, gin.H{"code": http.StatusBadRequest})
+		return
+	}
+	if c.Query("error") != "" || c.Query("code") == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"code": http.StatusUnauthorized, "error": c.Query("error")})
+		return
+	}
+
+	idToken, err := a.oidc.Exchange(c.Request.Context(), c.Query("code"), verifier)
+	if err != nil {
+		log.Println("OIDC: ", err)
+		c.JSON(http.StatusUnauthorized, gin.H{"code": http.StatusUnauthorized})
+		return
+	}
+	claims, err := a.oidc.VerifyIDToken(c.Request.Context(), idToken, nonce)
+	if err != nil {
+		log.Println("OIDC: ", err)
+		c.JSON(http.StatusUnauthorized, gin.H{"code": http.StatusUnauthorized})
+		return
+	}
+
+	userID, err := a.s.GetUserIDByIdentity(claims.Issuer, claims.Subject)
+	switch {
+	case err == nil:
+	case errors.Is(err, storage.ErrorIdentityNotFound):
+		userID, err = a.linkOrCreateOIDCUser(c, claims)
+		if err != nil {
+			if errors.Is(err, storage.ErrorIdentityLinked) {
+				c.JSON(http.StatusConflict, gin.H{"code": http.StatusConflict})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"code": http.StatusInternalServerError})
+			return
+		}
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"code": http.StatusInternalServerError})
+		return
+	}
+
+	a.completeLogin(c, userID, storage.LoginMethodOIDC)
+}
+
+// sessionUserID возвращает пользователя действующей cookie-сессии, если она есть.
+func (a *App) sessionUserID(c *gin.Context) (int, bool) {
+	session := sessions.Default(c)
+	userID, ok := session.Get(a.Config.SessionName).(int)
+	if !ok {
+		return 0, false
+	}
+	user, err := a.s.GetUserByID(userID)
+	if err != nil {
+		return 0, false
+	}
+	sessionVersion, _ := session.Get(sessionVersionKey).(int)
+	return user.ID, sessionVersion == user.SessionVersion && !user.Frozen
+}
+
+// linkOrCreateOIDCUser привязывает внешнюю учётную запись к пользователю, который уже вошёл,
+// или создаёт нового. Существующий локальный логин с тем же именем не захватывается.
+func (a *App) linkOrCreateOIDCUser(c *gin.Context, claims oidc.Claims) (userID int, err error) {
+	if userID, ok := a.sessionUserID(c); ok {
+		return userID, a.s.LinkUserIdentity(userID, claims.Issuer, claims.Subject)
+	}
+
+	login := policy.NormalizeLogin(claims.PreferredUsername)
+	if login == "" {
+		login = policy.NormalizeLogin(claims.Email)
+	}
+	if login == "" || len(a.policy.ValidateLogin(login)) > 0 {
+		login = "sso-" + storage.GetSHA256Hash(claims.Issuer + "|" + claims.Subject)[:12]
+	}
+
+	// пароль неизвестен никому: войти можно только через провайдера или после сброса пароля
+	password, err := generateToken(32)
+	if err != nil {
+		return 0, err
+	}
+
+	candidate := login
+	for i := 0; i < oidcLoginAttempts; i++ {
+		userID, err = a.s.CreateUserWithIdentity(storage.UserTable{Login: candidate, Password: password}, claims.Issuer, claims.Subject)
+		if !errors.Is(err, storage.ErrorUserAlreadyExists) {
+			return userID, err
+		}
+		suffix, err := generateToken(2)
+		if err != nil {
+			return 0, err
+		}
+		candidate = login + "-" + suffix
+	}
+	return userID, err
+}
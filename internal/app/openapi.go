@@ -0,0 +1,157 @@
+package app
+
+import (
+	"bytes"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+)
+
+const openAPIViolationHeader = "X-OpenAPI-Violation"
+
+var ErrorRequestSchema = errors.New("request does not match the API schema")
+
+func (a *App) OpenAPIHandler(c *gin.Context) {
+	c.Data(http.StatusOK, gin.MIMEJSON, a.spec.JSON())
+}
+
+// openAPIPath переводит шаблон маршрута gin (/api/v2/user/orders/:number) в путь спецификации (/user/orders/{number}).
+func openAPIPath(fullPath string) string {
+	if strings.HasPrefix(fullPath, apiV2Prefix+"/") {
+		fullPath = strings.TrimPrefix(fullPath, apiV2Prefix)
+	} else {
+		fullPath = strings.TrimPrefix(fullPath, "/api")
+	}
+	segments := strings.Split(fullPath, "/")
+	for i, v := range segments {
+		if strings.HasPrefix(v, ":") {
+			segments[i] = "{" + v[1:] + "}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// openAPIRecorder придерживает ответ, чтобы сверить его со спецификацией до отправки клиенту.
+type openAPIRecorder struct {
+	gin.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *openAPIRecorder) WriteHeader(code int) {
+	w.status = code
+}
+
+func (w *openAPIRecorder) WriteHeaderNow() {}
+
+func (w *openAPIRecorder) Write(data []byte) (int, error) {
+	return w.body.Write(data)
+}
+
+func (w *openAPIRecorder) WriteString(s string) (int, error) {
+	return w.body.WriteString(s)
+}
+
+func (w *openAPIRecorder) Status() int {
+	return w.status
+}
+
+func (w *openAPIRecorder) Size() int {
+	return w.body.Len()
+}
+
+func (w *openAPIRecorder) Written() bool {
+	return w.body.Len() > 0
+}
+
+// OpenAPIMiddleware проверяет запросы по спецификации и отвечает 400 со списком нарушений.
+// С Config.OpenAPIValidateResponses сверяются и ответы: нарушения пишутся в лог и в заголовок
+// X-OpenAPI-Violation, ответ при этом не меняется. Это режим для тестов, потоки не проверяются.
+func (a *App) OpenAPIMiddleware(c *gin.Context) {
+	op := a.spec.Operation(c.Request.Method, openAPIPath(c.FullPath()))
+	if op == nil {
+		c.Next()
+		return
+	}
+
+	if a.Config.OpenAPIValidation {
+		var body []byte
+		if c.Request.Body != nil {
+			var err error
+			body, err = io.ReadAll(io.LimitReader(c.Request.Body, maxOrderBatchBody+1))
+			if err != nil {
+				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"code": http.StatusBadRequest})
+				return
+			}
+			c.Request.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), c.Request.Body))
+		}
+
+		// слишком большие тела не проверяются, их отклонит обработчик
+		if len(body) <= maxOrderBatchBody {
+			params := map[string]string{}
+			for _, p := range c.Params {
+				params[p.Key] = p.Value
+			}
+			violations := a.spec.ValidateRequest(op, params, c.Request.URL.Query(), c.ContentType(), body)
+			if len(violations) > 0 {
+				_ = c.Error(ErrorRequestSchema)
+				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"code": http.StatusBadRequest, "errors": violations})
+				return
+			}
+		}
+	}
+
+	if !a.Config.OpenAPIValidateResponses || op.Streaming() {
+		c.Next()
+		return
+	}
+
+	w := &openAPIRecorder{ResponseWriter: c.Writer, status: http.StatusOK}
+	c.Writer = w
+	defer func() {
+		c.Writer = w.ResponseWriter
+		// при панике ответ пишет recovery
+		if r := recover(); r != nil {
+			panic(r)
+		}
+		violations := a.spec.ValidateResponse(op, w.status, w.Header().Get("Content-Type"), w.body.Bytes())
+		if len(violations) > 0 {
+			messages := make([]string, 0, len(violations))
+			for _, v := range violations {
+				messages = append(messages, v.String())
+			}
+			log.Printf("openapi: %s %s -> %d: %s", c.Request.Method, c.FullPath(), w.status, strings.Join(messages, "; "))
+			c.Header(openAPIViolationHeader, strings.Join(messages, "; "))
+		}
+		c.Writer.WriteHeader(w.status)
+		_, _ = c.Writer.Write(w.body.Bytes())
+	}()
+
+	c.Next()
+}
+
+// checkOpenAPISync сверяет маршруты /api с описанными в спецификации. Операции с x-optional
+// могут отсутствовать в роутере, например при выключенном входе через SSO.
+func (a *App) checkOpenAPISync(r *gin.Engine) (missing, undocumented []string) {
+	routes := map[string]bool{}
+	for _, route := range r.Routes() {
+		if !strings.HasPrefix(route.Path, "/api/") || strings.HasPrefix(route.Path, apiV2Prefix+"/") {
+			continue
+		}
+		key := route.Method + " " + openAPIPath(route.Path)
+		routes[key] = true
+		if a.spec.Operation(route.Method, openAPIPath(route.Path)) == nil {
+			undocumented = append(undocumented, key)
+		}
+	}
+	for _, key := range a.spec.Operations() {
+		method, path, _ := strings.Cut(key, " ")
+		if !routes[key] && !a.spec.Operation(method, path).Optional {
+			missing = append(missing, key)
+		}
+	}
+	return missing, undocumented
+}
@@ -0,0 +1,83 @@
+package app
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/rainset/gophermart/internal/luhn"
+	"net/http"
+)
+
+const (
+	OrderUploadAccepted        = "accepted"
+	OrderUploadAlreadyUploaded = "already_uploaded"
+	OrderUploadConflict        = "conflict"
+	OrderUploadInvalid         = "invalid"
+	OrderUploadDuplicate       = "duplicate"
+)
+
+type ResponseOrderUpload struct {
+	Number string `json:"number"`
+	Status string `json:"status"`
+}
+
+func (a *App) CreateUserOrdersBatchHandler(c *gin.Context) {
+	sessionUserID := a.currentUserID(c)
+
+	numbers, err := readOrderNumbers(c)
+	if errors.Is(err, ErrorRequestTooLarge) {
+		_ = c.Error(err)
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"code": http.StatusRequestEntityTooLarge})
+		return
+	}
+	if err != nil || len(numbers) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"code": http.StatusBadRequest})
+		return
+	}
+	if len(numbers) > a.Config.OrderBatchMaxSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"code": http.StatusRequestEntityTooLarge, "max": a.Config.OrderBatchMaxSize})
+		return
+	}
+
+	result := make([]ResponseOrderUpload, len(numbers))
+	seen := make(map[string]bool, len(numbers))
+	var valid []string
+	for i, number := range numbers {
+		result[i].Number = number
+		switch {
+		case !luhn.Validate(number):
+			result[i].Status = OrderUploadInvalid
+		case seen[number]:
+			result[i].Status = OrderUploadDuplicate
+		default:
+			seen[number] = true
+			valid = append(valid, number)
+		}
+	}
+
+	if len(valid) > 0 {
+		uploads, err := a.s.CreateOrders(sessionUserID, valid)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"code": http.StatusInternalServerError})
+			return
+		}
+
+		statuses := make(map[string]string, len(uploads))
+		for _, v := range uploads {
+			switch {
+			case v.Created:
+				statuses[v.Number] = OrderUploadAccepted
+			case v.UserID == sessionUserID:
+				statuses[v.Number] = OrderUploadAlreadyUploaded
+			default:
+				statuses[v.Number] = OrderUploadConflict
+			}
+		}
+		for i := range result {
+			if result[i].Status == "" {
+				result[i].Status = statuses[result[i].Number]
+			}
+		}
+	}
+
+	c.JSON(http.StatusOK, result)
+}
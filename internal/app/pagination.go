@@ -0,0 +1,164 @@
+package app
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/rainset/gophermart/internal/storage"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	listDefaultLimit = 100
+	listMaxLimit     = 1000
+)
+
+var ErrorListQuery = errors.New("invalid list query")
+
+// encodeListCursor упаковывает ключ последней записи страницы в непрозрачную строку.
+func encodeListCursor(at time.Time, id int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d.%d", at.UnixNano(), id)))
+}
+
+func decodeListCursor(cursor string) (*storage.ListCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, ErrorListQuery
+	}
+	at, id, ok := strings.Cut(string(b), ".")
+	if !ok {
+		return nil, ErrorListQuery
+	}
+	nsec, err := strconv.ParseInt(at, 10, 64)
+	if err != nil {
+		return nil, ErrorListQuery
+	}
+	result := storage.ListCursor{At: time.Unix(0, nsec).UTC()}
+	if result.ID, err = strconv.Atoi(id); err != nil {
+		return nil, ErrorListQuery
+	}
+	return &result, nil
+}
+
+// parseListTime принимает RFC 3339 или дату YYYY-MM-DD (начало суток UTC).
+func parseListTime(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return &t, nil
+		}
+	}
+	return nil, ErrorListQuery
+}
+
+// parseListFilter читает limit, cursor, from, to и status из строки запроса.
+// Лимит запрашивается на одну запись больше, чтобы понять, есть ли следующая страница.
+func parseListFilter(c *gin.Context, statuses map[string]bool) (filter storage.ListFilter, limit int, err error) {
+	limit = listDefaultLimit
+	if v := c.Query("limit"); v != "" {
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > listMaxLimit {
+			return filter, 0, ErrorListQuery
+		}
+	}
+	filter.Limit = limit + 1
+
+	if v := c.Query("cursor"); v != "" {
+		if filter.After, err = decodeListCursor(v); err != nil {
+			return filter, 0, err
+		}
+	}
+	if filter.From, err = parseListTime(c.Query("from")); err != nil {
+		return filter, 0, err
+	}
+	if filter.To, err = parseListTime(c.Query("to")); err != nil {
+		return filter, 0, err
+	}
+
+	for _, v := range c.QueryArray("status") {
+		for _, status := range strings.Split(v, ",") {
+			status = strings.ToUpper(strings.TrimSpace(status))
+			if !statuses[status] {
+				return filter, 0, ErrorListQuery
+			}
+			filter.Statuses = append(filter.Statuses, status)
+		}
+	}
+
+	return filter, limit, nil
+}
+
+// setNextPage отдаёт курсор следующей страницы в заголовках X-Next-Cursor и Link,
+// тело ответа остаётся прежним массивом.
+func setNextPage(c *gin.Context, cursor string) {
+	query := c.Request.URL.Query()
+	query.Set("cursor", cursor)
+	next := url.URL{Path: c.Request.URL.Path, RawQuery: query.Encode()}
+
+	c.Header("X-Next-Cursor", cursor)
+	c.Header("Link", fmt.Sprintf("<%s>; rel=\"next\"", next.String()))
+}
+
+var orderListStatuses = map[string]bool{
+	storage.OrderStatusNew:        true,
+	storage.OrderStatusProcessing: true,
+	storage.OrderStatusInvalid:    true,
+	storage.OrderStatusProcessed:  true,
+	storage.OrderStatusReturned:   true,
+}
+
+var withdrawalListStatuses = map[string]bool{
+	storage.WithdrawalStatusCompleted: true,
+	storage.WithdrawalStatusCancelled: true,
+}
+
+// listOrders возвращает страницу заказов пользователя, ответ об ошибке уже отправлен при ok == false.
+func (a *App) listOrders(c *gin.Context, userID int) (orders []storage.OrderTable, ok bool) {
+	filter, limit, err := parseListFilter(c, orderListStatuses)
+	if err != nil {
+		_ = c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{"code": http.StatusBadRequest})
+		return nil, false
+	}
+
+	orders, err = a.s.GetOrdersByUserID(userID, filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": http.StatusInternalServerError})
+		return nil, false
+	}
+	if len(orders) > limit {
+		orders = orders[:limit]
+		last := orders[limit-1]
+		setNextPage(c, encodeListCursor(last.UploadedAt, last.ID))
+	}
+	return orders, true
+}
+
+// listWithdrawals возвращает страницу списаний пользователя, ответ об ошибке уже отправлен при ok == false.
+func (a *App) listWithdrawals(c *gin.Context, userID int) (withdrawals []storage.WithdrawalTable, ok bool) {
+	filter, limit, err := parseListFilter(c, withdrawalListStatuses)
+	if err != nil {
+		_ = c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{"code": http.StatusBadRequest})
+		return nil, false
+	}
+
+	withdrawals, err = a.s.GetWithdrawListByUserID(userID, filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": http.StatusInternalServerError})
+		return nil, false
+	}
+	if len(withdrawals) > limit {
+		withdrawals = withdrawals[:limit]
+		last := withdrawals[limit-1]
+		setNextPage(c, encodeListCursor(last.ProcessedAt, last.ID))
+	}
+	return withdrawals, true
+}
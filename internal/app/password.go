@@ -0,0 +1,154 @@
+package app
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/rainset/gophermart/internal/notify"
+	"github.com/rainset/gophermart/internal/policy"
+	"github.com/rainset/gophermart/internal/storage"
+	"log"
+	"net/http"
+	"time"
+)
+
+const sessionVersionKey = "session_version"
+
+func generateToken(size int) (string, error) {
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func (a *App) ChangePasswordHandler(c *gin.Context) {
+	sessionUserID := a.currentUserID(c)
+
+	clientData := struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}{}
+
+	err := c.BindJSON(&clientData)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": http.StatusBadRequest})
+		return
+	}
+
+	violations := a.policy.ValidatePassword(clientData.NewPassword, a.currentUser(c).Login)
+	if len(violations) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"code": http.StatusBadRequest, "errors": violations})
+		return
+	}
+
+	err = a.s.CheckUserPassword(sessionUserID, clientData.CurrentPassword)
+	if err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"code": http.StatusForbidden})
+		return
+	}
+
+	sessionVersion, err := a.s.UpdateUserPassword(sessionUserID, clientData.NewPassword)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": http.StatusInternalServerError})
+		return
+	}
+
+	// текущая сессия остаётся действительной, остальные отзываются
+	a.openSession(c, sessionUserID, sessionVersion)
+
+	c.JSON(http.StatusOK, gin.H{"code": http.StatusOK})
+}
+
+func (a *App) RequestPasswordResetHandler(c *gin.Context) {
+
+	clientData := struct {
+		Login string `json:"login"`
+	}{}
+
+	err := c.BindJSON(&clientData)
+	if err != nil || clientData.Login == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"code": http.StatusBadRequest})
+		return
+	}
+
+	// ответ не зависит от того, существует ли логин
+	clientData.Login = policy.NormalizeLogin(clientData.Login)
+	userID, err := a.s.GetUserIDByLogin(clientData.Login)
+	if err != nil {
+		if !errors.Is(err, storage.ErrorUserNotFound) {
+			log.Println(err)
+		}
+		c.JSON(http.StatusAccepted, gin.H{"code": http.StatusAccepted})
+		return
+	}
+
+	token, err := generateToken(32)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": http.StatusInternalServerError})
+		return
+	}
+
+	expiresAt := time.Now().Add(a.Config.PasswordResetTTL)
+	err = a.s.CreatePasswordResetToken(userID, token, expiresAt)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": http.StatusInternalServerError})
+		return
+	}
+
+	err = a.Notifier.Notify(notify.Message{
+		UserID:  userID,
+		Login:   clientData.Login,
+		Subject: "Password reset",
+		Body:    fmt.Sprintf("Password reset token: %s (valid until %s)", token, expiresAt.UTC().Format(time.RFC3339)),
+	})
+	if err != nil {
+		log.Println("NOTIFY: ", err)
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"code": http.StatusAccepted})
+}
+
+func (a *App) ConfirmPasswordResetHandler(c *gin.Context) {
+
+	clientData := struct {
+		Token       string `json:"token"`
+		NewPassword string `json:"new_password"`
+	}{}
+
+	err := c.BindJSON(&clientData)
+	if err != nil || clientData.Token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"code": http.StatusBadRequest})
+		return
+	}
+
+	login, err := a.s.GetLoginByResetToken(clientData.Token)
+	if err != nil {
+		if errors.Is(err, storage.ErrorResetTokenInvalid) {
+			c.JSON(http.StatusBadRequest, gin.H{"code": http.StatusBadRequest})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"code": http.StatusInternalServerError})
+		return
+	}
+
+	violations := a.policy.ValidatePassword(clientData.NewPassword, login)
+	if len(violations) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"code": http.StatusBadRequest, "errors": violations})
+		return
+	}
+
+	_, err = a.s.ResetPasswordByToken(clientData.Token, clientData.NewPassword)
+	if err != nil {
+		if errors.Is(err, storage.ErrorResetTokenInvalid) {
+			c.JSON(http.StatusBadRequest, gin.H{"code": http.StatusBadRequest})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"code": http.StatusInternalServerError})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"code": http.StatusOK})
+}
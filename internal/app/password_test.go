@@ -0,0 +1,38 @@
+package app
+
+import (
+	"github.com/rainset/gophermart/internal/storage"
+	"net/http"
+	"testing"
+)
+
+// TestConfirmPasswordReset проверяет, что новый пароль при сбросе проходит ту же политику, что и при
+// регистрации, включая запрет совпадения с логином владельца токена.
+func TestConfirmPasswordReset(t *testing.T) {
+	tests := []struct {
+		name         string
+		token        string
+		password     string
+		status       int
+		wantPassword string
+	}{
+		{"valid", "reset-token", "new-password-1", http.StatusOK, "new-password-1"},
+		{"same as login", "reset-token", "Carol.Example", http.StatusBadRequest, "carol-password-1"},
+		{"too short", "reset-token", "short", http.StatusBadRequest, "carol-password-1"},
+		{"unknown token", "other-token", "new-password-1", http.StatusBadRequest, "carol-password-1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestStore()
+			s.users[4] = storage.UserTable{ID: 4, Login: "carol.example", Role: storage.UserRoleUser}
+			s.passwords[4] = "carol-password-1"
+			s.resets = map[string]int{"reset-token": 4}
+			c := &testClient{t: t, router: newTestRouter(t, s), prefix: "/api", cookies: map[string]*http.Cookie{}}
+
+			c.json("POST", "/user/password/reset/confirm", `{"token":"`+tt.token+`","new_password":"`+tt.password+`"}`, tt.status)
+			if s.passwords[4] != tt.wantPassword {
+				t.Errorf("password = %q, want %q", s.passwords[4], tt.wantPassword)
+			}
+		})
+	}
+}
@@ -0,0 +1,38 @@
+package app
+
+import (
+	"log"
+	"time"
+)
+
+const (
+	pointsExpiryInterval   = time.Hour
+	pointsMaturityInterval = time.Minute * 10
+)
+
+// PointsExpiryServer раз в час списывает сгоревшие баллы. Партии без срока не сгорают,
+// поэтому при выключенном сгорании задача ничего не делает.
+func (a *App) PointsExpiryServer() {
+	for {
+		expired, err := a.s.ExpirePoints()
+		if err != nil {
+			log.Println("points expiry: ", err)
+		} else if expired > 0 {
+			log.Printf("points expiry: %d lots expired", expired)
+		}
+		time.Sleep(pointsExpiryInterval)
+	}
+}
+
+// PendingPointsServer переводит начисления с истёкшим периодом ожидания в доступный баланс.
+func (a *App) PendingPointsServer() {
+	for {
+		matured, err := a.s.MaturePoints()
+		if err != nil {
+			log.Println("pending points: ", err)
+		} else if matured > 0 {
+			log.Printf("pending points: %d lots available", matured)
+		}
+		time.Sleep(pointsMaturityInterval)
+	}
+}
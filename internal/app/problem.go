@@ -0,0 +1,212 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/rainset/gophermart/internal/storage"
+	"github.com/rainset/gophermart/internal/webhook"
+	"net/http"
+)
+
+const (
+	apiV2Prefix          = "/api/v2"
+	contextAPIVersionKey = "api_version"
+	problemTypePrefix    = "urn:gophermart:problem:"
+	problemContentType   = "application/problem+json"
+)
+
+var (
+	ErrorOrderNumberInvalid = errors.New("order number fails the luhn check")
+	ErrorOrderConflict      = errors.New("order was uploaded by another user")
+	ErrorCredentialsPolicy  = errors.New("login or password violates the policy")
+	ErrorTOTPRequired       = errors.New("one-time code required")
+)
+
+// problemCodes — единственное место, где ошибки сопоставляются с кодами ответов /api/v2.
+// Коды стабильны: клиенты разбирают их вместо текста. Порядок важен, побеждает первое совпадение.
+var problemCodes = []struct {
+	err  error
+	code string
+}{
+	{storage.ErrorUserAlreadyExists, "login_taken"},
+	{storage.ErrorUserCredentials, "invalid_credentials"},
+	{storage.ErrorUserNotFound, "user_not_found"},
+	{storage.ErrorUserFrozen, "account_frozen"},
+	{storage.ErrorResetTokenInvalid, "reset_token_invalid"},
+	{storage.ErrorAPIKeyNotFound, "api_key_not_found"},
+	{storage.ErrorWebhookNotFound, "webhook_not_found"},
+	{storage.ErrorIdentityNotFound, "identity_not_found"},
+	{storage.ErrorIdentityLinked, "identity_linked"},
+	{storage.ErrorUserBalanceWithdraw, "insufficient_funds"},
+	{storage.ErrorOrderAlreadyExists, "order_already_exists"},
+	{storage.ErrorOrderNotFound, "order_not_found"},
+	{storage.ErrorOrderNotReturnable, "order_not_returnable"},
+	{storage.ErrorOrderFinal, "order_status_final"},
+	{storage.ErrorReturnAmount, "return_amount_exceeded"},
+	{storage.ErrorWithdrawalNotFound, "withdrawal_not_found"},
+	{storage.ErrorWithdrawalCancelled, "withdrawal_already_cancelled"},
+	{storage.ErrorWithdrawalCancelWindow, "withdrawal_cancel_window_passed"},
+	{storage.ErrorHoldNotFound, "hold_not_found"},
+	{storage.ErrorHoldClosed, "hold_closed"},
+	{storage.ErrorHoldExpired, "hold_expired"},
+	{storage.ErrorHoldAmount, "hold_amount_exceeded"},
+	{storage.ErrorTOTPAlreadyEnabled, "two_factor_already_enabled"},
+	{storage.ErrorTOTPNotEnrolled, "two_factor_not_enrolled"},
+	{storage.ErrorTOTPCodeReused, "one_time_code_reused"},
+	{storage.ErrorRecoveryCodeInvalid, "recovery_code_invalid"},
+	{ErrorOrderNumberFormat, "order_number_format"},
+	{ErrorOrderNumberInvalid, "order_number_invalid"},
+	{ErrorRequestTooLarge, "payload_too_large"},
+	{ErrorOrderConflict, "order_owned_by_another_user"},
+	{ErrorCredentialsPolicy, "credentials_policy"},
+	{ErrorTOTPCodeInvalid, "one_time_code_invalid"},
+	{ErrorTOTPLocked, "two_factor_locked"},
+	{ErrorTOTPRequired, "two_factor_required"},
+	{ErrorWithdrawalCancelDisabled, "withdrawal_cancel_disabled"},
+	{ErrorListQuery, "invalid_query"},
+	{ErrorRequestSchema, "request_schema_violation"},
+	{webhook.ErrorURL, "webhook_url_invalid"},
+	{webhook.ErrorPrivateHost, "webhook_url_private"},
+}
+
+// statusProblemCodes используются, когда обработчик не сообщил причину.
+var statusProblemCodes = map[int]string{
+	http.StatusBadRequest:            "bad_request",
+	http.StatusUnauthorized:          "unauthorized",
+	http.StatusPaymentRequired:       "insufficient_funds",
+	http.StatusForbidden:             "forbidden",
+	http.StatusNotFound:              "not_found",
+	http.StatusMethodNotAllowed:      "method_not_allowed",
+	http.StatusConflict:              "conflict",
+	http.StatusRequestEntityTooLarge: "payload_too_large",
+	http.StatusUnsupportedMediaType:  "unsupported_media_type",
+	http.StatusUnprocessableEntity:   "unprocessable_entity",
+	http.StatusTooManyRequests:       "too_many_requests",
+	http.StatusInternalServerError:   "internal_error",
+	http.StatusServiceUnavailable:    "service_unavailable",
+}
+
+// problemCode ищет код по последней ошибке, добавленной через c.Error. Текст известных
+// ошибок отдаётся в detail, текст остальных (например, ошибок базы) наружу не попадает.
+func problemCode(status int, errs []*gin.Error) (code, detail string) {
+	for i := len(errs) - 1; i >= 0; i-- {
+		for _, v := range problemCodes {
+			if errors.Is(errs[i].Err, v.err) {
+				return v.code, v.err.Error()
+			}
+		}
+		if errs[i].IsType(gin.ErrorTypeBind) {
+			return "invalid_request_body", errs[i].Error()
+		}
+	}
+	if code, ok := statusProblemCodes[status]; ok {
+		return code, ""
+	}
+	if status >= http.StatusInternalServerError {
+		return "internal_error", ""
+	}
+	return "error", ""
+}
+
+func isAPIv2(c *gin.Context) bool {
+	return c.GetInt(contextAPIVersionKey) == 2
+}
+
+// writeProblem отвечает документом RFC 7807. Поля из исходного тела ответа v1
+// (например, two_factor_required или errors) переносятся как расширения.
+func writeProblem(c *gin.Context, status int, body []byte) {
+	problem := map[string]interface{}{}
+	_ = json.Unmarshal(body, &problem)
+	delete(problem, "code")
+	delete(problem, "err")
+
+	code, detail := problemCode(status, c.Errors)
+	problem["type"] = problemTypePrefix + code
+	problem["title"] = http.StatusText(status)
+	problem["status"] = status
+	problem["code"] = code
+	problem["instance"] = c.Request.URL.Path
+	if detail != "" {
+		problem["detail"] = detail
+	}
+
+	b, err := json.Marshal(problem)
+	if err != nil {
+		b = []byte(`{"status":500,"code":"internal_error"}`)
+	}
+	c.Header("Content-Type", problemContentType)
+	c.Writer.WriteHeader(status)
+	_, _ = c.Writer.Write(b)
+}
+
+// problemWriter придерживает ответы с ошибкой, чтобы ProblemMiddleware заменил их на problem+json.
+// Успешные ответы, включая потоки, проходят без буферизации.
+type problemWriter struct {
+	gin.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *problemWriter) failed() bool {
+	return w.status >= http.StatusBadRequest
+}
+
+func (w *problemWriter) WriteHeader(code int) {
+	w.status = code
+	if !w.failed() {
+		w.ResponseWriter.WriteHeader(code)
+	}
+}
+
+func (w *problemWriter) WriteHeaderNow() {
+	if !w.failed() {
+		w.ResponseWriter.WriteHeaderNow()
+	}
+}
+
+func (w *problemWriter) Write(data []byte) (int, error) {
+	if w.failed() {
+		return w.body.Write(data)
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *problemWriter) WriteString(s string) (int, error) {
+	if w.failed() {
+		return w.body.WriteString(s)
+	}
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (w *problemWriter) Status() int {
+	if w.failed() {
+		return w.status
+	}
+	return w.ResponseWriter.Status()
+}
+
+func (w *problemWriter) Written() bool {
+	return w.failed() || w.ResponseWriter.Written()
+}
+
+// ProblemMiddleware включает для группы /api/v2 ответы об ошибках в формате application/problem+json.
+func (a *App) ProblemMiddleware(c *gin.Context) {
+	c.Set(contextAPIVersionKey, 2)
+
+	w := &problemWriter{ResponseWriter: c.Writer}
+	c.Writer = w
+	defer func() {
+		c.Writer = w.ResponseWriter
+		// при панике ответ пишет recovery
+		if r := recover(); r != nil {
+			panic(r)
+		}
+		if w.failed() {
+			writeProblem(c, w.status, w.body.Bytes())
+		}
+	}()
+
+	c.Next()
+}
@@ -0,0 +1,112 @@
+package app
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/rainset/gophermart/internal/storage"
+	"net/http"
+	"strconv"
+)
+
+type Permission string
+
+const (
+	PermissionUsersRead     Permission = "users.read"
+	PermissionUsersWrite    Permission = "users.write"
+	PermissionRolesManage   Permission = "roles.manage"
+	PermissionOrdersManage  Permission = "orders.manage"
+	PermissionBalanceAdjust Permission = "balance.adjust"
+	PermissionAuditRead     Permission = "audit.read"
+	PermissionOrdersReturn  Permission = "orders.return"
+)
+
+const contextUserKey = "user"
+
+var rolePermissions = map[string][]Permission{
+	storage.UserRoleUser:    {},
+	storage.UserRoleSupport: {PermissionUsersRead},
+	// магазин только оформляет возвраты покупок
+	storage.UserRoleMerchant: {PermissionOrdersReturn},
+	storage.UserRoleAdmin: {
+		PermissionUsersRead,
+		PermissionUsersWrite,
+		PermissionRolesManage,
+		PermissionOrdersManage,
+		PermissionBalanceAdjust,
+		PermissionAuditRead,
+		PermissionOrdersReturn,
+	},
+}
+
+func RoleHasPermission(role string, permission Permission) bool {
+	for _, p := range rolePermissions[role] {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+func IsValidRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// currentUser возвращает пользователя, загруженного AuthMiddleware.
+func (a *App) currentUser(c *gin.Context) storage.UserTable {
+	user, _ := c.MustGet(contextUserKey).(storage.UserTable)
+	return user
+}
+
+func (a *App) currentUserID(c *gin.Context) int {
+	return a.currentUser(c).ID
+}
+
+// RequirePermission пропускает запрос, только если роль пользователя даёт право permission.
+// Должен стоять после AuthMiddleware.
+func (a *App) RequirePermission(permission Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !RoleHasPermission(a.currentUser(c).Role, permission) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"code": http.StatusForbidden})
+			return
+		}
+		c.Next()
+	}
+}
+
+func (a *App) AdminSetUserRoleHandler(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": http.StatusBadRequest})
+		return
+	}
+
+	clientData := struct {
+		Role string `json:"role"`
+	}{}
+
+	err = c.BindJSON(&clientData)
+	if err != nil || !IsValidRole(clientData.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{"code": http.StatusBadRequest})
+		return
+	}
+
+	// администратор не может снять роль с самого себя, иначе можно остаться без администраторов
+	if userID == a.currentUserID(c) && clientData.Role != storage.UserRoleAdmin {
+		c.JSON(http.StatusConflict, gin.H{"code": http.StatusConflict})
+		return
+	}
+
+	err = a.s.SetUserRole(userID, clientData.Role)
+	if err != nil {
+		_ = c.Error(err)
+		if errors.Is(err, storage.ErrorUserNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"code": http.StatusNotFound})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"code": http.StatusInternalServerError})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"code": http.StatusOK})
+}
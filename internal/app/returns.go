@@ -0,0 +1,92 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/rainset/gophermart/internal/storage"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ClawbackPolicy определяет, что делать, если при возврате на балансе не хватает баллов для отката начисления.
+type ClawbackPolicy string
+
+const (
+	ClawbackPolicyRecover  ClawbackPolicy = "recover"  // баланс не уходит ниже нуля, остаток гасится из следующих начислений
+	ClawbackPolicyNegative ClawbackPolicy = "negative" // баланс уходит в минус
+)
+
+func ParseClawbackPolicy(s string) (ClawbackPolicy, error) {
+	switch policy := ClawbackPolicy(strings.ToLower(s)); policy {
+	case "":
+		return ClawbackPolicyRecover, nil
+	case ClawbackPolicyRecover, ClawbackPolicyNegative:
+		return policy, nil
+	}
+	return "", fmt.Errorf("unknown clawback policy %q", s)
+}
+
+type ResponseOrderReturn struct {
+	Order       string  `json:"order"`
+	Status      string  `json:"status"`
+	Amount      float64 `json:"amount"`
+	FromPending float64 `json:"from_pending"`
+	FromExpired float64 `json:"from_expired"`
+	FromBalance float64 `json:"from_balance"`
+	Debt        float64 `json:"debt"`
+	Returned    float64 `json:"returned"`
+	CreatedAt   string  `json:"created_at"`
+}
+
+// AdminReturnOrderHandler отмечает покупку возвращённой и откатывает начисление за неё.
+// amount — сколько баллов откатить при частичном возврате, без него откатывается всё оставшееся.
+func (a *App) AdminReturnOrderHandler(c *gin.Context) {
+	clientData := struct {
+		Amount float64 `json:"amount"`
+		Reason string  `json:"reason"`
+	}{}
+
+	err := c.BindJSON(&clientData)
+	clientData.Reason = strings.TrimSpace(clientData.Reason)
+	if err != nil || clientData.Amount < 0 || clientData.Reason == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"code": http.StatusBadRequest})
+		return
+	}
+	setAuditDetails(c, clientData)
+
+	result, err := a.s.ReturnOrder(storage.OrderReturn{
+		OrderNumber:   c.Param("number"),
+		Amount:        clientData.Amount,
+		ActorID:       a.currentUserID(c),
+		Reason:        clientData.Reason,
+		AllowNegative: a.Config.ClawbackPolicy == ClawbackPolicyNegative,
+	})
+	if err != nil {
+		_ = c.Error(err)
+		switch {
+		case errors.Is(err, storage.ErrorOrderNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"code": http.StatusNotFound})
+		case errors.Is(err, storage.ErrorOrderNotReturnable):
+			c.JSON(http.StatusConflict, gin.H{"code": http.StatusConflict})
+		case errors.Is(err, storage.ErrorReturnAmount):
+			c.JSON(http.StatusBadRequest, gin.H{"code": http.StatusBadRequest})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"code": http.StatusInternalServerError})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, ResponseOrderReturn{
+		Order:       result.OrderNumber,
+		Status:      storage.OrderStatusReturned,
+		Amount:      result.Amount,
+		FromPending: result.FromPending,
+		FromExpired: result.FromExpired,
+		FromBalance: result.FromBalance,
+		Debt:        result.Debt,
+		Returned:    result.Returned,
+		CreatedAt:   result.CreatedAt.Format(time.RFC3339),
+	})
+}
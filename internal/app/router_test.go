@@ -0,0 +1,208 @@
+package app
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/rainset/gophermart/internal/policy"
+	"github.com/rainset/gophermart/internal/secrets"
+	"github.com/rainset/gophermart/internal/storage"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testClient ходит в роутер как браузер: хранит cookie сессии между запросами.
+type testClient struct {
+	t       *testing.T
+	router  *gin.Engine
+	prefix  string
+	cookies map[string]*http.Cookie
+}
+
+// newTestApp создаёт приложение с проверкой запросов и ответов по спецификации.
+func newTestApp(t *testing.T, s *fakeStore) *App {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	pair, err := secrets.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return New(s, Config{
+		SessionName:              "userID",
+		SessionKeys:              []secrets.KeyPair{pair},
+		SessionMaxAge:            3600,
+		SessionCookie:            CookieConfig{Path: "/", HttpOnly: true, SameSite: http.SameSiteLaxMode},
+		Policy:                   policy.Config{LoginMinLength: 3, PasswordMinLength: 8},
+		OpenAPIValidation:        true,
+		OpenAPIValidateResponses: true,
+		PointsTTL:                365 * 24 * time.Hour,
+	})
+}
+
+func newTestRouter(t *testing.T, s *fakeStore) *gin.Engine {
+	t.Helper()
+	return newTestApp(t, s).NewRouter()
+}
+
+// do отправляет запрос и проверяет код ответа и то, что ответ соответствует спецификации.
+func (c *testClient) do(method, path, contentType, body string, wantStatus int) *httptest.ResponseRecorder {
+	c.t.Helper()
+	req := httptest.NewRequest(method, c.prefix+path, strings.NewReader(body))
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	for _, v := range c.cookies {
+		req.AddCookie(v)
+	}
+	w := httptest.NewRecorder()
+	c.router.ServeHTTP(w, req)
+
+	for _, v := range w.Result().Cookies() {
+		c.cookies[v.Name] = v
+	}
+	if w.Code != wantStatus {
+		c.t.Errorf("%s %s: status %d, want %d, body %s", method, c.prefix+path, w.Code, wantStatus, w.Body.String())
+	}
+	if violation := w.Header().Get(openAPIViolationHeader); violation != "" {
+		c.t.Errorf("%s %s: response does not match the spec: %s", method, c.prefix+path, violation)
+	}
+	return w
+}
+
+func (c *testClient) json(method, path, body string, wantStatus int) *httptest.ResponseRecorder {
+	c.t.Helper()
+	return c.do(method, path, "application/json", body, wantStatus)
+}
+
+func newTestStore() *fakeStore {
+	return &fakeStore{
+		users: map[int]storage.UserTable{
+			1: {ID: 1, Login: "alice", Role: storage.UserRoleUser},
+			2: {ID: 2, Login: "root", Role: storage.UserRoleAdmin},
+			3: {ID: 3, Login: "bob", Role: storage.UserRoleUser},
+		},
+		passwords: map[int]string{1: "alice-password-1", 2: "root-password-1", 3: "bob-password-1"},
+		orders: []storage.OrderTable{
+			{ID: 1, UserID: 3, Number: "9278923470", Status: storage.OrderStatusProcessed, Accrual: 100, UploadedAt: time.Now().Add(-time.Hour)},
+		},
+		balance:      storage.UserBalance{Balance: 500, Withdrawn: 20},
+		totp:         map[int]storage.UserTOTP{},
+		totpFailures: map[int][]time.Time{},
+	}
+}
+
+// TestRouterMatchesSpec проходит по документированным эндпоинтам v1 и v2 и сверяет ответы со спецификацией.
+func TestRouterMatchesSpec(t *testing.T) {
+	for _, prefix := range []string{"/api", apiV2Prefix} {
+		t.Run(prefix, func(t *testing.T) {
+			v2 := prefix == apiV2Prefix
+			emptyList := http.StatusNoContent
+			if v2 {
+				emptyList = http.StatusOK
+			}
+
+			c := &testClient{t: t, router: newTestRouter(t, newTestStore()), prefix: prefix, cookies: map[string]*http.Cookie{}}
+
+			c.json("GET", "/openapi.json", "", http.StatusOK)
+			c.json("GET", "/user/balance", "", http.StatusUnauthorized)
+
+			c.json("POST", "/user/register", `{"login":"Carol","password":"carol-password-1"}`, http.StatusOK)
+			c.json("POST", "/user/register", `{"login":"carol","password":"carol-password-1"}`, http.StatusConflict)
+			c.json("POST", "/user/register", `{"login":"dave","password":"1"}`, http.StatusBadRequest)
+
+			c.json("POST", "/user/login", `{"login":"alice","password":"wrong-password"}`, http.StatusUnauthorized)
+			c.json("POST", "/user/login", `{"login":" Alice ","password":"alice-password-1"}`, http.StatusOK)
+
+			c.json("GET", "/user/orders", "", emptyList)
+			c.do("POST", "/user/orders", "text/plain", "12345678903", http.StatusAccepted)
+			c.do("POST", "/user/orders", "text/plain", "12345678903", http.StatusOK)
+			c.do("POST", "/user/orders", "text/plain", "9278923470", http.StatusConflict)
+			c.do("POST", "/user/orders", "text/plain", "12345678904", http.StatusUnprocessableEntity)
+			c.json("GET", "/user/orders", "", http.StatusOK)
+			c.json("GET", "/user/orders/12345678903", "", http.StatusOK)
+			c.json("GET", "/user/orders/9278923470", "", http.StatusNotFound)
+
+			c.json("GET", "/user/balance", "", http.StatusOK)
+			c.json("GET", "/user/withdrawals", "", emptyList)
+			c.json("POST", "/user/balance/withdraw", `{"order":"2377225624","sum":10}`, http.StatusOK)
+			c.json("POST", "/user/balance/withdraw", `{"order":"346436439","sum":10000}`, http.StatusPaymentRequired)
+			c.json("GET", "/user/withdrawals", "", http.StatusOK)
+			c.json("GET", "/user/balance/statement", "", http.StatusOK)
+			c.json("GET", "/user/balance/statement?from=2024-02-01T00:00:00Z&to=2024-01-01T00:00:00Z", "", http.StatusBadRequest)
+
+			c.json("GET", "/admin/users/1", "", http.StatusForbidden)
+
+			c.json("POST", "/user/login", `{"login":"root","password":"root-password-1"}`, http.StatusOK)
+			c.json("GET", "/admin/users/1", "", http.StatusOK)
+			c.json("GET", "/admin/users/999", "", http.StatusNotFound)
+			c.json("POST", "/admin/orders/9278923470/return", `{"reason":"возврат покупки"}`, http.StatusOK)
+			c.json("POST", "/admin/orders/9278923470/return", `{"reason":"  "}`, http.StatusBadRequest)
+		})
+	}
+}
+
+func TestRouterAdminOrderErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		store      func(s *fakeStore)
+		method     string
+		path       string
+		body       string
+		wantStatus int
+	}{
+		{"final status", func(s *fakeStore) { s.orderStatusErr = storage.ErrorOrderFinal },
+			"PUT", "/admin/orders/9278923470/status", `{"status":"NEW"}`, http.StatusConflict},
+		{"status of unknown order", func(s *fakeStore) { s.orderStatusErr = storage.ErrorOrderNotFound },
+			"PUT", "/admin/orders/12345678903/status", `{"status":"NEW"}`, http.StatusNotFound},
+		{"not returnable", func(s *fakeStore) { s.returnErr = storage.ErrorOrderNotReturnable },
+			"POST", "/admin/orders/9278923470/return", `{"reason":"x"}`, http.StatusConflict},
+		{"return more than accrued", func(s *fakeStore) { s.returnErr = storage.ErrorReturnAmount },
+			"POST", "/admin/orders/9278923470/return", `{"amount":500,"reason":"x"}`, http.StatusBadRequest},
+	}
+	for _, prefix := range []string{"/api", apiV2Prefix} {
+		for _, tt := range tests {
+			t.Run(prefix+" "+tt.name, func(t *testing.T) {
+				s := newTestStore()
+				tt.store(s)
+				c := &testClient{t: t, router: newTestRouter(t, s), prefix: prefix, cookies: map[string]*http.Cookie{}}
+				c.json("POST", "/user/login", `{"login":"root","password":"root-password-1"}`, http.StatusOK)
+				w := c.json(tt.method, tt.path, tt.body, tt.wantStatus)
+				if prefix == apiV2Prefix && !strings.HasPrefix(w.Header().Get("Content-Type"), "application/problem+json") {
+					t.Errorf("v2 error content type %q", w.Header().Get("Content-Type"))
+				}
+				if len(s.audit) != 1 || s.audit[0].Status != tt.wantStatus {
+					t.Errorf("audit log %+v", s.audit)
+				}
+			})
+		}
+	}
+}
+
+func TestRouterOpenAPISync(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	a := New(newTestStore(), Config{SessionName: "userID"})
+	missing, undocumented := a.checkOpenAPISync(a.NewRouter())
+	if len(missing) > 0 {
+		t.Errorf("documented but not routed: %v", missing)
+	}
+	if len(undocumented) > 0 {
+		t.Errorf("routed but not documented: %v", undocumented)
+	}
+}
+
+func TestRouterOrderBodyTooLarge(t *testing.T) {
+	body := strings.Repeat("0", maxOrderNumberBody) + "12345678903"
+	for _, prefix := range []string{"/api", apiV2Prefix} {
+		t.Run(prefix, func(t *testing.T) {
+			s := newTestStore()
+			c := &testClient{t: t, router: newTestRouter(t, s), prefix: prefix, cookies: map[string]*http.Cookie{}}
+			c.json("POST", "/user/login", `{"login":"alice","password":"alice-password-1"}`, http.StatusOK)
+			c.do("POST", "/user/orders", "text/plain", body, http.StatusRequestEntityTooLarge)
+			c.do("POST", "/user/orders/batch", "text/plain", strings.Repeat("\n", maxOrderBatchBody)+"12345678903", http.StatusRequestEntityTooLarge)
+			if len(s.orders) != 1 {
+				t.Errorf("orders stored: %+v", s.orders[1:])
+			}
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package app
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/rainset/gophermart/internal/notify"
+	"github.com/rainset/gophermart/internal/storage"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	loginHistoryDefaultLimit = 50
+	loginHistoryMaxLimit     = 500
+)
+
+// deviceFingerprint — грубый отпечаток устройства по заголовкам браузера.
+// IP в него не входит: у мобильных клиентов он меняется постоянно.
+func deviceFingerprint(c *gin.Context) string {
+	return storage.GetSHA256Hash(c.Request.UserAgent() + "|" + c.GetHeader("Accept-Language"))[:32]
+}
+
+// recordLogin пишет попытку входа в историю и предупреждает о входе с нового устройства.
+func (a *App) recordLogin(c *gin.Context, userID int, login, method string, success bool, reason string) {
+	event := storage.LoginEvent{
+		UserID:      userID,
+		Login:       login,
+		Success:     success,
+		Method:      method,
+		Reason:      reason,
+		IP:          c.ClientIP(),
+		UserAgent:   c.Request.UserAgent(),
+		Fingerprint: deviceFingerprint(c),
+	}
+
+	newDevice, err := a.s.CreateLoginEvent(event)
+	if err != nil {
+		log.Println("DB: login event: ", err)
+		return
+	}
+	if !newDevice {
+		return
+	}
+
+	err = a.Notifier.Notify(notify.Message{
+		UserID:  userID,
+		Login:   login,
+		Subject: "New sign-in to your account",
+		Body: fmt.Sprintf("Sign-in from a new device at %s: %s, IP %s. If it was not you, change your password.",
+			time.Now().UTC().Format(time.RFC3339), event.UserAgent, event.IP),
+	})
+	if err != nil {
+		log.Println("NOTIFY: ", err)
+	}
+}
+
+func (a *App) GetLoginHistoryHandler(c *gin.Context) {
+	sessionUserID := a.currentUserID(c)
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(loginHistoryDefaultLimit)))
+	if err != nil || limit <= 0 || limit > loginHistoryMaxLimit {
+		c.JSON(http.StatusBadRequest, gin.H{"code": http.StatusBadRequest})
+		return
+	}
+
+	type ResponseLoginEvent struct {
+		Success   bool   `json:"success"`
+		Method    string `json:"method"`
+		Reason    string `json:"reason,omitempty"`
+		IP        string `json:"ip"`
+		UserAgent string `json:"user_agent"`
+		NewDevice bool   `json:"new_device"`
+		CreatedAt string `json:"created_at"`
+	}
+
+	events, err := a.s.GetLoginEventsByUserID(sessionUserID, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": http.StatusInternalServerError})
+		return
+	}
+
+	result := make([]ResponseLoginEvent, 0, len(events))
+	for _, v := range events {
+		result = append(result, ResponseLoginEvent{
+			Success:   v.Success,
+			Method:    v.Method,
+			Reason:    v.Reason,
+			IP:        v.IP,
+			UserAgent: v.UserAgent,
+			NewDevice: v.NewDevice,
+			CreatedAt: v.CreatedAt.Format(time.RFC3339),
+		})
+	}
+	c.JSON(http.StatusOK, result)
+}
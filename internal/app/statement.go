@@ -0,0 +1,83 @@
+package app
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/rainset/gophermart/internal/storage"
+	"net/http"
+	"time"
+)
+
+type ResponseStatementLine struct {
+	Date    string  `json:"date"`
+	Type    string  `json:"type"`
+	Order   string  `json:"order,omitempty"`
+	Reason  string  `json:"reason,omitempty"`
+	Amount  float64 `json:"amount"`
+	Balance float64 `json:"balance"`
+}
+
+type ResponseStatement struct {
+	From           string                  `json:"from,omitempty"`
+	To             string                  `json:"to,omitempty"`
+	OpeningBalance float64                 `json:"opening_balance"`
+	ClosingBalance float64                 `json:"closing_balance"`
+	Lines          []ResponseStatementLine `json:"lines"`
+}
+
+func newResponseStatement(statement storage.Statement, from, to *time.Time) ResponseStatement {
+	result := ResponseStatement{
+		OpeningBalance: statement.Opening,
+		ClosingBalance: statement.Closing,
+		Lines:          make([]ResponseStatementLine, 0, len(statement.Lines)),
+	}
+	if from != nil {
+		result.From = from.Format(time.RFC3339)
+	}
+	if to != nil {
+		result.To = to.Format(time.RFC3339)
+	}
+	for _, v := range statement.Lines {
+		line := ResponseStatementLine{
+			Date:    v.At.Format(time.RFC3339),
+			Type:    v.Type,
+			Amount:  v.Amount,
+			Balance: v.Balance,
+		}
+		if v.Type == storage.StatementLineAdjustment {
+			line.Reason = v.Ref
+		} else {
+			line.Order = v.Ref
+		}
+		result.Lines = append(result.Lines, line)
+	}
+	return result
+}
+
+// statementPeriod читает from и to из запроса, ответ об ошибке уже отправлен при ok == false.
+func statementPeriod(c *gin.Context) (from, to *time.Time, ok bool) {
+	from, errFrom := parseListTime(c.Query("from"))
+	to, errTo := parseListTime(c.Query("to"))
+	if errFrom != nil || errTo != nil || (from != nil && to != nil && !from.Before(*to)) {
+		_ = c.Error(ErrorListQuery)
+		c.JSON(http.StatusBadRequest, gin.H{"code": http.StatusBadRequest})
+		return nil, nil, false
+	}
+	return from, to, true
+}
+
+func (a *App) GetUserStatementHandler(c *gin.Context) {
+	sessionUserID := a.currentUserID(c)
+
+	from, to, ok := statementPeriod(c)
+	if !ok {
+		return
+	}
+
+	statement, err := a.s.GetStatement(sessionUserID, from, to)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": http.StatusInternalServerError})
+		return
+	}
+
+	c.JSON(http.StatusOK, newResponseStatement(statement, from, to))
+}
@@ -0,0 +1,264 @@
+package app
+
+import (
+	"github.com/rainset/gophermart/internal/storage"
+	"time"
+)
+
+// fakeStore подменяет базу в тестах пакета. Методы, которые тест не переопределил,
+// падают на nil-интерфейсе — так сразу видно, что обработчик полез в базу неожиданно.
+type fakeStore struct {
+	storage.Interface
+
+	users       map[int]storage.UserTable
+	passwords   map[int]string
+	resets      map[string]int // токен сброса пароля -> пользователь
+	orders      []storage.OrderTable
+	withdrawals []storage.WithdrawalTable
+	balance     storage.UserBalance
+
+	totp         map[int]storage.UserTOTP
+	totpFailures map[int][]time.Time
+	totpErr      error // ответ GetUserTOTP
+
+	orderStatusErr error // ответ SetOrderStatus
+	returnErr      error // ответ ReturnOrder
+
+	updatedDeliveries []storage.WebhookDelivery
+	audit             []storage.AuditEntry
+}
+
+func (s *fakeStore) CreateUser(user storage.UserTable) (userID int, err error) {
+	for _, v := range s.users {
+		if v.Login == user.Login {
+			return 0, storage.ErrorUserAlreadyExists
+		}
+	}
+	user.ID = len(s.users) + 1
+	if user.Role == "" {
+		user.Role = storage.UserRoleUser
+	}
+	s.users[user.ID] = user
+	s.passwords[user.ID] = user.Password
+	return user.ID, nil
+}
+
+func (s *fakeStore) GetUserIDByCredentials(login, password string) (userID int, err error) {
+	for _, v := range s.users {
+		if v.Login == login && s.passwords[v.ID] == password {
+			return v.ID, nil
+		}
+	}
+	return 0, storage.ErrorUserCredentials
+}
+
+func (s *fakeStore) GetUserIDByLogin(login string) (userID int, err error) {
+	for _, v := range s.users {
+		if v.Login == login {
+			return v.ID, nil
+		}
+	}
+	return 0, storage.ErrorUserNotFound
+}
+
+func (s *fakeStore) GetUserByID(userID int) (user storage.UserTable, err error) {
+	user, ok := s.users[userID]
+	if !ok {
+		return user, storage.ErrorUserNotFound
+	}
+	return user, nil
+}
+
+func (s *fakeStore) GetLoginByResetToken(token string) (login string, err error) {
+	userID, ok := s.resets[token]
+	if !ok {
+		return "", storage.ErrorResetTokenInvalid
+	}
+	return s.users[userID].Login, nil
+}
+
+func (s *fakeStore) ResetPasswordByToken(token, password string) (userID int, err error) {
+	userID, ok := s.resets[token]
+	if !ok {
+		return 0, storage.ErrorResetTokenInvalid
+	}
+	delete(s.resets, token)
+	s.passwords[userID] = password
+	return userID, nil
+}
+
+func (s *fakeStore) GetUserTOTP(userID int) (userTOTP storage.UserTOTP, err error) {
+	if s.totpErr != nil {
+		return userTOTP, s.totpErr
+	}
+	userTOTP = s.totp[userID]
+	userTOTP.Login = s.users[userID].Login
+	return userTOTP, nil
+}
+
+func (s *fakeStore) SetUserTOTPSecret(userID int, secret string) (err error) {
+	userTOTP := s.totp[userID]
+	if userTOTP.Enabled {
+		return storage.ErrorTOTPAlreadyEnabled
+	}
+	userTOTP.Secret = secret
+	s.totp[userID] = userTOTP
+	return nil
+}
+
+func (s *fakeStore) DisableUserTOTP(userID int) (err error) {
+	delete(s.totp, userID)
+	return nil
+}
+
+func (s *fakeStore) UseUserTOTPStep(userID int, step int64) (err error) {
+	return nil
+}
+
+func (s *fakeStore) UseUserRecoveryCode(userID int, code string) (err error) {
+	return storage.ErrorRecoveryCodeInvalid
+}
+
+func (s *fakeStore) AddUserTOTPFailure(userID int, windowStart, now time.Time) (failures int, err error) {
+	var kept []time.Time
+	for _, v := range s.totpFailures[userID] {
+		if v.After(windowStart) {
+			kept = append(kept, v)
+		}
+	}
+	s.totpFailures[userID] = append(kept, now)
+	return len(s.totpFailures[userID]), nil
+}
+
+func (s *fakeStore) LockUserTOTP(userID int, until time.Time) (err error) {
+	userTOTP := s.totp[userID]
+	userTOTP.LockedUntil = until
+	s.totp[userID] = userTOTP
+	s.totpFailures[userID] = nil
+	return nil
+}
+
+func (s *fakeStore) ResetUserTOTPFailures(userID int) (err error) {
+	s.totpFailures[userID] = nil
+	return nil
+}
+
+func (s *fakeStore) CreateLoginEvent(event storage.LoginEvent) (newDevice bool, err error) {
+	return false, nil
+}
+
+func (s *fakeStore) CreateAuditEntry(entry storage.AuditEntry) (err error) {
+	s.audit = append(s.audit, entry)
+	return nil
+}
+
+func (s *fakeStore) CreateOrder(order storage.OrderTable) (err error) {
+	for _, v := range s.orders {
+		if v.Number == order.Number {
+			return storage.ErrorOrderAlreadyExists
+		}
+	}
+	order.ID = len(s.orders) + 1
+	order.UploadedAt = time.Now()
+	s.orders = append(s.orders, order)
+	return nil
+}
+
+func (s *fakeStore) GetOrderByNumber(number string) (order storage.OrderTable, err error) {
+	for _, v := range s.orders {
+		if v.Number == number {
+			return v, nil
+		}
+	}
+	return order, storage.ErrorOrderNotFound
+}
+
+func (s *fakeStore) GetOrdersByUserID(userID int, filter storage.ListFilter) (orders []storage.OrderTable, err error) {
+	for _, v := range s.orders {
+		if v.UserID == userID {
+			orders = append(orders, v)
+		}
+	}
+	return orders, nil
+}
+
+func (s *fakeStore) GetOrderStatusHistory(orderID int) (events []storage.OrderStatusEvent, err error) {
+	for _, v := range s.orders {
+		if v.ID == orderID {
+			events = append(events, storage.OrderStatusEvent{ID: 1, OrderID: v.ID, Number: v.Number, Status: storage.OrderStatusNew,
+				Source: storage.OrderSourceUpload, CreatedAt: v.UploadedAt})
+			if v.Status != storage.OrderStatusNew {
+				events = append(events, storage.OrderStatusEvent{ID: 2, OrderID: v.ID, Number: v.Number, Status: v.Status,
+					Accrual: v.Accrual, Source: storage.OrderSourceAccrual, CreatedAt: v.UploadedAt.Add(time.Minute)})
+			}
+		}
+	}
+	return events, nil
+}
+
+func (s *fakeStore) SetOrderStatus(number, status string) (err error) {
+	return s.orderStatusErr
+}
+
+func (s *fakeStore) ReturnOrder(ret storage.OrderReturn) (result storage.OrderReturn, err error) {
+	if s.returnErr != nil {
+		return ret, s.returnErr
+	}
+	order, err := s.GetOrderByNumber(ret.OrderNumber)
+	if err != nil {
+		return ret, err
+	}
+	result = ret
+	result.UserID = order.UserID
+	if result.Amount == 0 {
+		result.Amount = order.Accrual
+	}
+	result.FromBalance = result.Amount
+	result.Returned = result.Amount
+	result.CreatedAt = time.Now()
+	return result, nil
+}
+
+func (s *fakeStore) GetUserBalance(userID int) (userBalance storage.UserBalance, err error) {
+	return s.balance, nil
+}
+
+func (s *fakeStore) GetExpiringPoints(userID int, until time.Time) (lots []storage.AccrualLot, err error) {
+	expiresAt := time.Now().Add(24 * time.Hour)
+	return []storage.AccrualLot{{ID: 1, UserID: userID, OrderNumber: "12345678903", Remaining: 40, ExpiresAt: &expiresAt}}, nil
+}
+
+func (s *fakeStore) GetStatement(userID int, from, to *time.Time) (statement storage.Statement, err error) {
+	statement.Closing = s.balance.Balance
+	for _, v := range s.orders {
+		if v.UserID == userID && v.Status == storage.OrderStatusProcessed {
+			statement.Lines = append(statement.Lines, storage.StatementLine{At: v.UploadedAt, Type: "accrual", Ref: v.Number, Amount: v.Accrual})
+		}
+	}
+	return statement, nil
+}
+
+func (s *fakeStore) CreateUserWithdraw(userID int, orderNumber string, sum float64) (err error) {
+	if sum > s.balance.Balance {
+		return storage.ErrorUserBalanceWithdraw
+	}
+	s.balance.Balance -= sum
+	s.balance.Withdrawn += sum
+	s.withdrawals = append(s.withdrawals, storage.WithdrawalTable{ID: len(s.withdrawals) + 1, UserID: userID, OrderNumber: orderNumber,
+		Sum: sum, Status: storage.WithdrawalStatusCompleted, ProcessedAt: time.Now()})
+	return nil
+}
+
+func (s *fakeStore) GetWithdrawListByUserID(userID int, filter storage.ListFilter) (withdrawals []storage.WithdrawalTable, err error) {
+	for _, v := range s.withdrawals {
+		if v.UserID == userID {
+			withdrawals = append(withdrawals, v)
+		}
+	}
+	return withdrawals, nil
+}
+
+func (s *fakeStore) UpdateWebhookDelivery(delivery storage.WebhookDelivery) (err error) {
+	s.updatedDeliveries = append(s.updatedDeliveries, delivery)
+	return nil
+}
@@ -0,0 +1,223 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/rainset/gophermart/internal/storage"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	orderStreamBatch       = 100
+	defaultStreamHeartbeat = 15 * time.Second
+)
+
+// orderEventHub раздаёт уведомления о новых событиях открытым потокам пользователя.
+// Сами события поток читает из базы, уведомление только будит его.
+type orderEventHub struct {
+	mu   sync.Mutex
+	subs map[int]map[chan struct{}]struct{}
+}
+
+func newOrderEventHub() *orderEventHub {
+	return &orderEventHub{subs: make(map[int]map[chan struct{}]struct{})}
+}
+
+func (h *orderEventHub) subscribe(userID int) chan struct{} {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	ch := make(chan struct{}, 1)
+	if h.subs[userID] == nil {
+		h.subs[userID] = make(map[chan struct{}]struct{})
+	}
+	h.subs[userID][ch] = struct{}{}
+	return ch
+}
+
+func (h *orderEventHub) unsubscribe(userID int, ch chan struct{}) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.subs[userID], ch)
+	if len(h.subs[userID]) == 0 {
+		delete(h.subs, userID)
+	}
+}
+
+func (h *orderEventHub) publish(userID int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subs[userID] {
+		select {
+		case ch <- struct{}{}:
+		default: // поток ещё не разобрал прошлое уведомление
+		}
+	}
+}
+
+// OrderEventsServer слушает order_events в Postgres и будит потоки нужных пользователей.
+// Уведомления приходят от всех экземпляров сервиса, при обрыве соединение восстанавливается.
+func (a *App) OrderEventsServer() {
+	notices := make(chan storage.OrderEventNotice)
+	go func() {
+		for notice := range notices {
+			a.events.publish(notice.UserID)
+		}
+	}()
+
+	for {
+		err := a.s.ListenOrderEvents(context.Background(), notices)
+		log.Println("order events: ", err)
+		time.Sleep(5 * time.Second)
+	}
+}
+
+type ResponseOrderEvent struct {
+	Number    string  `json:"number"`
+	Status    string  `json:"status"`
+	Accrual   float64 `json:"accrual,omitempty"`
+	Source    string  `json:"source"`
+	CreatedAt string  `json:"created_at"`
+}
+
+func writeSSE(c *gin.Context, id int, event string, data interface{}) error {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if id > 0 {
+		_, err = fmt.Fprintf(c.Writer, "id: %d\n", id)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(c.Writer, "event: %s\ndata: %s\n\n", event, b)
+	return err
+}
+
+// sendOrderEvents отправляет события с номером больше lastID и, если они были или withBalance, текущий баланс.
+// Баланс меняется и без событий заказов (списания, резервы, сгорание), о таком приходит только уведомление.
+func (a *App) sendOrderEvents(c *gin.Context, userID int, lastID *int, withBalance bool) error {
+	sent := false
+	for {
+		events, err := a.s.GetOrderEventsByUserID(userID, *lastID, orderStreamBatch)
+		if err != nil {
+			return err
+		}
+		for _, v := range events {
+			err = writeSSE(c, v.Seq, "order", ResponseOrderEvent{
+				Number:    v.Number,
+				Status:    v.Status,
+				Accrual:   v.Accrual,
+				Source:    v.Source,
+				CreatedAt: v.CreatedAt.Format(time.RFC3339),
+			})
+			if err != nil {
+				return err
+			}
+			*lastID = v.Seq
+			sent = true
+		}
+		if len(events) < orderStreamBatch {
+			break
+		}
+	}
+	if !sent && !withBalance {
+		return nil
+	}
+
+	userBalance, err := a.s.GetUserBalance(userID)
+	if err != nil {
+		return err
+	}
+	err = writeSSE(c, 0, "balance", newResponseBalance(userBalance))
+	if err != nil {
+		return err
+	}
+	c.Writer.Flush()
+	return nil
+}
+
+func (a *App) StreamUserOrdersHandler(c *gin.Context) {
+	sessionUserID := a.currentUserID(c)
+
+	// подписка до чтения позиции, чтобы не потерять событие между ними
+	notices := a.events.subscribe(sessionUserID)
+	defer a.events.unsubscribe(sessionUserID, notices)
+
+	interval := a.Config.StreamHeartbeat
+	if interval <= 0 {
+		interval = defaultStreamHeartbeat
+	}
+
+	resume := c.GetHeader("Last-Event-ID")
+	var lastID int
+	var err error
+	if resume != "" {
+		lastID, err = strconv.Atoi(resume)
+		if err != nil || lastID < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"code": http.StatusBadRequest})
+			return
+		}
+	} else {
+		lastID, err = a.s.GetLastOrderEventID(sessionUserID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"code": http.StatusInternalServerError})
+			return
+		}
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	_, err = fmt.Fprintf(c.Writer, "retry: %d\n\n", interval.Milliseconds())
+	if err != nil {
+		return
+	}
+	c.Writer.Flush()
+
+	if resume != "" {
+		if err = a.sendOrderEvents(c, sessionUserID, &lastID, false); err != nil {
+			log.Println("order stream: ", err)
+			return
+		}
+	}
+
+	heartbeat := time.NewTicker(interval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-notices:
+			if err = a.sendOrderEvents(c, sessionUserID, &lastID, true); err != nil {
+				log.Println("order stream: ", err)
+				return
+			}
+		case <-heartbeat.C:
+			if _, err = fmt.Fprint(c.Writer, ": ping\n\n"); err != nil {
+				return
+			}
+			c.Writer.Flush()
+		}
+	}
+}
+
+// gzipExceptStreams не сжимает потоки событий: gzip копит данные в буфере и задерживает их.
+func gzipExceptStreams(gz gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if strings.HasSuffix(c.Request.URL.Path, "/stream") {
+			return
+		}
+		gz(c)
+	}
+}
@@ -0,0 +1,398 @@
+package app
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/rainset/gophermart/internal/secrets"
+	"github.com/rainset/gophermart/internal/storage"
+	"github.com/rainset/gophermart/internal/totp"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	sessionPending2FAUser     = "2fa_pending_user"
+	sessionPending2FAAt       = "2fa_pending_at"
+	sessionPending2FAFailures = "2fa_pending_failures"
+	pending2FATTL             = 5 * time.Minute
+	pending2FAMaxFailures     = 3 // после стольких неверных кодов нужно заново ввести пароль
+	recoveryCodesCount        = 10
+
+	defaultTOTPMaxFailures = 5
+	defaultTOTPLockout     = 15 * time.Minute
+)
+
+var (
+	ErrorTOTPCodeInvalid = errors.New("invalid one-time code")
+	ErrorTOTPLocked      = errors.New("too many invalid one-time codes")
+)
+
+func generateRecoveryCodes() ([]string, error) {
+	codes := make([]string, 0, recoveryCodesCount)
+	enc := base32.StdEncoding.WithPadding(base32.NoPadding)
+	for i := 0; i < recoveryCodesCount; i++ {
+		b := make([]byte, 6)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		code := strings.ToLower(enc.EncodeToString(b))
+		codes = append(codes, code[:5]+"-"+code[5:10])
+	}
+	return codes, nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.TrimSpace(code))
+}
+
+// verifyTOTP проверяет код пользователя и помечает его использованным.
+func (a *App) verifyTOTP(userID int, userTOTP storage.UserTOTP, code string) error {
+	if userTOTP.Secret == "" {
+		return storage.ErrorTOTPNotEnrolled
+	}
+	secret, err := secrets.Open(a.Config.TOTPSecretKey, userTOTP.Secret)
+	if err != nil {
+		return err
+	}
+	step, ok := totp.Validate(secret, code, time.Now())
+	if !ok {
+		return ErrorTOTPCodeInvalid
+	}
+	return a.s.UseUserTOTPStep(userID, step)
+}
+
+// checkTOTP проверяет код 2FA или, если он передан, код восстановления. Неверные коды считаются
+// у пользователя, а не в сессии, и для всех проверок вместе: вход, отключение 2FA, списания.
+// После TOTPMaxFailures подряд второй фактор закрывается на TOTPLockout — тогда возвращается
+// ErrorTOTPLocked и время до разблокировки.
+func (a *App) checkTOTP(userID int, code, recoveryCode string) (retryAfter time.Duration, err error) {
+	userTOTP, err := a.s.GetUserTOTP(userID)
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now()
+	if now.Before(userTOTP.LockedUntil) {
+		return userTOTP.LockedUntil.Sub(now), ErrorTOTPLocked
+	}
+	if userTOTP.Secret == "" {
+		return 0, storage.ErrorTOTPNotEnrolled
+	}
+
+	if recoveryCode != "" {
+		err = a.s.UseUserRecoveryCode(userID, normalizeRecoveryCode(recoveryCode))
+	} else {
+		err = a.verifyTOTP(userID, userTOTP, code)
+	}
+	if err == nil {
+		return 0, a.s.ResetUserTOTPFailures(userID)
+	}
+	if !isWrongTOTPCode(err) {
+		return 0, err
+	}
+
+	lockout := a.totpLockout()
+	failures, lockErr := a.s.AddUserTOTPFailure(userID, now.Add(-lockout), now)
+	if lockErr != nil {
+		return 0, lockErr
+	}
+	if failures >= a.totpMaxFailures() {
+		if lockErr = a.s.LockUserTOTP(userID, now.Add(lockout)); lockErr != nil {
+			return 0, lockErr
+		}
+		return lockout, ErrorTOTPLocked
+	}
+	return 0, err
+}
+
+// respondTOTPError отвечает на неудачную проверку checkTOTP: 429 при блокировке,
+// 422 без подключённой 2FA, 403 на неверный код, 500 на остальные ошибки.
+func respondTOTPError(c *gin.Context, retryAfter time.Duration, err error, body gin.H) {
+	_ = c.Error(err)
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, ErrorTOTPLocked):
+		c.Header("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+		status = http.StatusTooManyRequests
+	case errors.Is(err, storage.ErrorTOTPNotEnrolled):
+		status = http.StatusUnprocessableEntity
+	case isWrongTOTPCode(err):
+		status = http.StatusForbidden
+	}
+	if body == nil || status == http.StatusInternalServerError {
+		body = gin.H{}
+	}
+	body["code"] = status
+	c.JSON(status, body)
+}
+
+// completeLogin открывает сессию пользователя или, если у него включена 2FA,
+// переводит вход во второй шаг.
+func (a *App) completeLogin(c *gin.Context, userID int, method string) {
+	user, err := a.s.GetUserByID(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": http.StatusInternalServerError})
+		return
+	}
+	if user.Frozen {
+		_ = c.Error(storage.ErrorUserFrozen)
+		a.recordLogin(c, user.ID, user.Login, method, false, "frozen")
+		c.JSON(http.StatusForbidden, gin.H{"code": http.StatusForbidden})
+		return
+	}
+
+	userTOTP, err := a.s.GetUserTOTP(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": http.StatusInternalServerError})
+		return
+	}
+
+	session := sessions.Default(c)
+	if userTOTP.Enabled {
+		session.Delete(a.Config.SessionName)
+		session.Set(sessionPending2FAUser, userID)
+		session.Set(sessionPending2FAAt, time.Now().Unix())
+		session.Delete(sessionPending2FAFailures)
+		_ = session.Save()
+		c.JSON(http.StatusAccepted, gin.H{"code": http.StatusAccepted, "two_factor_required": true})
+		return
+	}
+
+	a.openSession(c, user.ID, user.SessionVersion)
+	a.recordLogin(c, user.ID, user.Login, method, true, "")
+	c.JSON(http.StatusOK, gin.H{"code": http.StatusOK})
+}
+
+func (a *App) UserLogin2FAHandler(c *gin.Context) {
+
+	clientData := struct {
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}{}
+
+	err := c.BindJSON(&clientData)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": http.StatusBadRequest})
+		return
+	}
+
+	session := sessions.Default(c)
+	userID, _ := session.Get(sessionPending2FAUser).(int)
+	startedAt, _ := session.Get(sessionPending2FAAt).(int64)
+	if userID == 0 || time.Since(time.Unix(startedAt, 0)) > pending2FATTL {
+		c.JSON(http.StatusUnauthorized, gin.H{"code": http.StatusUnauthorized})
+		return
+	}
+
+	user, err := a.s.GetUserByID(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": http.StatusInternalServerError})
+		return
+	}
+
+	retryAfter, err := a.checkTOTP(userID, clientData.Code, clientData.RecoveryCode)
+	switch {
+	case errors.Is(err, ErrorTOTPLocked):
+		a.reject2FA(c, session, user, retryAfter)
+		return
+	case isWrongTOTPCode(err):
+		_ = c.Error(err)
+		a.recordLogin(c, user.ID, user.Login, storage.LoginMethod2FA, false, "invalid_code")
+
+		sessionFailures, _ := session.Get(sessionPending2FAFailures).(int)
+		sessionFailures++
+		if sessionFailures >= pending2FAMaxFailures {
+			clearPending2FA(session)
+		} else {
+			session.Set(sessionPending2FAFailures, sessionFailures)
+		}
+		_ = session.Save()
+		c.JSON(http.StatusUnauthorized, gin.H{"code": http.StatusUnauthorized})
+		return
+	case err != nil:
+		_ = c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"code": http.StatusInternalServerError})
+		return
+	}
+
+	clearPending2FA(session)
+	a.openSession(c, user.ID, user.SessionVersion)
+	a.recordLogin(c, user.ID, user.Login, storage.LoginMethod2FA, true, "")
+
+	c.JSON(http.StatusOK, gin.H{"code": http.StatusOK})
+}
+
+// reject2FA отвечает на второй шаг входа, пока он закрыт после серии неверных кодов.
+// Незавершённый вход сбрасывается: после блокировки нужно заново ввести пароль.
+func (a *App) reject2FA(c *gin.Context, session sessions.Session, user storage.UserTable, retryAfter time.Duration) {
+	clearPending2FA(session)
+	_ = session.Save()
+	a.recordLogin(c, user.ID, user.Login, storage.LoginMethod2FA, false, "locked")
+	respondTOTPError(c, retryAfter, ErrorTOTPLocked, nil)
+}
+
+func clearPending2FA(session sessions.Session) {
+	session.Delete(sessionPending2FAUser)
+	session.Delete(sessionPending2FAAt)
+	session.Delete(sessionPending2FAFailures)
+}
+
+func isWrongTOTPCode(err error) bool {
+	return errors.Is(err, ErrorTOTPCodeInvalid) || errors.Is(err, storage.ErrorTOTPCodeReused) ||
+		errors.Is(err, storage.ErrorRecoveryCodeInvalid) || errors.Is(err, storage.ErrorTOTPNotEnrolled)
+}
+
+func (a *App) totpMaxFailures() int {
+	if a.Config.TOTPMaxFailures <= 0 {
+		return defaultTOTPMaxFailures
+	}
+	return a.Config.TOTPMaxFailures
+}
+
+func (a *App) totpLockout() time.Duration {
+	if a.Config.TOTPLockout <= 0 {
+		return defaultTOTPLockout
+	}
+	return a.Config.TOTPLockout
+}
+
+func (a *App) Enroll2FAHandler(c *gin.Context) {
+	sessionUserID := a.currentUserID(c)
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": http.StatusInternalServerError})
+		return
+	}
+
+	sealed, err := secrets.Seal(a.Config.TOTPSecretKey, secret)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": http.StatusInternalServerError})
+		return
+	}
+
+	err = a.s.SetUserTOTPSecret(sessionUserID, sealed)
+	if err != nil {
+		if errors.Is(err, storage.ErrorTOTPAlreadyEnabled) {
+			c.JSON(http.StatusConflict, gin.H{"code": http.StatusConflict})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"code": http.StatusInternalServerError})
+		return
+	}
+
+	userTOTP, err := a.s.GetUserTOTP(sessionUserID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": http.StatusInternalServerError})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"secret": secret,
+		"uri":    totp.ProvisioningURI(a.Config.TOTPIssuer, userTOTP.Login, secret),
+	})
+}
+
+func (a *App) Confirm2FAHandler(c *gin.Context) {
+	sessionUserID := a.currentUserID(c)
+
+	clientData := struct {
+		Code string `json:"code"`
+	}{}
+
+	err := c.BindJSON(&clientData)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": http.StatusBadRequest})
+		return
+	}
+
+	userTOTP, err := a.s.GetUserTOTP(sessionUserID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": http.StatusInternalServerError})
+		return
+	}
+	if userTOTP.Enabled {
+		c.JSON(http.StatusConflict, gin.H{"code": http.StatusConflict})
+		return
+	}
+
+	err = a.verifyTOTP(sessionUserID, userTOTP, clientData.Code)
+	if err != nil {
+		respondTOTPError(c, 0, err, nil)
+		return
+	}
+
+	recoveryCodes, err := generateRecoveryCodes()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": http.StatusInternalServerError})
+		return
+	}
+
+	err = a.s.EnableUserTOTP(sessionUserID, recoveryCodes)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": http.StatusInternalServerError})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": recoveryCodes})
+}
+
+func (a *App) Disable2FAHandler(c *gin.Context) {
+	sessionUserID := a.currentUserID(c)
+
+	clientData := struct {
+		Code string `json:"code"`
+	}{}
+
+	err := c.BindJSON(&clientData)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": http.StatusBadRequest})
+		return
+	}
+
+	retryAfter, err := a.checkTOTP(sessionUserID, clientData.Code, "")
+	if err != nil {
+		respondTOTPError(c, retryAfter, err, nil)
+		return
+	}
+
+	err = a.s.DisableUserTOTP(sessionUserID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": http.StatusInternalServerError})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"code": http.StatusOK})
+}
+
+// requireWithdrawTOTP требует свежий код 2FA для списаний выше порога.
+// Возвращает false, если ответ клиенту уже отправлен.
+func (a *App) requireWithdrawTOTP(c *gin.Context, userID int, sum float64, code string) bool {
+	if a.Config.TOTPWithdrawThreshold <= 0 || sum <= a.Config.TOTPWithdrawThreshold {
+		return true
+	}
+
+	userTOTP, err := a.s.GetUserTOTP(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": http.StatusInternalServerError})
+		return false
+	}
+	if !userTOTP.Enabled {
+		return true
+	}
+
+	if code == "" {
+		_ = c.Error(ErrorTOTPRequired)
+		c.JSON(http.StatusForbidden, gin.H{"code": http.StatusForbidden, "two_factor_required": true})
+		return false
+	}
+	if retryAfter, err := a.checkTOTP(userID, code, ""); err != nil {
+		respondTOTPError(c, retryAfter, err, gin.H{"two_factor_required": true})
+		return false
+	}
+	return true
+}
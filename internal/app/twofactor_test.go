@@ -0,0 +1,192 @@
+package app
+
+import (
+	"errors"
+	"github.com/rainset/gophermart/internal/secrets"
+	"github.com/rainset/gophermart/internal/storage"
+	"github.com/rainset/gophermart/internal/totp"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLogin2FAAttempts(t *testing.T) {
+	key := []byte(strings.Repeat("k", secrets.BlockKeySize))
+	secret, _ := totp.GenerateSecret()
+	sealed, err := secrets.Seal(key, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := newTestStore()
+	s.totp[1] = storage.UserTOTP{Secret: sealed, Enabled: true}
+	a := newTestApp(t, s)
+	a.Config.TOTPSecretKey = key
+	a.Config.TOTPMaxFailures = 5
+	a.Config.TOTPLockout = 15 * time.Minute
+	c := &testClient{t: t, router: a.NewRouter(), prefix: "/api", cookies: map[string]*http.Cookie{}}
+
+	code := func() string {
+		v, _ := totp.Code(secret, time.Now())
+		return `{"code":"` + v + `"}`
+	}
+	login := func() {
+		t.Helper()
+		c.json("POST", "/user/login", `{"login":"alice","password":"alice-password-1"}`, http.StatusAccepted)
+	}
+	wrong := `{"code":"000000"}`
+	if strings.Contains(code(), "000000") {
+		wrong = `{"code":"111111"}`
+	}
+
+	// после трёх неверных кодов в одном входе нужно заново ввести пароль
+	login()
+	c.json("POST", "/user/login/2fa", wrong, http.StatusUnauthorized)
+	c.json("POST", "/user/login/2fa", `{"recovery_code":"aaaaa-bbbbb"}`, http.StatusUnauthorized)
+	c.json("POST", "/user/login/2fa", wrong, http.StatusUnauthorized)
+	c.json("POST", "/user/login/2fa", code(), http.StatusUnauthorized)
+
+	// новый вход не сбрасывает счётчик пользователя: пятая неудача закрывает второй шаг
+	login()
+	c.json("POST", "/user/login/2fa", wrong, http.StatusUnauthorized)
+	w := c.json("POST", "/user/login/2fa", wrong, http.StatusTooManyRequests)
+	if w.Header().Get("Retry-After") == "" {
+		t.Error("no Retry-After on lockout")
+	}
+	if until := s.totp[1].LockedUntil; until.Before(time.Now().Add(14 * time.Minute)) {
+		t.Errorf("locked until %v", until)
+	}
+
+	// пока блокировка действует, не проходит и верный код
+	login()
+	c.json("POST", "/user/login/2fa", code(), http.StatusTooManyRequests)
+	c.json("GET", "/user/balance", "", http.StatusUnauthorized)
+
+	userTOTP := s.totp[1]
+	userTOTP.LockedUntil = time.Now().Add(-time.Second)
+	s.totp[1] = userTOTP
+	login()
+	c.json("POST", "/user/login/2fa", wrong, http.StatusUnauthorized)
+	c.json("POST", "/user/login/2fa", code(), http.StatusOK)
+	c.json("GET", "/user/balance", "", http.StatusOK)
+	if len(s.totpFailures[1]) != 0 {
+		t.Errorf("failures after a successful login: %d", len(s.totpFailures[1]))
+	}
+}
+
+func TestEnroll2FASealsSecret(t *testing.T) {
+	tests := []struct {
+		name   string
+		key    []byte
+		sealed bool
+	}{
+		{"with key", []byte(strings.Repeat("k", secrets.BlockKeySize)), true},
+		{"without key", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestStore()
+			a := newTestApp(t, s)
+			a.Config.TOTPSecretKey = tt.key
+			c := &testClient{t: t, router: a.NewRouter(), prefix: "/api", cookies: map[string]*http.Cookie{}}
+
+			c.json("POST", "/user/login", `{"login":"bob","password":"bob-password-1"}`, http.StatusOK)
+			w := c.json("POST", "/user/2fa/enroll", "", http.StatusOK)
+
+			stored := s.totp[3].Secret
+			if strings.HasPrefix(stored, "enc:") != tt.sealed {
+				t.Errorf("stored secret %q", stored)
+			}
+			secret, err := secrets.Open(tt.key, stored)
+			if err != nil || !strings.Contains(w.Body.String(), `"secret":"`+secret+`"`) {
+				t.Errorf("stored secret opens to %q (%v), response %s", secret, err, w.Body.String())
+			}
+		})
+	}
+}
+
+// TestTOTPLockoutOnSensitiveActions проверяет, что коды для отключения 2FA и крупных списаний
+// считаются вместе с кодами входа и закрываются той же блокировкой.
+func TestTOTPLockoutOnSensitiveActions(t *testing.T) {
+	wrongCode := func(secret string) string {
+		if v, _ := totp.Code(secret, time.Now()); v == "000000" {
+			return "111111"
+		}
+		return "000000"
+	}
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   func(code string) string
+		status int // ответ на неверный код
+	}{
+		{"disable 2fa", "POST", "/user/2fa/disable",
+			func(code string) string { return `{"code":"` + code + `"}` }, http.StatusForbidden},
+		{"withdraw above threshold", "POST", "/user/balance/withdraw",
+			func(code string) string { return `{"order":"2377225624","sum":200,"totp_code":"` + code + `"}` }, http.StatusForbidden},
+	}
+	for _, prefix := range []string{"/api", apiV2Prefix} {
+		for _, tt := range tests {
+			t.Run(prefix+" "+tt.name, func(t *testing.T) {
+				secret, _ := totp.GenerateSecret()
+				s := newTestStore()
+				a := newTestApp(t, s)
+				a.Config.TOTPMaxFailures = 3
+				a.Config.TOTPLockout = 15 * time.Minute
+				a.Config.TOTPWithdrawThreshold = 100
+				c := &testClient{t: t, router: a.NewRouter(), prefix: prefix, cookies: map[string]*http.Cookie{}}
+				c.json("POST", "/user/login", `{"login":"alice","password":"alice-password-1"}`, http.StatusOK)
+				s.totp[1] = storage.UserTOTP{Secret: secret, Enabled: true}
+
+				wrong := tt.body(wrongCode(secret))
+				c.json(tt.method, tt.path, wrong, tt.status)
+				c.json(tt.method, tt.path, wrong, tt.status)
+				w := c.json(tt.method, tt.path, wrong, http.StatusTooManyRequests)
+				if w.Header().Get("Retry-After") == "" {
+					t.Error("no Retry-After on lockout")
+				}
+
+				// пока блокировка действует, не проходит и верный код
+				code, _ := totp.Code(secret, time.Now())
+				c.json(tt.method, tt.path, tt.body(code), http.StatusTooManyRequests)
+				if !s.totp[1].Enabled || len(s.withdrawals) != 0 {
+					t.Errorf("action went through while locked: totp %+v, withdrawals %d", s.totp[1], len(s.withdrawals))
+				}
+
+				userTOTP := s.totp[1]
+				userTOTP.LockedUntil = time.Time{}
+				s.totp[1] = userTOTP
+				c.json(tt.method, tt.path, tt.body(code), http.StatusOK)
+				if len(s.totpFailures[1]) != 0 {
+					t.Errorf("failures after a valid code: %d", len(s.totpFailures[1]))
+				}
+			})
+		}
+	}
+}
+
+func TestTOTPStorageError(t *testing.T) {
+	tests := []struct {
+		name string
+		path string
+		body string
+	}{
+		{"disable 2fa", "/user/2fa/disable", `{"code":"123456"}`},
+		{"confirm 2fa", "/user/2fa/confirm", `{"code":"123456"}`},
+		{"withdraw above threshold", "/user/balance/withdraw", `{"order":"2377225624","sum":200,"totp_code":"123456"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestStore()
+			a := newTestApp(t, s)
+			a.Config.TOTPWithdrawThreshold = 100
+			c := &testClient{t: t, router: a.NewRouter(), prefix: "/api", cookies: map[string]*http.Cookie{}}
+			c.json("POST", "/user/login", `{"login":"alice","password":"alice-password-1"}`, http.StatusOK)
+
+			s.totpErr = errors.New("connection refused")
+			c.json("POST", tt.path, tt.body, http.StatusInternalServerError)
+		})
+	}
+}
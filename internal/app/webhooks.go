@@ -0,0 +1,252 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/rainset/gophermart/internal/storage"
+	"github.com/rainset/gophermart/internal/webhook"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	webhookSecretPrefix    = "whsec_"
+	webhookDeliveriesLimit = 100
+	webhookPollInterval    = 5 * time.Second
+)
+
+func isWebhookEvent(event string) bool {
+	for _, v := range storage.WebhookEvents {
+		if v == event {
+			return true
+		}
+	}
+	return false
+}
+
+type ResponseWebhook struct {
+	ID        int      `json:"id"`
+	URL       string   `json:"url"`
+	Events    []string `json:"events"`
+	CreatedAt string   `json:"created_at"`
+	Secret    string   `json:"secret,omitempty"`
+}
+
+func newResponseWebhook(hook storage.Webhook) ResponseWebhook {
+	return ResponseWebhook{
+		ID:        hook.ID,
+		URL:       hook.URL,
+		Events:    hook.Events,
+		CreatedAt: hook.CreatedAt.Format(time.RFC3339),
+	}
+}
+
+type ResponseWebhookDelivery struct {
+	ID             int    `json:"id"`
+	Event          string `json:"event"`
+	Status         string `json:"status"`
+	Attempts       int    `json:"attempts"`
+	NextAttemptAt  string `json:"next_attempt_at,omitempty"`
+	LastStatusCode int    `json:"last_status_code,omitempty"`
+	LastError      string `json:"last_error,omitempty"`
+	CreatedAt      string `json:"created_at"`
+	DeliveredAt    string `json:"delivered_at,omitempty"`
+}
+
+func newResponseWebhookDelivery(delivery storage.WebhookDelivery) ResponseWebhookDelivery {
+	result := ResponseWebhookDelivery{
+		ID:             delivery.ID,
+		Event:          delivery.Event,
+		Status:         delivery.Status,
+		Attempts:       delivery.Attempts,
+		LastStatusCode: delivery.LastStatusCode,
+		LastError:      delivery.LastError,
+		CreatedAt:      delivery.CreatedAt.Format(time.RFC3339),
+	}
+	if delivery.Status == storage.WebhookDeliveryPending {
+		result.NextAttemptAt = delivery.NextAttemptAt.Format(time.RFC3339)
+	}
+	if delivery.DeliveredAt != nil {
+		result.DeliveredAt = delivery.DeliveredAt.Format(time.RFC3339)
+	}
+	return result
+}
+
+func (a *App) CreateWebhookHandler(c *gin.Context) {
+	sessionUserID := a.currentUserID(c)
+
+	clientData := struct {
+		URL    string   `json:"url"`
+		Events []string `json:"events"`
+	}{}
+
+	err := c.BindJSON(&clientData)
+	if err != nil || len(clientData.Events) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"code": http.StatusBadRequest})
+		return
+	}
+	if err = webhook.ValidateURL(clientData.URL, a.Config.WebhookAllowPrivate); err != nil {
+		_ = c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{"code": http.StatusBadRequest, "error": err.Error()})
+		return
+	}
+	for _, event := range clientData.Events {
+		if !isWebhookEvent(event) {
+			c.JSON(http.StatusBadRequest, gin.H{"code": http.StatusBadRequest, "event": event})
+			return
+		}
+	}
+
+	token, err := generateToken(24)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": http.StatusInternalServerError})
+		return
+	}
+
+	hook := storage.Webhook{
+		UserID:    sessionUserID,
+		URL:       clientData.URL,
+		Secret:    webhookSecretPrefix + token,
+		Events:    clientData.Events,
+		CreatedAt: time.Now().UTC(),
+	}
+	hook.ID, err = a.s.CreateWebhook(hook)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": http.StatusInternalServerError})
+		return
+	}
+
+	// секрет для проверки подписи показывается только один раз
+	result := newResponseWebhook(hook)
+	result.Secret = hook.Secret
+	c.JSON(http.StatusCreated, result)
+}
+
+func (a *App) GetWebhooksHandler(c *gin.Context) {
+	sessionUserID := a.currentUserID(c)
+
+	hooks, err := a.s.GetWebhooksByUserID(sessionUserID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": http.StatusInternalServerError})
+		return
+	}
+
+	result := make([]ResponseWebhook, 0, len(hooks))
+	for _, v := range hooks {
+		result = append(result, newResponseWebhook(v))
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+func (a *App) DeleteWebhookHandler(c *gin.Context) {
+	sessionUserID := a.currentUserID(c)
+
+	hookID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": http.StatusBadRequest})
+		return
+	}
+
+	err = a.s.DeleteWebhook(sessionUserID, hookID)
+	if err != nil {
+		_ = c.Error(err)
+		if errors.Is(err, storage.ErrorWebhookNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"code": http.StatusNotFound})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"code": http.StatusInternalServerError})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"code": http.StatusOK})
+}
+
+func (a *App) GetWebhookDeliveriesHandler(c *gin.Context) {
+	sessionUserID := a.currentUserID(c)
+
+	hookID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": http.StatusBadRequest})
+		return
+	}
+
+	hook, err := a.s.GetWebhook(sessionUserID, hookID)
+	if err != nil {
+		_ = c.Error(err)
+		if errors.Is(err, storage.ErrorWebhookNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"code": http.StatusNotFound})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"code": http.StatusInternalServerError})
+		return
+	}
+
+	deliveries, err := a.s.GetWebhookDeliveries(hook.ID, webhookDeliveriesLimit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": http.StatusInternalServerError})
+		return
+	}
+
+	result := make([]ResponseWebhookDelivery, 0, len(deliveries))
+	for _, v := range deliveries {
+		result = append(result, newResponseWebhookDelivery(v))
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// deliverWebhook отправляет одну доставку и планирует повтор с экспоненциальной паузой.
+func (a *App) deliverWebhook(delivery storage.WebhookDelivery) {
+	statusCode, err := a.webhooks.Send(context.Background(), delivery.URL, delivery.Secret, webhook.Delivery{
+		ID:      delivery.ID,
+		Event:   delivery.Event,
+		Payload: []byte(delivery.Payload),
+	})
+
+	now := time.Now().UTC()
+	delivery.Attempts++
+	delivery.LastStatusCode = statusCode
+	delivery.LastError = ""
+	switch {
+	case err == nil:
+		delivery.Status = storage.WebhookDeliveryDelivered
+		delivery.DeliveredAt = &now
+		delivery.NextAttemptAt = now
+	case delivery.Attempts >= a.Config.WebhookMaxAttempts:
+		delivery.Status = storage.WebhookDeliveryFailed
+		delivery.LastError = err.Error()
+		delivery.NextAttemptAt = now
+	default:
+		delivery.LastError = err.Error()
+		delivery.NextAttemptAt = now.Add(webhook.Backoff(delivery.Attempts))
+	}
+
+	if err = a.s.UpdateWebhookDelivery(delivery); err != nil {
+		log.Println("webhook delivery: ", err)
+	}
+}
+
+// WebhookDeliveryServer разбирает outbox вебхуков. Несколько экземпляров сервиса
+// могут работать одновременно: доставка захватывается одним из них.
+func (a *App) WebhookDeliveryServer() {
+	// доставки забираются по одной: lease должен покрыть отправку всего забранного, иначе
+	// к концу пачки её хвост снова станет доступен другим экземплярам и уйдёт дважды
+	lease := 2 * a.Config.WebhookTimeout
+	if lease <= 0 {
+		lease = time.Minute
+	}
+	for {
+		deliveries, err := a.s.ClaimWebhookDeliveries(1, lease)
+		if err != nil {
+			log.Println("webhook deliveries: ", err)
+		}
+		for _, v := range deliveries {
+			a.deliverWebhook(v)
+		}
+		if len(deliveries) == 0 {
+			time.Sleep(webhookPollInterval)
+		}
+	}
+}
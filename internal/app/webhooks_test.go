@@ -0,0 +1,129 @@
+package app
+
+import (
+	"github.com/rainset/gophermart/internal/storage"
+	"github.com/rainset/gophermart/internal/webhook"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// webhookReceiver отвечает ошибкой на первые failures доставок и проверяет подпись каждой.
+type webhookReceiver struct {
+	mu       sync.Mutex
+	failures int
+	calls    int
+	badSigs  int
+}
+
+func (rcv *webhookReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+	rcv.calls++
+	if webhook.Verify("secret", r.Header, body, time.Minute, time.Now()) != nil {
+		rcv.badSigs++
+	}
+	if rcv.calls <= rcv.failures {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// runDeliveries прогоняет доставку через deliverWebhook, пока она не перестанет быть pending,
+// как это делал бы WebhookDeliveryServer при каждом захвате.
+func runDeliveries(t *testing.T, a *App, s *fakeStore, delivery storage.WebhookDelivery) storage.WebhookDelivery {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		before := time.Now().UTC()
+		a.deliverWebhook(delivery)
+		delivery = s.updatedDeliveries[len(s.updatedDeliveries)-1]
+		if delivery.Status != storage.WebhookDeliveryPending {
+			return delivery
+		}
+		// повтор запланирован с экспоненциальной паузой от числа неудач
+		want := before.Add(webhook.Backoff(delivery.Attempts))
+		if d := delivery.NextAttemptAt.Sub(want); d < 0 || d > time.Second {
+			t.Errorf("attempt %d: next attempt at %v, want about %v", delivery.Attempts, delivery.NextAttemptAt, want)
+		}
+		if delivery.LastError == "" || delivery.LastStatusCode != http.StatusServiceUnavailable {
+			t.Errorf("attempt %d: last error %q, status %d", delivery.Attempts, delivery.LastError, delivery.LastStatusCode)
+		}
+	}
+	t.Fatal("delivery is still pending")
+	return delivery
+}
+
+func TestDeliverWebhook(t *testing.T) {
+	tests := []struct {
+		name         string
+		failures     int
+		maxAttempts  int
+		wantStatus   string
+		wantAttempts int
+	}{
+		{"delivered at once", 0, 5, storage.WebhookDeliveryDelivered, 1},
+		{"delivered after retries", 3, 5, storage.WebhookDeliveryDelivered, 4},
+		{"delivered on the last attempt", 4, 5, storage.WebhookDeliveryDelivered, 5},
+		{"gives up after max attempts", 10, 5, storage.WebhookDeliveryFailed, 5},
+		{"single attempt", 1, 1, storage.WebhookDeliveryFailed, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			receiver := &webhookReceiver{failures: tt.failures}
+			server := httptest.NewServer(receiver)
+			defer server.Close()
+
+			s := &fakeStore{}
+			a := New(s, Config{WebhookTimeout: time.Second, WebhookMaxAttempts: tt.maxAttempts, WebhookAllowPrivate: true})
+			delivery := runDeliveries(t, a, s, storage.WebhookDelivery{
+				ID:      1,
+				Event:   storage.WebhookEventOrderProcessed,
+				Payload: `{"event":"order.processed"}`,
+				Status:  storage.WebhookDeliveryPending,
+				URL:     server.URL,
+				Secret:  "secret",
+			})
+
+			if delivery.Status != tt.wantStatus || delivery.Attempts != tt.wantAttempts {
+				t.Errorf("status %s after %d attempts, want %s after %d", delivery.Status, delivery.Attempts, tt.wantStatus, tt.wantAttempts)
+			}
+			if receiver.calls != tt.wantAttempts {
+				t.Errorf("receiver got %d requests, want %d", receiver.calls, tt.wantAttempts)
+			}
+			if receiver.badSigs != 0 {
+				t.Errorf("%d requests with invalid signature", receiver.badSigs)
+			}
+			switch delivery.Status {
+			case storage.WebhookDeliveryDelivered:
+				if delivery.DeliveredAt == nil || delivery.LastError != "" || delivery.LastStatusCode != http.StatusOK {
+					t.Errorf("delivered: at %v, error %q, status %d", delivery.DeliveredAt, delivery.LastError, delivery.LastStatusCode)
+				}
+			case storage.WebhookDeliveryFailed:
+				if delivery.DeliveredAt != nil || delivery.LastError == "" {
+					t.Errorf("failed: at %v, error %q", delivery.DeliveredAt, delivery.LastError)
+				}
+			}
+		})
+	}
+}
+
+func TestDeliverWebhookUnreachable(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	url := server.URL
+	server.Close()
+
+	s := &fakeStore{}
+	a := New(s, Config{WebhookTimeout: time.Second, WebhookMaxAttempts: 3, WebhookAllowPrivate: true})
+	a.deliverWebhook(storage.WebhookDelivery{ID: 1, Event: storage.WebhookEventOrderProcessed, Payload: `{}`,
+		Status: storage.WebhookDeliveryPending, URL: url, Secret: "secret"})
+
+	delivery := s.updatedDeliveries[0]
+	if delivery.Status != storage.WebhookDeliveryPending || delivery.Attempts != 1 || delivery.LastStatusCode != 0 || delivery.LastError == "" {
+		t.Errorf("got status %s, attempts %d, code %d, error %q", delivery.Status, delivery.Attempts, delivery.LastStatusCode, delivery.LastError)
+	}
+}
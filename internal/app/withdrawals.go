@@ -0,0 +1,51 @@
+package app
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/rainset/gophermart/internal/storage"
+	"net/http"
+	"strings"
+)
+
+var ErrorWithdrawalCancelDisabled = errors.New("withdrawal cancellation is disabled")
+
+// cancelWithdrawal отменяет списание и отвечает им же со статусом CANCELLED.
+func (a *App) cancelWithdrawal(c *gin.Context, cancel storage.WithdrawalCancel) {
+	withdrawal, err := a.s.CancelUserWithdraw(cancel)
+	if err != nil {
+		_ = c.Error(err)
+		switch {
+		case errors.Is(err, storage.ErrorWithdrawalNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"code": http.StatusNotFound})
+		case errors.Is(err, storage.ErrorWithdrawalCancelled), errors.Is(err, storage.ErrorWithdrawalCancelWindow):
+			c.JSON(http.StatusConflict, gin.H{"code": http.StatusConflict})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"code": http.StatusInternalServerError})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, newResponseWithdrawal(withdrawal))
+}
+
+// CancelUserWithdrawHandler возвращает баллы за списание, если с него прошло не больше WithdrawalCancelWindow.
+func (a *App) CancelUserWithdrawHandler(c *gin.Context) {
+	if a.Config.WithdrawalCancelWindow <= 0 {
+		_ = c.Error(ErrorWithdrawalCancelDisabled)
+		c.JSON(http.StatusForbidden, gin.H{"code": http.StatusForbidden})
+		return
+	}
+
+	clientData := struct {
+		Reason string `json:"reason"`
+	}{}
+	_ = c.ShouldBindJSON(&clientData)
+
+	a.cancelWithdrawal(c, storage.WithdrawalCancel{
+		UserID:      a.currentUserID(c),
+		OrderNumber: c.Param("number"),
+		Reason:      strings.TrimSpace(clientData.Reason),
+		Window:      a.Config.WithdrawalCancelWindow,
+	})
+}
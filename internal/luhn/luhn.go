@@ -0,0 +1,61 @@
+// Package luhn проверяет и вычисляет контрольную цифру по алгоритму Луна
+// для строк из цифр произвольной длины.
+package luhn
+
+import "errors"
+
+var ErrorNotDigits = errors.New("luhn: number must contain only digits")
+
+func isDigits(number string) bool {
+	if number == "" {
+		return false
+	}
+	for i := 0; i < len(number); i++ {
+		if number[i] < '0' || number[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// sum считает сумму Луна; double задаёт, удваивается ли последняя цифра.
+func sum(number string, double bool) int {
+	var luhn int
+	for i := len(number) - 1; i >= 0; i-- {
+		cur := int(number[i] - '0')
+		if double {
+			cur = cur * 2
+			if cur > 9 {
+				cur -= 9
+			}
+		}
+		luhn += cur
+		double = !double
+	}
+	return luhn
+}
+
+// Validate сообщает, является ли последняя цифра number верной контрольной цифрой.
+func Validate(number string) bool {
+	if len(number) < 2 || !isDigits(number) {
+		return false
+	}
+	return sum(number, false)%10 == 0
+}
+
+// CheckDigit возвращает контрольную цифру для payload.
+func CheckDigit(payload string) (byte, error) {
+	if !isDigits(payload) {
+		return 0, ErrorNotDigits
+	}
+	return byte('0' + (10-sum(payload, true)%10)%10), nil
+}
+
+// Generate дописывает к payload контрольную цифру.
+func Generate(payload string) (string, error) {
+	digit, err := CheckDigit(payload)
+	if err != nil {
+		return "", err
+	}
+	return payload + string(digit), nil
+}
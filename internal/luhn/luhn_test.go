@@ -0,0 +1,76 @@
+package luhn
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		number string
+		want   bool
+	}{
+		{"79927398713", true},
+		{"12345678903", true},
+		{"4561261212345467", true},
+		{"4111111111111111", true},
+		{"0000000000", true},
+		{"18", true},
+		{"79927398710", false},
+		{"12345678904", false},
+		{"4111111111111112", false},
+		{"0", false}, // одной контрольной цифры мало
+		{"", false},
+		{"1234-5678-903", false},
+		{" 12345678903", false},
+		{"１２３４５６７８９０３", false}, // полноширинные цифры — не ASCII
+		// длиннее int64: номер проверяется как строка
+		{"123456789012345678901234567891", true},
+		{"123456789012345678901234567890", false},
+	}
+	for _, tt := range tests {
+		if got := Validate(tt.number); got != tt.want {
+			t.Errorf("Validate(%q) = %v, want %v", tt.number, got, tt.want)
+		}
+	}
+}
+
+func TestCheckDigit(t *testing.T) {
+	tests := []struct {
+		payload string
+		want    byte
+		wantErr error
+	}{
+		{"7992739871", '3', nil},
+		{"1234567890", '3', nil},
+		{"456126121234546", '7', nil},
+		{"0", '0', nil},
+		{"", 0, ErrorNotDigits},
+		{"12a4", 0, ErrorNotDigits},
+	}
+	for _, tt := range tests {
+		got, err := CheckDigit(tt.payload)
+		if !errors.Is(err, tt.wantErr) || got != tt.want {
+			t.Errorf("CheckDigit(%q) = %q, %v, want %q, %v", tt.payload, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	for _, payload := range []string{"0", "1", "42", "7992739871", strings.Repeat("9", 40)} {
+		number, err := Generate(payload)
+		if err != nil {
+			t.Fatalf("Generate(%q): %v", payload, err)
+		}
+		if !strings.HasPrefix(number, payload) || len(number) != len(payload)+1 {
+			t.Errorf("Generate(%q) = %q", payload, number)
+		}
+		if !Validate(number) {
+			t.Errorf("Validate(Generate(%q)) = false", payload)
+		}
+	}
+	if _, err := Generate("12 3"); !errors.Is(err, ErrorNotDigits) {
+		t.Errorf("Generate with spaces: %v", err)
+	}
+}
@@ -0,0 +1,72 @@
+// Package notify доставляет пользователям служебные сообщения:
+// токены сброса пароля, предупреждения безопасности и т.п.
+package notify
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+type Message struct {
+	UserID  int       `json:"user_id"`
+	Login   string    `json:"login"`
+	Subject string    `json:"subject"`
+	Body    string    `json:"body"`
+	SentAt  time.Time `json:"sent_at"`
+}
+
+type Notifier interface {
+	Notify(msg Message) error
+}
+
+// LogNotifier пишет сообщения в лог, подходит для разработки.
+type LogNotifier struct{}
+
+func (LogNotifier) Notify(msg Message) error {
+	log.Printf("NOTIFY: user %d (%s): %s: %s", msg.UserID, msg.Login, msg.Subject, msg.Body)
+	return nil
+}
+
+// FileNotifier дописывает сообщения в файл в формате JSON Lines.
+type FileNotifier struct {
+	mu   sync.Mutex
+	path string
+}
+
+func NewFileNotifier(path string) *FileNotifier {
+	return &FileNotifier{path: path}
+}
+
+func (n *FileNotifier) Notify(msg Message) error {
+	if msg.SentAt.IsZero() {
+		msg.SentAt = time.Now().UTC()
+	}
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	f, err := os.OpenFile(n.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(b, '\n'))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// New возвращает FileNotifier, если задан путь, иначе LogNotifier.
+func New(path string) Notifier {
+	if path == "" {
+		return LogNotifier{}
+	}
+	return NewFileNotifier(path)
+}
@@ -0,0 +1,454 @@
+// Package openapi отдаёт описание API в формате OpenAPI 3 и проверяет по нему запросы и ответы.
+// Проверяется только используемое в openapi.json подмножество JSON Schema.
+package openapi
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"math"
+	"mime"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed openapi.json
+var document []byte
+
+const (
+	refSchemaPrefix   = "#/components/schemas/"
+	refResponsePrefix = "#/components/responses/"
+)
+
+type Violation struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func (v Violation) String() string {
+	return v.Field + ": " + v.Message
+}
+
+type Schema struct {
+	Ref              string             `json:"$ref"`
+	Type             string             `json:"type"`
+	Format           string             `json:"format"`
+	Enum             []interface{}      `json:"enum"`
+	Properties       map[string]*Schema `json:"properties"`
+	Required         []string           `json:"required"`
+	Items            *Schema            `json:"items"`
+	OneOf            []*Schema          `json:"oneOf"`
+	Minimum          *float64           `json:"minimum"`
+	Maximum          *float64           `json:"maximum"`
+	ExclusiveMinimum bool               `json:"exclusiveMinimum"`
+	MinLength        *int               `json:"minLength"`
+	MinItems         *int               `json:"minItems"`
+	Pattern          string             `json:"pattern"`
+	Nullable         bool               `json:"nullable"`
+
+	pattern *regexp.Regexp
+}
+
+type Parameter struct {
+	Name     string  `json:"name"`
+	In       string  `json:"in"`
+	Required bool    `json:"required"`
+	Schema   *Schema `json:"schema"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+type RequestBody struct {
+	Required bool                 `json:"required"`
+	Content  map[string]MediaType `json:"content"`
+}
+
+type Response struct {
+	Ref         string               `json:"$ref"`
+	Description string               `json:"description"`
+	Content     map[string]MediaType `json:"content"`
+}
+
+type Operation struct {
+	Summary     string               `json:"summary"`
+	Parameters  []Parameter          `json:"parameters"`
+	RequestBody *RequestBody         `json:"requestBody"`
+	Responses   map[string]*Response `json:"responses"`
+	Optional    bool                 `json:"x-optional"` // маршрут есть не во всех конфигурациях, например вход через SSO
+}
+
+// Streaming сообщает, что успешный ответ операции — поток, который нельзя буферизовать.
+func (o *Operation) Streaming() bool {
+	for _, r := range o.Responses {
+		if _, ok := r.Content["text/event-stream"]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+type Spec struct {
+	raw        []byte
+	operations map[string]*Operation // ключ — "GET /user/orders/{number}"
+	schemas    map[string]*Schema
+}
+
+func Load() (*Spec, error) {
+	return Parse(document)
+}
+
+func Parse(raw []byte) (*Spec, error) {
+	var doc struct {
+		Paths      map[string]map[string]*Operation `json:"paths"`
+		Components struct {
+			Schemas   map[string]*Schema   `json:"schemas"`
+			Responses map[string]*Response `json:"responses"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return nil, err
+	}
+
+	s := &Spec{
+		raw:        raw,
+		operations: map[string]*Operation{},
+		schemas:    doc.Components.Schemas,
+	}
+	for _, schema := range s.schemas {
+		if err := s.compile(schema); err != nil {
+			return nil, err
+		}
+	}
+
+	for path, item := range doc.Paths {
+		for method, op := range item {
+			for _, p := range op.Parameters {
+				if err := s.compile(p.Schema); err != nil {
+					return nil, fmt.Errorf("%s %s: %w", method, path, err)
+				}
+			}
+			if op.RequestBody != nil {
+				for _, m := range op.RequestBody.Content {
+					if err := s.compile(m.Schema); err != nil {
+						return nil, fmt.Errorf("%s %s: %w", method, path, err)
+					}
+				}
+			}
+			for status, r := range op.Responses {
+				if r.Ref != "" {
+					ref, ok := doc.Components.Responses[strings.TrimPrefix(r.Ref, refResponsePrefix)]
+					if !ok {
+						return nil, fmt.Errorf("%s %s: unknown response %s", method, path, r.Ref)
+					}
+					op.Responses[status] = ref
+					r = ref
+				}
+				for _, m := range r.Content {
+					if err := s.compile(m.Schema); err != nil {
+						return nil, fmt.Errorf("%s %s: %w", method, path, err)
+					}
+				}
+			}
+			s.operations[strings.ToUpper(method)+" "+path] = op
+		}
+	}
+	return s, nil
+}
+
+// compile проверяет ссылки и собирает регулярные выражения схемы.
+func (s *Spec) compile(schema *Schema) error {
+	if schema == nil {
+		return nil
+	}
+	if schema.Ref != "" {
+		if _, ok := s.schemas[strings.TrimPrefix(schema.Ref, refSchemaPrefix)]; !ok {
+			return fmt.Errorf("unknown schema %s", schema.Ref)
+		}
+		return nil
+	}
+	if schema.Pattern != "" && schema.pattern == nil {
+		re, err := regexp.Compile(schema.Pattern)
+		if err != nil {
+			return err
+		}
+		schema.pattern = re
+	}
+	for _, v := range schema.Properties {
+		if err := s.compile(v); err != nil {
+			return err
+		}
+	}
+	for _, v := range schema.OneOf {
+		if err := s.compile(v); err != nil {
+			return err
+		}
+	}
+	return s.compile(schema.Items)
+}
+
+// JSON возвращает документ в исходном виде.
+func (s *Spec) JSON() []byte {
+	return s.raw
+}
+
+// Operation ищет операцию по методу и шаблону пути в записи OpenAPI (/user/orders/{number}).
+func (s *Spec) Operation(method, path string) *Operation {
+	return s.operations[method+" "+path]
+}
+
+// Operations возвращает ключи всех операций, отсортированные по пути.
+func (s *Spec) Operations() []string {
+	keys := make([]string, 0, len(s.operations))
+	for k := range s.operations {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		pi, pj := keys[i][strings.IndexByte(keys[i], ' ')+1:], keys[j][strings.IndexByte(keys[j], ' ')+1:]
+		if pi != pj {
+			return pi < pj
+		}
+		return keys[i] < keys[j]
+	})
+	return keys
+}
+
+// ValidateRequest проверяет параметры пути и строки запроса и тело. Тело без Content-Type или
+// с незнакомым типом проверяется как text/plain, если операция его принимает, иначе как JSON —
+// так же, как его читают обработчики.
+func (s *Spec) ValidateRequest(op *Operation, pathParams map[string]string, query url.Values, contentType string, body []byte) (violations []Violation) {
+	for _, p := range op.Parameters {
+		var value string
+		var present bool
+		switch p.In {
+		case "path":
+			value, present = pathParams[p.Name]
+		case "query":
+			present = query.Has(p.Name)
+			value = query.Get(p.Name)
+		default:
+			continue
+		}
+		field := p.In + "." + p.Name
+		if !present {
+			if p.Required {
+				violations = append(violations, Violation{field, "is required"})
+			}
+			continue
+		}
+		v, ok := s.coerce(p.Schema, value)
+		if !ok {
+			violations = append(violations, Violation{field, "must be " + s.resolve(p.Schema).Type})
+			continue
+		}
+		violations = append(violations, s.validate(p.Schema, v, field)...)
+	}
+
+	if op.RequestBody == nil {
+		return violations
+	}
+	if len(bytes.TrimSpace(body)) == 0 {
+		if op.RequestBody.Required {
+			violations = append(violations, Violation{"body", "is required"})
+		}
+		return violations
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	media, ok := op.RequestBody.Content[mediaType]
+	if !ok {
+		if media, ok = op.RequestBody.Content["text/plain"]; ok {
+			mediaType = "text/plain"
+		} else if media, ok = op.RequestBody.Content["application/json"]; ok {
+			mediaType = "application/json"
+		} else {
+			return append(violations, Violation{"body", "unsupported content type " + contentType})
+		}
+	}
+	return append(violations, s.validateBody(media, mediaType, body, "body")...)
+}
+
+// ValidateResponse проверяет статус и тело ответа. Незаявленные статусы ошибок
+// сверяются с ответом default.
+func (s *Spec) ValidateResponse(op *Operation, status int, contentType string, body []byte) []Violation {
+	r, ok := op.Responses[strconv.Itoa(status)]
+	if !ok {
+		if status < 400 {
+			return []Violation{{"status", fmt.Sprintf("%d is not documented", status)}}
+		}
+		if r, ok = op.Responses["default"]; !ok {
+			return nil
+		}
+	}
+	if len(r.Content) == 0 || len(body) == 0 {
+		return nil
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	media, ok := r.Content[mediaType]
+	if !ok {
+		return []Violation{{"content-type", mediaType + " is not documented"}}
+	}
+	return s.validateBody(media, mediaType, body, "response")
+}
+
+func (s *Spec) validateBody(media MediaType, mediaType string, body []byte, field string) []Violation {
+	if media.Schema == nil {
+		return nil
+	}
+	if !strings.HasSuffix(mediaType, "json") {
+		if s.resolve(media.Schema).Type == "string" {
+			return s.validate(media.Schema, strings.TrimSpace(string(body)), field)
+		}
+		return nil
+	}
+
+	var v interface{}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&v); err != nil {
+		return []Violation{{field, "invalid JSON: " + err.Error()}}
+	}
+	return s.validate(media.Schema, v, field)
+}
+
+func (s *Spec) resolve(schema *Schema) *Schema {
+	for schema != nil && schema.Ref != "" {
+		schema = s.schemas[strings.TrimPrefix(schema.Ref, refSchemaPrefix)]
+	}
+	return schema
+}
+
+// coerce приводит строковое значение параметра к типу схемы.
+func (s *Spec) coerce(schema *Schema, value string) (interface{}, bool) {
+	switch s.resolve(schema).Type {
+	case "integer", "number":
+		if _, err := strconv.ParseFloat(value, 64); err != nil {
+			return nil, false
+		}
+		return json.Number(value), true
+	case "boolean":
+		b, err := strconv.ParseBool(value)
+		return b, err == nil
+	}
+	return value, true
+}
+
+func (s *Spec) validate(schema *Schema, v interface{}, field string) (violations []Violation) {
+	schema = s.resolve(schema)
+	if schema == nil {
+		return nil
+	}
+	if v == nil {
+		if schema.Nullable || schema.Type == "" {
+			return nil
+		}
+		return []Violation{{field, "must not be null"}}
+	}
+
+	if len(schema.OneOf) > 0 {
+		matched := 0
+		for _, variant := range schema.OneOf {
+			if len(s.validate(variant, v, field)) == 0 {
+				matched++
+			}
+		}
+		if matched != 1 {
+			return []Violation{{field, "must match exactly one schema"}}
+		}
+		return nil
+	}
+
+	if len(schema.Enum) > 0 && !inEnum(schema.Enum, v) {
+		return []Violation{{field, fmt.Sprintf("must be one of %v", schema.Enum)}}
+	}
+
+	switch schema.Type {
+	case "object":
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return []Violation{{field, "must be an object"}}
+		}
+		for _, name := range schema.Required {
+			if _, ok := obj[name]; !ok {
+				violations = append(violations, Violation{field + "." + name, "is required"})
+			}
+		}
+		names := make([]string, 0, len(obj))
+		for name := range obj {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if prop, ok := schema.Properties[name]; ok {
+				violations = append(violations, s.validate(prop, obj[name], field+"."+name)...)
+			}
+		}
+	case "array":
+		arr, ok := v.([]interface{})
+		if !ok {
+			return []Violation{{field, "must be an array"}}
+		}
+		if schema.MinItems != nil && len(arr) < *schema.MinItems {
+			violations = append(violations, Violation{field, fmt.Sprintf("must contain at least %d items", *schema.MinItems)})
+		}
+		for i, item := range arr {
+			violations = append(violations, s.validate(schema.Items, item, fmt.Sprintf("%s[%d]", field, i))...)
+		}
+	case "string":
+		str, ok := v.(string)
+		if !ok {
+			return []Violation{{field, "must be a string"}}
+		}
+		if schema.MinLength != nil && len([]rune(str)) < *schema.MinLength {
+			violations = append(violations, Violation{field, fmt.Sprintf("must be at least %d characters", *schema.MinLength)})
+		}
+		if schema.pattern != nil && !schema.pattern.MatchString(str) {
+			violations = append(violations, Violation{field, "must match " + schema.Pattern})
+		}
+		if schema.Format == "date-time" {
+			if _, err := time.Parse(time.RFC3339, str); err != nil {
+				violations = append(violations, Violation{field, "must be an RFC 3339 date-time"})
+			}
+		}
+	case "integer", "number":
+		num, ok := v.(json.Number)
+		if !ok {
+			return []Violation{{field, "must be a " + schema.Type}}
+		}
+		f, err := num.Float64()
+		if err != nil || (schema.Type == "integer" && f != math.Trunc(f)) {
+			return []Violation{{field, "must be a " + schema.Type}}
+		}
+		if schema.Minimum != nil {
+			if schema.ExclusiveMinimum && f <= *schema.Minimum {
+				violations = append(violations, Violation{field, fmt.Sprintf("must be greater than %v", *schema.Minimum)})
+			} else if f < *schema.Minimum {
+				violations = append(violations, Violation{field, fmt.Sprintf("must be at least %v", *schema.Minimum)})
+			}
+		}
+		if schema.Maximum != nil && f > *schema.Maximum {
+			violations = append(violations, Violation{field, fmt.Sprintf("must be at most %v", *schema.Maximum)})
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			return []Violation{{field, "must be a boolean"}}
+		}
+	}
+	return violations
+}
+
+func inEnum(enum []interface{}, v interface{}) bool {
+	for _, e := range enum {
+		if fmt.Sprint(e) == fmt.Sprint(v) {
+			return true
+		}
+	}
+	return false
+}
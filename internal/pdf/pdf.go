@@ -0,0 +1,120 @@
+// Package pdf собирает простые текстовые PDF-документы моноширинным шрифтом без внешних зависимостей.
+//
+// Используется встроенный шрифт Courier в кодировке WinAnsi, символы вне Windows-1252 заменяются на "?".
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"golang.org/x/text/encoding/charmap"
+	"io"
+	"strings"
+)
+
+const (
+	pageWidth  = 595 // A4 в пунктах
+	pageHeight = 842
+	margin     = 40
+	fontSize   = 9
+	leading    = 12
+
+	// LineWidth — сколько символов Courier 9pt помещается в строку между полями.
+	LineWidth = (pageWidth - 2*margin) * 1000 / (fontSize * 600)
+)
+
+var linesPerPage = (pageHeight - 2*margin) / leading
+
+type Document struct {
+	lines []string
+}
+
+func New() *Document {
+	return &Document{}
+}
+
+// Line добавляет строку, слишком длинная обрезается по ширине страницы.
+func (d *Document) Line(format string, args ...interface{}) {
+	text := fmt.Sprintf(format, args...)
+	if r := []rune(text); len(r) > LineWidth {
+		text = string(r[:LineWidth])
+	}
+	d.lines = append(d.lines, text)
+}
+
+func escape(text string) string {
+	var b strings.Builder
+	for _, r := range text {
+		c, ok := charmap.Windows1252.EncodeRune(r)
+		switch {
+		case !ok:
+			b.WriteByte('?')
+		case c == '\\' || c == '(' || c == ')':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c == '\r' || c == '\n':
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+func (d *Document) pages() [][]string {
+	var pages [][]string
+	for start := 0; start < len(d.lines); start += linesPerPage {
+		end := start + linesPerPage
+		if end > len(d.lines) {
+			end = len(d.lines)
+		}
+		pages = append(pages, d.lines[start:end])
+	}
+	if len(pages) == 0 {
+		pages = append(pages, nil)
+	}
+	return pages
+}
+
+// WriteTo записывает документ. Объекты: 1 — каталог, 2 — дерево страниц, 3 — шрифт,
+// дальше по паре «страница, содержимое» на каждую страницу.
+func (d *Document) WriteTo(w io.Writer) (int64, error) {
+	var buf bytes.Buffer
+	var offsets []int
+
+	object := func(body string) {
+		offsets = append(offsets, buf.Len())
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	pages := d.pages()
+	kids := make([]string, 0, len(pages))
+	for i := range pages {
+		kids = append(kids, fmt.Sprintf("%d 0 R", 4+2*i))
+	}
+
+	buf.WriteString("%PDF-1.4\n")
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pages)))
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Courier /Encoding /WinAnsiEncoding >>")
+
+	for i, lines := range pages {
+		var content bytes.Buffer
+		fmt.Fprintf(&content, "BT\n/F1 %d Tf\n%d TL\n%d %d Td\n", fontSize, leading, margin, pageHeight-margin-fontSize)
+		for _, line := range lines {
+			fmt.Fprintf(&content, "(%s) '\n", escape(line))
+		}
+		content.WriteString("ET")
+
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] /Resources << /Font << /F1 3 0 R >> >> /Contents %d 0 R >>",
+			pageWidth, pageHeight, 5+2*i))
+		object(fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", content.Len(), content.String()))
+	}
+
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+
+	return buf.WriteTo(w)
+}
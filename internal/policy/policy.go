@@ -0,0 +1,131 @@
+// Package policy проверяет логины и пароли при регистрации и смене пароля.
+package policy
+
+import (
+	"bufio"
+	_ "embed"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+//go:embed common_passwords.txt
+var commonPasswords string
+
+const (
+	RuleRequired   = "required"
+	RuleMinLength  = "min_length"
+	RuleMaxLength  = "max_length"
+	RuleCharset    = "charset"
+	RuleBlocklist  = "blocklist"
+	RuleSameAsUser = "same_as_login"
+)
+
+type Config struct {
+	LoginMinLength        int
+	LoginMaxLength        int
+	LoginPattern          string // допустимые символы логина, регулярное выражение
+	PasswordMinLength     int
+	PasswordBlocklistFile string // дополнительный список запрещённых паролей, по одному в строке
+}
+
+type Violation struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+type Policy struct {
+	config    Config
+	pattern   *regexp.Regexp
+	blocklist map[string]struct{}
+}
+
+func New(config Config) (*Policy, error) {
+	p := &Policy{
+		config:    config,
+		blocklist: map[string]struct{}{},
+	}
+
+	if config.LoginPattern != "" {
+		pattern, err := regexp.Compile(config.LoginPattern)
+		if err != nil {
+			return nil, fmt.Errorf("login pattern: %w", err)
+		}
+		p.pattern = pattern
+	}
+
+	_ = p.addBlocklist(strings.NewReader(commonPasswords))
+	if config.PasswordBlocklistFile != "" {
+		f, err := os.Open(config.PasswordBlocklistFile)
+		if err != nil {
+			return nil, fmt.Errorf("password blocklist: %w", err)
+		}
+		defer f.Close()
+		if err = p.addBlocklist(f); err != nil {
+			return nil, fmt.Errorf("password blocklist: %w", err)
+		}
+	}
+
+	return p, nil
+}
+
+func (p *Policy) addBlocklist(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			p.blocklist[NormalizeLogin(line)] = struct{}{}
+		}
+	}
+	return scanner.Err()
+}
+
+// NormalizeLogin приводит логин к каноническому виду: NFKC и свёртка регистра.
+// Логины сравниваются и хранятся только в таком виде.
+func NormalizeLogin(login string) string {
+	login = norm.NFKC.String(strings.TrimSpace(login))
+	return norm.NFKC.String(cases.Fold().String(login))
+}
+
+// ValidateLogin проверяет уже нормализованный логин.
+func (p *Policy) ValidateLogin(login string) (violations []Violation) {
+	length := utf8.RuneCountInString(login)
+	if length == 0 {
+		return append(violations, Violation{"login", RuleRequired, "login is required"})
+	}
+	if p.config.LoginMinLength > 0 && length < p.config.LoginMinLength {
+		violations = append(violations, Violation{"login", RuleMinLength, fmt.Sprintf("login must be at least %d characters", p.config.LoginMinLength)})
+	}
+	if p.config.LoginMaxLength > 0 && length > p.config.LoginMaxLength {
+		violations = append(violations, Violation{"login", RuleMaxLength, fmt.Sprintf("login must be at most %d characters", p.config.LoginMaxLength)})
+	}
+	if p.pattern != nil && !p.pattern.MatchString(login) {
+		violations = append(violations, Violation{"login", RuleCharset, "login contains characters that are not allowed"})
+	}
+	return violations
+}
+
+// ValidatePassword проверяет пароль, login передаётся нормализованным и может быть пустым.
+func (p *Policy) ValidatePassword(password, login string) (violations []Violation) {
+	length := utf8.RuneCountInString(password)
+	if length == 0 {
+		return append(violations, Violation{"password", RuleRequired, "password is required"})
+	}
+	if p.config.PasswordMinLength > 0 && length < p.config.PasswordMinLength {
+		violations = append(violations, Violation{"password", RuleMinLength, fmt.Sprintf("password must be at least %d characters", p.config.PasswordMinLength)})
+	}
+	normalized := NormalizeLogin(password)
+	if _, ok := p.blocklist[normalized]; ok {
+		violations = append(violations, Violation{"password", RuleBlocklist, "password is too common"})
+	}
+	if login != "" && normalized == login {
+		violations = append(violations, Violation{"password", RuleSameAsUser, "password must not match the login"})
+	}
+	return violations
+}
@@ -0,0 +1,112 @@
+package policy
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestNormalizeLogin(t *testing.T) {
+	tests := []struct {
+		login string
+		want  string
+	}{
+		{"alice", "alice"},
+		{"Alice", "alice"},
+		{"  ALICE\t\n", "alice"},
+		{"Алиса", "алиса"},
+		{"ＡＬＩＣＥ", "alice"},     // полноширинные буквы (NFKC)
+		{"ａｌｉｃｅ１２", "alice12"}, // и цифры
+		{"ﬁona", "fiona"},      // лигатура раскладывается
+		{"Straße", "strasse"},  // полная свёртка регистра, а не lower
+		{"STRASSE", "strasse"}, // и совпадает с записью заглавными
+		{"ΟΔΥΣΣΕΥΣ", "οδυσσευσ"},
+		{"Οδυσσευς", "οδυσσευσ"},    // финальная сигма
+		{"Cafe\u0301", "caf\u00e9"}, // e + U+0301 собирается в é
+		{"Caf\u00e9", "caf\u00e9"},
+		{"Bob Smith", "bob smith"}, // пробелы внутри остаются, их отсекает шаблон
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := NormalizeLogin(tt.login); got != tt.want {
+			t.Errorf("NormalizeLogin(%q) = %q, want %q", tt.login, got, tt.want)
+		}
+		if got := NormalizeLogin(tt.want); got != tt.want {
+			t.Errorf("NormalizeLogin(%q) is not idempotent: %q", tt.want, got)
+		}
+	}
+}
+
+func rules(violations []Violation) (result []string) {
+	for _, v := range violations {
+		result = append(result, v.Rule)
+	}
+	return result
+}
+
+func TestValidateLogin(t *testing.T) {
+	p, err := New(Config{LoginMinLength: 3, LoginMaxLength: 10, LoginPattern: `^[\p{L}\p{N}._@+-]+$`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		login string
+		want  []string
+	}{
+		{"alice", nil},
+		{"алиса", nil}, // длина в символах, а не в байтах
+		{"a.b@c+d-e", nil},
+		{"", []string{RuleRequired}},
+		{"al", []string{RuleMinLength}},
+		{"alice-in-wonderland", []string{RuleMaxLength}},
+		{"bob smith", []string{RuleCharset}},
+		{"a b", []string{RuleCharset}},
+		{"a b c d e f g", []string{RuleMaxLength, RuleCharset}},
+	}
+	for _, tt := range tests {
+		if got := rules(p.ValidateLogin(tt.login)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ValidateLogin(%q) = %v, want %v", tt.login, got, tt.want)
+		}
+	}
+}
+
+func TestValidatePassword(t *testing.T) {
+	blocklist := filepath.Join(t.TempDir(), "blocklist.txt")
+	if err := os.WriteFile(blocklist, []byte("gophermart2024\n\n  Loyalty-Points  \n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	p, err := New(Config{PasswordMinLength: 8, PasswordBlocklistFile: blocklist})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		password string
+		login    string
+		want     []string
+	}{
+		{"correct-horse-battery", "alice", nil},
+		{"", "alice", []string{RuleRequired}},
+		{"short", "alice", []string{RuleMinLength}},
+		{"12345678", "alice", []string{RuleBlocklist}},
+		{"123456", "alice", []string{RuleMinLength, RuleBlocklist}},
+		{"GOPHERMART2024", "alice", []string{RuleBlocklist}}, // из своего списка, без учёта регистра
+		{"loyalty-points", "alice", []string{RuleBlocklist}},
+		{"Alice-Liddell", "alice-liddell", []string{RuleSameAsUser}},
+		{"alice-liddell", "", nil}, // логин не передан при смене пароля
+	}
+	for _, tt := range tests {
+		if got := rules(p.ValidatePassword(tt.password, tt.login)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ValidatePassword(%q, %q) = %v, want %v", tt.password, tt.login, got, tt.want)
+		}
+	}
+}
+
+func TestNewErrors(t *testing.T) {
+	if _, err := New(Config{LoginPattern: "["}); err == nil {
+		t.Error("invalid login pattern accepted")
+	}
+	if _, err := New(Config{PasswordBlocklistFile: filepath.Join(t.TempDir(), "missing.txt")}); err == nil {
+		t.Error("missing blocklist file accepted")
+	}
+}
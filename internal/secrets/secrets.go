@@ -0,0 +1,203 @@
+// Package secrets загружает секреты из окружения или файлов, разбирает ключи подписи
+// и шифрования сессионных cookie и шифрует секреты, хранящиеся в базе.
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const (
+	HashKeySize  = 64 // ключ HMAC-SHA256 для подписи cookie
+	BlockKeySize = 32 // ключ AES-256 для шифрования cookie
+
+	sealedPrefix = "enc:"
+	legacyPrefix = "raw:"
+)
+
+var (
+	ErrorNoKeys       = errors.New("no session keys configured")
+	ErrorNoSealingKey = errors.New("value is encrypted but no key is configured")
+)
+
+// Lookup возвращает значение переменной окружения name или, если она не задана,
+// содержимое файла из переменной name_FILE (как принято для docker/k8s secrets).
+func Lookup(name string) (string, error) {
+	if v, ok := os.LookupEnv(name); ok {
+		return v, nil
+	}
+	path := os.Getenv(name + "_FILE")
+	if path == "" {
+		return "", nil
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// KeyPair — ключ подписи и необязательный ключ шифрования cookie.
+type KeyPair struct {
+	HashKey  []byte
+	BlockKey []byte
+	Legacy   bool // ключ из прежней конфигурации в виде строки, только для проверки старых cookie
+}
+
+func (k KeyPair) String() string {
+	if k.Legacy {
+		return legacyPrefix + string(k.HashKey)
+	}
+	if len(k.BlockKey) == 0 {
+		return hex.EncodeToString(k.HashKey)
+	}
+	return hex.EncodeToString(k.HashKey) + ":" + hex.EncodeToString(k.BlockKey)
+}
+
+func GenerateKeyPair() (k KeyPair, err error) {
+	k.HashKey = make([]byte, HashKeySize)
+	if _, err = rand.Read(k.HashKey); err != nil {
+		return k, err
+	}
+	k.BlockKey = make([]byte, BlockKeySize)
+	_, err = rand.Read(k.BlockKey)
+	return k, err
+}
+
+// ParseKeyPairs разбирает список пар "hash:block" в hex, разделённых запятыми
+// или переводами строк. Первая пара используется для новых cookie, остальные
+// только для проверки ранее выданных. Строки, начинающиеся с #, пропускаются.
+// Запись "raw:<строка>" — прежний ключ подписи как есть, без hex (так он задавался
+// до SESSION_KEYS); он допускается только для проверки, не первым.
+func ParseKeyPairs(s string) (pairs []KeyPair, err error) {
+	fields := strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || r == '\n' || r == '\r'
+	})
+	for _, field := range fields {
+		field = strings.TrimSpace(field)
+		if field == "" || strings.HasPrefix(field, "#") {
+			continue
+		}
+
+		var pair KeyPair
+		if strings.HasPrefix(field, legacyPrefix) {
+			if len(pairs) == 0 {
+				return nil, fmt.Errorf("session key 1: legacy key can only verify old cookies, put a new key first")
+			}
+			pair.HashKey = []byte(strings.TrimPrefix(field, legacyPrefix))
+			pair.Legacy = true
+			if len(pair.HashKey) == 0 {
+				return nil, fmt.Errorf("session key %d: legacy key is empty", len(pairs)+1)
+			}
+			pairs = append(pairs, pair)
+			continue
+		}
+
+		hashKey, blockKey, _ := strings.Cut(field, ":")
+		pair.HashKey, err = hex.DecodeString(hashKey)
+		if err != nil {
+			return nil, fmt.Errorf("session key %d: hash key: %w", len(pairs)+1, err)
+		}
+		if len(pair.HashKey) < 32 {
+			return nil, fmt.Errorf("session key %d: hash key must be at least 32 bytes", len(pairs)+1)
+		}
+		if blockKey != "" {
+			pair.BlockKey, err = hex.DecodeString(blockKey)
+			if err != nil {
+				return nil, fmt.Errorf("session key %d: block key: %w", len(pairs)+1, err)
+			}
+			switch len(pair.BlockKey) {
+			case 16, 24, 32:
+			default:
+				return nil, fmt.Errorf("session key %d: block key must be 16, 24 or 32 bytes", len(pairs)+1)
+			}
+		}
+		pairs = append(pairs, pair)
+	}
+	if len(pairs) == 0 {
+		return nil, ErrorNoKeys
+	}
+	return pairs, nil
+}
+
+// CookieKeys раскладывает пары в аргументы для gorilla securecookie.
+func CookieKeys(pairs []KeyPair) (keys [][]byte) {
+	for _, pair := range pairs {
+		keys = append(keys, pair.HashKey, pair.BlockKey)
+	}
+	return keys
+}
+
+// ParseSealingKey разбирает ключ AES-256 в hex для Seal и Open, пустая строка — шифрование выключено.
+func ParseSealingKey(s string) (key []byte, err error) {
+	if s == "" {
+		return nil, nil
+	}
+	key, err = hex.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != BlockKeySize {
+		return nil, fmt.Errorf("sealing key must be %d bytes", BlockKeySize)
+	}
+	return key, nil
+}
+
+// Seal шифрует value в AES-GCM и помечает результат префиксом "enc:". Без ключа value возвращается как есть.
+func Seal(key []byte, value string) (string, error) {
+	if len(key) == 0 {
+		return value, nil
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(value), nil)
+	return sealedPrefix + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// Open расшифровывает значение из Seal. Значения без префикса (записанные до включения шифрования)
+// возвращаются как есть.
+func Open(key []byte, value string) (string, error) {
+	if !strings.HasPrefix(value, sealedPrefix) {
+		return value, nil
+	}
+	if len(key) == 0 {
+		return "", ErrorNoSealingKey
+	}
+	sealed, err := base64.RawStdEncoding.DecodeString(strings.TrimPrefix(value, sealedPrefix))
+	if err != nil {
+		return "", err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return "", errors.New("sealed value is too short")
+	}
+	b, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,140 @@
+package secrets
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestSealOpen(t *testing.T) {
+	key := []byte(strings.Repeat("k", BlockKeySize))
+	otherKey := []byte(strings.Repeat("o", BlockKeySize))
+
+	sealed, err := Seal(key, "JBSWY3DPEHPK3PXP")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(sealed, sealedPrefix) || strings.Contains(sealed, "JBSWY3DPEHPK3PXP") {
+		t.Fatalf("Seal() = %q", sealed)
+	}
+	again, _ := Seal(key, "JBSWY3DPEHPK3PXP")
+	if again == sealed {
+		t.Error("Seal() reuses the nonce")
+	}
+
+	tests := []struct {
+		name    string
+		key     []byte
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{"sealed", key, sealed, "JBSWY3DPEHPK3PXP", false},
+		{"plain value written before the key", key, "JBSWY3DPEHPK3PXP", "JBSWY3DPEHPK3PXP", false},
+		{"plain value without key", nil, "JBSWY3DPEHPK3PXP", "JBSWY3DPEHPK3PXP", false},
+		{"sealed without key", nil, sealed, "", true},
+		{"wrong key", otherKey, sealed, "", true},
+		{"tampered", key, sealed[:len(sealed)-2] + "AA", "", true},
+		{"truncated", key, sealedPrefix + "AAAA", "", true},
+		{"not base64", key, sealedPrefix + "%%%", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Open(tt.key, tt.value)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("Open() = %q, %v, want %q, error %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+
+	if _, err = Open(nil, sealed); !errors.Is(err, ErrorNoSealingKey) {
+		t.Errorf("Open without key: %v", err)
+	}
+	if plain, _ := Seal(nil, "JBSWY3DPEHPK3PXP"); plain != "JBSWY3DPEHPK3PXP" {
+		t.Errorf("Seal without key = %q", plain)
+	}
+}
+
+func TestParseSealingKey(t *testing.T) {
+	tests := []struct {
+		in      string
+		wantLen int
+		wantErr bool
+	}{
+		{"", 0, false},
+		{strings.Repeat("ab", BlockKeySize), BlockKeySize, false},
+		{strings.Repeat("ab", 16), 0, true},
+		{strings.Repeat("zz", BlockKeySize), 0, true},
+	}
+	for _, tt := range tests {
+		key, err := ParseSealingKey(tt.in)
+		if (err != nil) != tt.wantErr || len(key) != tt.wantLen {
+			t.Errorf("ParseSealingKey(%q) = %d bytes, %v", tt.in, len(key), err)
+		}
+	}
+}
+
+func TestParseKeyPairs(t *testing.T) {
+	hash := strings.Repeat("ab", HashKeySize)
+	block := strings.Repeat("cd", BlockKeySize)
+	legacy := "raw:49a8aca82c132d8d1f430e32be1e6ff3"
+
+	tests := []struct {
+		name    string
+		in      string
+		want    []string // String() разобранных пар
+		wantErr bool
+	}{
+		{"hash and block", hash + ":" + block, []string{hash + ":" + block}, false},
+		{"hash only", hash, []string{hash}, false},
+		{"rotation", hash + ":" + block + ",\n " + hash, []string{hash + ":" + block, hash}, false},
+		{"comments and blank lines", "# new\n" + hash + "\n\n# old\n" + hash + ":" + block + "\n", []string{hash, hash + ":" + block}, false},
+		{"legacy key to verify old cookies", hash + ":" + block + "," + legacy, []string{hash + ":" + block, legacy}, false},
+		{"legacy key first", legacy + "," + hash, nil, true},
+		{"legacy key alone", legacy, nil, true},
+		{"empty legacy key", hash + ",raw:", nil, true},
+		{"legacy key without prefix", hash + ",49a8aca82c132d8d1f430e32be1e6ff3", nil, true},
+		{"minimal hash key", strings.Repeat("ab", 32), []string{strings.Repeat("ab", 32)}, false},
+		{"too short hash key", strings.Repeat("ab", 31), nil, true},
+		{"bad block key size", hash + ":" + strings.Repeat("cd", 20), nil, true},
+		{"not hex", strings.Repeat("zz", HashKeySize), nil, true},
+		{"empty", " \n# nothing\n", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pairs, err := ParseKeyPairs(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseKeyPairs() error = %v, want error %v", err, tt.wantErr)
+			}
+			var got []string
+			for _, v := range pairs {
+				got = append(got, v.String())
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("ParseKeyPairs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := ParseKeyPairs(""); !errors.Is(err, ErrorNoKeys) {
+		t.Errorf("ParseKeyPairs(\"\") = %v, want ErrorNoKeys", err)
+	}
+
+	// прежний ключ проверяет cookie как есть, байтами строки, и не шифрует их
+	pairs, _ := ParseKeyPairs(hash + "," + legacy)
+	keys := CookieKeys(pairs)
+	if len(keys) != 4 || string(keys[2]) != "49a8aca82c132d8d1f430e32be1e6ff3" || keys[3] != nil || !pairs[1].Legacy {
+		t.Errorf("CookieKeys() = %q", keys)
+	}
+}
+
+func TestGenerateKeyPair(t *testing.T) {
+	pair, err := GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := ParseKeyPairs(pair.String())
+	if err != nil || len(parsed) != 1 || parsed[0].String() != pair.String() {
+		t.Errorf("ParseKeyPairs(GenerateKeyPair()) = %v, %v", parsed, err)
+	}
+}
@@ -0,0 +1,203 @@
+package storage
+
+import (
+	"errors"
+	"github.com/jackc/pgx/v4"
+	"time"
+)
+
+type BalanceAdjustment struct {
+	ID        int
+	UserID    int
+	ActorID   int
+	Amount    float64 // положительное — начисление, отрицательное — списание
+	Reason    string
+	CreatedAt time.Time
+}
+
+type AuditEntry struct {
+	ID           int
+	ActorID      int
+	Action       string
+	TargetUserID int
+	Target       string
+	Details      string
+	Status       int
+	CreatedAt    time.Time
+}
+
+func nullableID(id int) *int {
+	if id == 0 {
+		return nil
+	}
+	return &id
+}
+
+func (d *Database) SearchUsersByLogin(query string, limit int) (users []UserTable, err error) {
+	sql := "SELECT id,login,role,frozen,balance,withdrawn FROM users WHERE strpos(lower(login), lower($1)) > 0 ORDER BY login LIMIT $2"
+	rows, err := d.pgx.Query(d.ctx, sql, query, limit)
+	if err != nil {
+		return users, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var user UserTable
+		err = rows.Scan(&user.ID, &user.Login, &user.Role, &user.Frozen, &user.Balance, &user.Withdrawn)
+		if err != nil {
+			return users, err
+		}
+		users = append(users, user)
+	}
+	if err = rows.Err(); err != nil {
+		return users, err
+	}
+
+	return users, err
+}
+
+func (d *Database) SetUserFrozen(userID int, frozen bool) (err error) {
+	sql := "UPDATE users SET frozen=$1 WHERE id=$2"
+	tag, err := d.pgx.Exec(d.ctx, sql, frozen, userID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrorUserNotFound
+	}
+	return nil
+}
+
+// AdjustUserBalance вручную начисляет или списывает баллы и сохраняет причину.
+func (d *Database) AdjustUserBalance(adjustment BalanceAdjustment) (err error) {
+
+	tx, err := d.pgx.Begin(d.ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err == nil {
+			_ = tx.Commit(d.ctx)
+		} else {
+			_ = tx.Rollback(d.ctx)
+		}
+	}()
+
+	var balance float64
+	s1 := "SELECT balance FROM users WHERE id=$1 LIMIT 1 FOR UPDATE"
+	err = tx.QueryRow(d.ctx, s1, adjustment.UserID).Scan(&balance)
+	if err != nil {
+		return ErrorUserNotFound
+	}
+
+	resultBalance := balance + adjustment.Amount
+	if resultBalance < 0 {
+		return ErrorUserBalanceWithdraw
+	}
+
+	s2 := "UPDATE users SET balance=$1 WHERE id=$2"
+	_, err = tx.Exec(d.ctx, s2, resultBalance, adjustment.UserID)
+	if err != nil {
+		return err
+	}
+
+	// ручное начисление не сгорает, а ручное списание расходует партии как обычное
+	if adjustment.Amount < 0 {
+		err = d.consumeAccrualLots(tx, adjustment.UserID, -adjustment.Amount)
+		if err != nil {
+			return err
+		}
+	}
+
+	s3 := "INSERT INTO balance_adjustments (user_id,actor_id,amount,reason,created_at) VALUES ($1, $2, $3, $4, $5)"
+	_, err = tx.Exec(d.ctx, s3, adjustment.UserID, nullableID(adjustment.ActorID), adjustment.Amount, adjustment.Reason, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+
+	return d.notifyBalanceChanged(tx, adjustment.UserID)
+}
+
+// SetOrderStatus меняет статус заказа без начислений. Заказ в финальном статусе не трогается:
+// вернув его в NEW или PROCESSING, система начислений начислила бы баллы второй раз.
+func (d *Database) SetOrderStatus(number, status string) (err error) {
+
+	tx, err := d.pgx.Begin(d.ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err == nil {
+			_ = tx.Commit(d.ctx)
+		} else {
+			_ = tx.Rollback(d.ctx)
+		}
+	}()
+
+	var orderID int
+	var userID int
+	var prevStatus string
+	var accrual float64
+
+	sql := "SELECT id, user_id, status, accrual FROM orders WHERE number = $1 FOR UPDATE"
+	err = tx.QueryRow(d.ctx, sql, number).Scan(&orderID, &userID, &prevStatus, &accrual)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrorOrderNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if status == prevStatus {
+		return nil
+	}
+	if isFinalOrderStatus(prevStatus) {
+		return ErrorOrderFinal
+	}
+
+	now := time.Now().UTC()
+	sql = "UPDATE orders SET status=$1,processed_at=$2 WHERE id=$3"
+	_, err = tx.Exec(d.ctx, sql, status, orderProcessedAt(status, now), orderID)
+	if err != nil {
+		return err
+	}
+
+	return d.createOrderStatusEvent(tx, userID, OrderStatusEvent{
+		OrderID:   orderID,
+		Number:    number,
+		Status:    status,
+		Accrual:   accrual,
+		Source:    OrderSourceAdmin,
+		CreatedAt: now,
+	})
+}
+
+func (d *Database) CreateAuditEntry(entry AuditEntry) (err error) {
+	sql := "INSERT INTO admin_audit_log (actor_id,action,target_user_id,target,details,status,created_at) VALUES ($1, $2, $3, $4, $5, $6, $7)"
+	_, err = d.pgx.Exec(d.ctx, sql, nullableID(entry.ActorID), entry.Action, nullableID(entry.TargetUserID), entry.Target, entry.Details, entry.Status, time.Now().UTC())
+	return err
+}
+
+// GetAuditLog возвращает последние записи журнала, targetUserID = 0 — по всем пользователям.
+func (d *Database) GetAuditLog(targetUserID int, limit int) (entries []AuditEntry, err error) {
+	sql := `SELECT id,COALESCE(actor_id,0),action,COALESCE(target_user_id,0),target,details,status,created_at FROM admin_audit_log
+		WHERE ($1 = 0 OR target_user_id = $1) ORDER BY created_at DESC, id DESC LIMIT $2`
+	rows, err := d.pgx.Query(d.ctx, sql, targetUserID, limit)
+	if err != nil {
+		return entries, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var entry AuditEntry
+		err = rows.Scan(&entry.ID, &entry.ActorID, &entry.Action, &entry.TargetUserID, &entry.Target, &entry.Details, &entry.Status, &entry.CreatedAt)
+		if err != nil {
+			return entries, err
+		}
+		entries = append(entries, entry)
+	}
+	if err = rows.Err(); err != nil {
+		return entries, err
+	}
+
+	return entries, err
+}
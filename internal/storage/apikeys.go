@@ -0,0 +1,69 @@
+package storage
+
+import (
+	"errors"
+	"github.com/jackc/pgx/v4"
+	"time"
+)
+
+type APIKey struct {
+	ID         int
+	UserID     int
+	Name       string
+	Prefix     string
+	Scopes     []string
+	CreatedAt  time.Time
+	LastUsedAt *time.Time
+}
+
+// CreateAPIKey сохраняет ключ; сам секрет хранится только в виде хеша.
+func (d *Database) CreateAPIKey(key APIKey, secret string) (id int, err error) {
+	sql := "INSERT INTO api_keys (user_id,name,prefix,key_hash,scopes,created_at) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id"
+	err = d.pgx.QueryRow(d.ctx, sql, key.UserID, key.Name, key.Prefix, GetSHA256Hash(secret), key.Scopes, time.Now().UTC()).Scan(&id)
+	return id, err
+}
+
+func (d *Database) GetAPIKeysByUserID(userID int) (keys []APIKey, err error) {
+	sql := "SELECT id,user_id,name,prefix,scopes,created_at,last_used_at FROM api_keys WHERE user_id = $1 AND revoked_at IS NULL ORDER BY created_at DESC"
+	rows, err := d.pgx.Query(d.ctx, sql, userID)
+	if err != nil {
+		return keys, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var key APIKey
+		err = rows.Scan(&key.ID, &key.UserID, &key.Name, &key.Prefix, &key.Scopes, &key.CreatedAt, &key.LastUsedAt)
+		if err != nil {
+			return keys, err
+		}
+		keys = append(keys, key)
+	}
+	if err = rows.Err(); err != nil {
+		return keys, err
+	}
+
+	return keys, err
+}
+
+func (d *Database) RevokeAPIKey(userID, id int) (err error) {
+	sql := "UPDATE api_keys SET revoked_at=$1 WHERE id=$2 AND user_id=$3 AND revoked_at IS NULL"
+	tag, err := d.pgx.Exec(d.ctx, sql, time.Now().UTC(), id, userID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrorAPIKeyNotFound
+	}
+	return nil
+}
+
+// UseAPIKey находит действующий ключ по секрету и отмечает время использования.
+func (d *Database) UseAPIKey(secret string) (key APIKey, err error) {
+	sql := "UPDATE api_keys SET last_used_at=$1 WHERE key_hash=$2 AND revoked_at IS NULL RETURNING id,user_id,name,prefix,scopes,created_at,last_used_at"
+	err = d.pgx.QueryRow(d.ctx, sql, time.Now().UTC(), GetSHA256Hash(secret)).Scan(&key.ID, &key.UserID, &key.Name, &key.Prefix, &key.Scopes, &key.CreatedAt, &key.LastUsedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return key, ErrorAPIKeyNotFound
+	}
+	return key, err
+}
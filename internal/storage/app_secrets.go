@@ -0,0 +1,17 @@
+package storage
+
+import "time"
+
+// GetOrCreateAppSecret возвращает секрет сервиса name, а если его ещё нет — сохраняет value.
+// Все экземпляры получают одно и то же значение: первый записавший побеждает.
+func (d *Database) GetOrCreateAppSecret(name, value string) (stored string, err error) {
+	s1 := "INSERT INTO app_secrets (name,value,created_at) VALUES ($1, $2, $3) ON CONFLICT (name) DO NOTHING"
+	_, err = d.pgx.Exec(d.ctx, s1, name, value, time.Now().UTC())
+	if err != nil {
+		return stored, err
+	}
+
+	s2 := "SELECT value FROM app_secrets WHERE name = $1"
+	err = d.pgx.QueryRow(d.ctx, s2, name).Scan(&stored)
+	return stored, err
+}
@@ -0,0 +1,116 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var testSeq int64
+
+// newTestDatabase подключается к базе из TEST_DATABASE_URI и накатывает миграции. Без переменной
+// тест пропускается: в обычном прогоне базы нет. Тесты создают своих пользователей и не мешают
+// друг другу, поэтому базу можно не очищать.
+func newTestDatabase(t *testing.T) *Database {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_URI")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URI is not set")
+	}
+
+	ctx := context.Background()
+	pool, err := pgxpool.Connect(ctx, dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(pool.Close)
+
+	tables, err := os.ReadFile("../../migrations/tables.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = pool.Exec(ctx, string(tables)); err != nil {
+		t.Fatal(err)
+	}
+	return &Database{pgx: pool, ctx: ctx, dsn: dsn}
+}
+
+// testNumber выдаёт уникальный номер заказа или логин для прогона.
+func testNumber() string {
+	return fmt.Sprintf("%d%04d", time.Now().UnixNano(), atomic.AddInt64(&testSeq, 1))
+}
+
+// newTestUser создаёт пользователя, баланс которого сложен из партий lots: pending-партии
+// лежат в users.pending, остальные — в users.balance.
+func newTestUser(t *testing.T, d *Database, lots ...AccrualLot) (userID int) {
+	t.Helper()
+	userID, err := d.CreateUser(UserTable{Login: "test-" + testNumber(), Password: "test-password"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var balance, pending float64
+	for _, lot := range lots {
+		lot.UserID = userID
+		lot.OrderNumber = testNumber()
+		lot.CreatedAt = time.Now().UTC()
+		if err = d.createAccrualLot(d.pgx, lot); err != nil {
+			t.Fatal(err)
+		}
+		if lot.Pending {
+			pending += lot.Amount
+		} else {
+			balance += lot.Amount
+		}
+	}
+
+	_, err = d.pgx.Exec(d.ctx, "UPDATE users SET balance=$1, pending=$2 WHERE id=$3", balance, pending, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return userID
+}
+
+// testDBLot — партия для newTestUser, сгорающая через expiresIn (0 — бессрочная).
+func testDBLot(amount float64, expiresIn time.Duration) AccrualLot {
+	lot := AccrualLot{Amount: amount}
+	if expiresIn != 0 {
+		expiresAt := time.Now().UTC().Add(expiresIn)
+		lot.ExpiresAt = &expiresAt
+	}
+	return lot
+}
+
+// testRemaining возвращает остатки партий пользователя в порядке создания.
+func testRemaining(t *testing.T, d *Database, userID int) (remaining []float64) {
+	t.Helper()
+	rows, err := d.pgx.Query(d.ctx, "SELECT remaining FROM accrual_lots WHERE user_id=$1 ORDER BY id", userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var v float64
+		if err = rows.Scan(&v); err != nil {
+			t.Fatal(err)
+		}
+		remaining = append(remaining, v)
+	}
+	if err = rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return remaining
+}
+
+func testBalance(t *testing.T, d *Database, userID int) UserBalance {
+	t.Helper()
+	balance, err := d.GetUserBalance(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return balance
+}
@@ -3,9 +3,30 @@ package storage
 import "errors"
 
 var (
-	ErrorUserAlreadyExists   = errors.New("user already exists")
-	ErrorUserCredentials     = errors.New("wrong pair login/password")
-	ErrorUserBalanceWithdraw = errors.New("insufficient funds to withdraw")
-	ErrorOrderAlreadyExists  = errors.New("order already exists")
-	ErrorOrderNotFound       = errors.New("order not found")
+	ErrorUserAlreadyExists      = errors.New("user already exists")
+	ErrorUserCredentials        = errors.New("wrong pair login/password")
+	ErrorUserNotFound           = errors.New("user not found")
+	ErrorUserFrozen             = errors.New("user account is frozen")
+	ErrorResetTokenInvalid      = errors.New("password reset token is invalid or expired")
+	ErrorAPIKeyNotFound         = errors.New("api key not found")
+	ErrorWebhookNotFound        = errors.New("webhook not found")
+	ErrorIdentityNotFound       = errors.New("external identity not found")
+	ErrorIdentityLinked         = errors.New("external identity already linked")
+	ErrorUserBalanceWithdraw    = errors.New("insufficient funds to withdraw")
+	ErrorOrderAlreadyExists     = errors.New("order already exists")
+	ErrorOrderNotFound          = errors.New("order not found")
+	ErrorOrderNotReturnable     = errors.New("order is not processed or already fully returned")
+	ErrorOrderFinal             = errors.New("order already has a final status")
+	ErrorReturnAmount           = errors.New("return amount exceeds the remaining accrual")
+	ErrorWithdrawalNotFound     = errors.New("withdrawal not found")
+	ErrorWithdrawalCancelled    = errors.New("withdrawal already cancelled")
+	ErrorWithdrawalCancelWindow = errors.New("withdrawal cancellation window has passed")
+	ErrorHoldNotFound           = errors.New("hold not found")
+	ErrorHoldClosed             = errors.New("hold is already captured, voided or expired")
+	ErrorHoldExpired            = errors.New("hold has expired")
+	ErrorHoldAmount             = errors.New("capture amount exceeds the hold")
+	ErrorTOTPAlreadyEnabled     = errors.New("two-factor authentication already enabled")
+	ErrorTOTPNotEnrolled        = errors.New("two-factor authentication not enrolled")
+	ErrorTOTPCodeReused         = errors.New("one-time code already used")
+	ErrorRecoveryCodeInvalid    = errors.New("invalid recovery code")
 )
@@ -0,0 +1,113 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	ExportRowOrder      string = "order"
+	ExportRowAccrual           = "accrual"
+	ExportRowWithdrawal        = "withdrawal"
+	ExportRowAdjustment        = "adjustment"
+	ExportRowRefund            = "refund"
+	ExportRowExpiry            = "expiry"
+	ExportRowReturn            = "return"
+	ExportRowRecovery          = "recovery"
+)
+
+type ExportRow struct {
+	At     time.Time
+	Type   string
+	Order  string
+	Status string
+	Amount float64
+	Reason string
+}
+
+// exportPageSize — сколько строк выгрузки читается за один запрос.
+const exportPageSize = 500
+
+// exportSource — один источник строк выгрузки. Строки страницы упорядочены по (at, kind, id), а id
+// разных таблиц пересекаются, поэтому kind у каждого источника свой.
+type exportSource struct {
+	kind    int
+	at      string // время строки
+	columns string // type, number, status, amount, reason
+	from    string
+	where   string
+}
+
+var exportSources = []exportSource{
+	{1, "uploaded_at", "$4::text, number, status, 0::double precision, ''", "orders", "user_id = $1"},
+	// начисления до появления партий: заказы без партии
+	{2, "COALESCE(processed_at, uploaded_at)", "$5::text, number, status, accrual, ''", "orders o",
+		"user_id = $1 AND status = 'PROCESSED' AND accrual > 0 AND NOT EXISTS (SELECT 1 FROM accrual_lots l WHERE l.user_id = o.user_id AND l.order_number = o.number)"},
+	{3, "COALESCE(available_at, created_at)", "$5::text, order_number, 'PROCESSED', amount, ''", "accrual_lots", "user_id = $1 AND NOT pending"},
+	{4, "processed_at", "$6::text, order_number, '', -sum, ''", "withdrawals", "user_id = $1"},
+	{5, "created_at", "$7::text, '', '', amount, reason", "balance_adjustments", "user_id = $1"},
+	{6, "cancelled_at", "$8::text, order_number, status, sum, cancel_reason", "withdrawals", "user_id = $1 AND cancelled_at IS NOT NULL"},
+	{7, "created_at", "$9::text, order_number, '', -amount, ''", "point_expirations", "user_id = $1"},
+	{8, "created_at", "$10::text, order_number, 'RETURNED', -from_balance, reason", "order_returns", "user_id = $1 AND from_balance > 0"},
+	{9, "created_at", "$11::text, order_number, '', -amount, ''", "clawback_recoveries", "user_id = $1"},
+}
+
+// exportHistorySQL собирает запрос страницы выгрузки. Период и ключ страницы проверяются в каждом источнике
+// по его (user_id, at, id), и каждый источник отдаёт не больше страницы, а не всю историю пользователя.
+func exportHistorySQL() string {
+	branches := make([]string, 0, len(exportSources))
+	for _, src := range exportSources {
+		branches = append(branches, fmt.Sprintf(`(SELECT %[2]s, %[1]d, id, %[3]s FROM %[4]s
+			WHERE %[5]s AND ($2::timestamptz IS NULL OR %[2]s >= $2) AND ($3::timestamptz IS NULL OR %[2]s < $3)
+				AND ($12::timestamptz IS NULL OR %[2]s > $12 OR (%[2]s = $12 AND (%[1]d > $13 OR (%[1]d = $13 AND id > $14))))
+			ORDER BY 1, 3 LIMIT $15)`, src.kind, src.at, src.columns, src.from, src.where))
+	}
+	return `SELECT at, kind, id, type, number, status, amount, reason FROM (
+			` + strings.Join(branches, "\n\t\t\tUNION ALL\n\t\t\t") + `
+		) history (at, kind, id, type, number, status, amount, reason)
+		ORDER BY at, kind, id LIMIT $15`
+}
+
+// ExportHistory отдаёт историю пользователя за период [from, to) построчно в fn. Строки читаются
+// страницами по ключу (at, kind, id), и fn вызывается уже после того, как страница прочитана: медленный
+// клиент не держит соединение с базой, пока скачивает выгрузку. Ошибка из fn прерывает выборку.
+func (d *Database) ExportHistory(userID int, from, to *time.Time, fn func(row ExportRow) error) (err error) {
+	sql := exportHistorySQL()
+
+	var afterAt *time.Time
+	var afterKind, afterID int
+	for {
+		rows, err := d.pgx.Query(d.ctx, sql, userID, from, to, ExportRowOrder, ExportRowAccrual, ExportRowWithdrawal, ExportRowAdjustment, ExportRowRefund, ExportRowExpiry,
+			ExportRowReturn, ExportRowRecovery, afterAt, afterKind, afterID, exportPageSize)
+		if err != nil {
+			return err
+		}
+
+		page := make([]ExportRow, 0, exportPageSize)
+		for rows.Next() {
+			var row ExportRow
+			var at time.Time
+			err = rows.Scan(&at, &afterKind, &afterID, &row.Type, &row.Order, &row.Status, &row.Amount, &row.Reason)
+			if err != nil {
+				rows.Close()
+				return err
+			}
+			row.At, afterAt = at, &at
+			page = append(page, row)
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return err
+		}
+
+		for _, row := range page {
+			if err = fn(row); err != nil {
+				return err
+			}
+		}
+		if len(page) < exportPageSize {
+			return nil
+		}
+	}
+}
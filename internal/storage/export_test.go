@@ -0,0 +1,26 @@
+package storage
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestExportSources проверяет ключ страниц выгрузки: у каждого источника свой kind, иначе строки
+// с одинаковыми at и id из разных таблиц теряются на границе страниц.
+func TestExportSources(t *testing.T) {
+	kinds := make(map[int]string)
+	for _, src := range exportSources {
+		if prev, ok := kinds[src.kind]; ok {
+			t.Errorf("kind %d is used by %s and %s", src.kind, prev, src.from)
+		}
+		kinds[src.kind] = src.from
+		if !strings.HasPrefix(src.where, "user_id = $1") {
+			t.Errorf("%s: rows are not limited to the user: %s", src.from, src.where)
+		}
+	}
+
+	sql := exportHistorySQL()
+	if n := strings.Count(sql, "LIMIT $15"); n != len(exportSources)+1 {
+		t.Errorf("LIMIT $15 appears %d times, want one per source and one for the page", n)
+	}
+}
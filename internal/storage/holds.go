@@ -0,0 +1,283 @@
+package storage
+
+import (
+	"errors"
+	"github.com/jackc/pgx/v4"
+	"time"
+)
+
+const (
+	HoldStatusAuthorized string = "AUTHORIZED" // баллы зарезервированы
+	HoldStatusCaptured          = "CAPTURED"   // резерв превращён в списание
+	HoldStatusVoided            = "VOIDED"     // резерв отменён, баллы возвращены
+	HoldStatusExpired           = "EXPIRED"    // резерв истёк, баллы возвращены
+)
+
+// Hold — резерв баллов под оплату заказа. Пока резерв действует, баллы лежат в users.held
+// и недоступны для других списаний.
+type Hold struct {
+	ID           int
+	UserID       int
+	OrderNumber  string
+	Sum          float64
+	Status       string
+	CapturedSum  float64
+	CreatedAt    time.Time
+	ExpiresAt    time.Time
+	ClosedAt     *time.Time
+	WithdrawalID *int
+}
+
+const holdColumns = "id,user_id,order_number,sum,status,captured_sum,created_at,expires_at,closed_at,withdrawal_id"
+
+func scanHold(row pgx.Row) (hold Hold, err error) {
+	err = row.Scan(&hold.ID, &hold.UserID, &hold.OrderNumber, &hold.Sum, &hold.Status, &hold.CapturedSum,
+		&hold.CreatedAt, &hold.ExpiresAt, &hold.ClosedAt, &hold.WithdrawalID)
+	return hold, err
+}
+
+// CreateHold резервирует сумму: переносит её из balance в held.
+func (d *Database) CreateHold(hold Hold) (result Hold, err error) {
+
+	tx, err := d.pgx.Begin(d.ctx)
+	if err != nil {
+		return result, err
+	}
+	defer func() {
+		if err == nil {
+			_ = tx.Commit(d.ctx)
+		} else {
+			_ = tx.Rollback(d.ctx)
+		}
+	}()
+
+	var balance float64
+	s1 := "SELECT balance FROM users WHERE id=$1 LIMIT 1 FOR UPDATE"
+	err = tx.QueryRow(d.ctx, s1, hold.UserID).Scan(&balance)
+	if err != nil {
+		return result, err
+	}
+	if err = checkWithdrawBalance(balance, hold.Sum); err != nil {
+		return result, err
+	}
+
+	s2 := "UPDATE users SET balance=balance-$1,held=held+$1 WHERE id=$2"
+	_, err = tx.Exec(d.ctx, s2, hold.Sum, hold.UserID)
+	if err != nil {
+		return result, err
+	}
+
+	// резерв сразу расходует партии, отмена резерва возвращает их
+	err = d.consumeAccrualLots(tx, hold.UserID, hold.Sum)
+	if err != nil {
+		return result, err
+	}
+
+	err = d.notifyBalanceChanged(tx, hold.UserID)
+	if err != nil {
+		return result, err
+	}
+
+	s3 := "INSERT INTO withdrawal_holds (user_id,order_number,sum,status,created_at,expires_at) VALUES ($1, $2, $3, $4, $5, $6) RETURNING " + holdColumns
+	result, err = scanHold(tx.QueryRow(d.ctx, s3, hold.UserID, hold.OrderNumber, hold.Sum, HoldStatusAuthorized, time.Now().UTC(), hold.ExpiresAt))
+	return result, err
+}
+
+func (d *Database) GetHoldsByUserID(userID int, limit int) (holds []Hold, err error) {
+	sql := "SELECT " + holdColumns + " FROM withdrawal_holds WHERE user_id = $1 ORDER BY created_at DESC, id DESC LIMIT $2"
+	rows, err := d.pgx.Query(d.ctx, sql, userID, limit)
+	if err != nil {
+		return holds, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		hold, err := scanHold(rows)
+		if err != nil {
+			return holds, err
+		}
+		holds = append(holds, hold)
+	}
+	if err = rows.Err(); err != nil {
+		return holds, err
+	}
+	return holds, err
+}
+
+// lockHold блокирует пользователя и его действующий резерв до конца транзакции.
+// Резерв с прошедшим сроком возвращается вместе с ErrorHoldExpired.
+func (d *Database) lockHold(tx pgx.Tx, userID, id int) (hold Hold, err error) {
+	s1 := "SELECT id FROM users WHERE id=$1 FOR UPDATE"
+	err = tx.QueryRow(d.ctx, s1, userID).Scan(&userID)
+	if err != nil {
+		return hold, err
+	}
+
+	s2 := "SELECT " + holdColumns + " FROM withdrawal_holds WHERE id = $1 AND user_id = $2 FOR UPDATE"
+	hold, err = scanHold(tx.QueryRow(d.ctx, s2, id, userID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return hold, ErrorHoldNotFound
+	}
+	if err != nil {
+		return hold, err
+	}
+	return hold, checkHoldOpen(hold, time.Now())
+}
+
+// checkHoldOpen проверяет, что резерв ещё действует: ErrorHoldClosed после списания или отмены,
+// ErrorHoldExpired после срока.
+func checkHoldOpen(hold Hold, now time.Time) error {
+	if hold.Status != HoldStatusAuthorized {
+		return ErrorHoldClosed
+	}
+	if !now.Before(hold.ExpiresAt) {
+		return ErrorHoldExpired
+	}
+	return nil
+}
+
+// captureSplit делит резерв при списании sum: captured уходит в списание, released возвращается
+// на баланс. sum 0 — списать всё.
+func captureSplit(hold Hold, sum float64) (captured, released float64, err error) {
+	if sum == 0 {
+		sum = hold.Sum
+	}
+	if sum < 0 || sum > hold.Sum {
+		return 0, 0, ErrorHoldAmount
+	}
+	return sum, hold.Sum - sum, nil
+}
+
+// CaptureHold превращает резерв в списание. sum меньше зарезервированного возвращает остаток
+// на баланс, 0 — списать всё.
+func (d *Database) CaptureHold(userID, id int, sum float64) (hold Hold, err error) {
+
+	tx, err := d.pgx.Begin(d.ctx)
+	if err != nil {
+		return hold, err
+	}
+	defer func() {
+		if err == nil {
+			_ = tx.Commit(d.ctx)
+		} else {
+			_ = tx.Rollback(d.ctx)
+		}
+	}()
+
+	hold, err = d.lockHold(tx, userID, id)
+	if err != nil {
+		return hold, err
+	}
+	sum, released, err := captureSplit(hold, sum)
+	if err != nil {
+		return hold, err
+	}
+
+	s1 := "UPDATE users SET held=held-$1,balance=balance+$2,withdrawn=withdrawn+$3 WHERE id=$4"
+	_, err = tx.Exec(d.ctx, s1, hold.Sum, released, sum, userID)
+	if err != nil {
+		return hold, err
+	}
+
+	if released > 0 {
+		err = d.restoreAccrualLots(tx, userID, released)
+		if err != nil {
+			return hold, err
+		}
+	}
+
+	now := time.Now().UTC()
+	withdrawalID, err := d.insertWithdrawal(tx, userID, hold.OrderNumber, sum, now)
+	if err != nil {
+		return hold, err
+	}
+
+	s2 := "UPDATE withdrawal_holds SET status=$1,captured_sum=$2,closed_at=$3,withdrawal_id=$4 WHERE id=$5 RETURNING " + holdColumns
+	hold, err = scanHold(tx.QueryRow(d.ctx, s2, HoldStatusCaptured, sum, now, withdrawalID, hold.ID))
+	return hold, err
+}
+
+// VoidHold отменяет резерв и возвращает баллы на баланс.
+func (d *Database) VoidHold(userID, id int) (hold Hold, err error) {
+	return d.releaseHold(userID, id, HoldStatusVoided)
+}
+
+// ExpireHolds возвращает баллы по истёкшим резервам, каждый в своей транзакции.
+func (d *Database) ExpireHolds() (expired int, err error) {
+	sql := "SELECT id, user_id FROM withdrawal_holds WHERE status = $1 AND expires_at <= $2 ORDER BY expires_at LIMIT 100"
+	rows, err := d.pgx.Query(d.ctx, sql, HoldStatusAuthorized, time.Now().UTC())
+	if err != nil {
+		return expired, err
+	}
+	var holds []Hold
+	for rows.Next() {
+		var hold Hold
+		if err = rows.Scan(&hold.ID, &hold.UserID); err != nil {
+			rows.Close()
+			return expired, err
+		}
+		holds = append(holds, hold)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return expired, err
+	}
+
+	for _, v := range holds {
+		_, err = d.releaseHold(v.UserID, v.ID, HoldStatusExpired)
+		// резерв успели списать или отменить
+		if errors.Is(err, ErrorHoldClosed) {
+			continue
+		}
+		if err != nil {
+			return expired, err
+		}
+		expired++
+	}
+	return expired, nil
+}
+
+// releaseHold закрывает резерв со статусом VOIDED или EXPIRED и возвращает баллы на баланс и в партии.
+func (d *Database) releaseHold(userID, id int, status string) (hold Hold, err error) {
+
+	tx, err := d.pgx.Begin(d.ctx)
+	if err != nil {
+		return hold, err
+	}
+	defer func() {
+		if err == nil {
+			_ = tx.Commit(d.ctx)
+		} else {
+			_ = tx.Rollback(d.ctx)
+		}
+	}()
+
+	hold, err = d.lockHold(tx, userID, id)
+	// истёкший по времени резерв отменять можно, списывать — нет
+	if errors.Is(err, ErrorHoldExpired) {
+		err = nil
+	}
+	if err != nil {
+		return hold, err
+	}
+
+	s1 := "UPDATE users SET held=held-$1,balance=balance+$1 WHERE id=$2"
+	_, err = tx.Exec(d.ctx, s1, hold.Sum, userID)
+	if err != nil {
+		return hold, err
+	}
+
+	err = d.restoreAccrualLots(tx, userID, hold.Sum)
+	if err != nil {
+		return hold, err
+	}
+
+	err = d.notifyBalanceChanged(tx, userID)
+	if err != nil {
+		return hold, err
+	}
+
+	s2 := "UPDATE withdrawal_holds SET status=$1,closed_at=$2 WHERE id=$3 RETURNING " + holdColumns
+	hold, err = scanHold(tx.QueryRow(d.ctx, s2, status, time.Now().UTC(), hold.ID))
+	return hold, err
+}
@@ -0,0 +1,194 @@
+package storage
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCheckHoldOpen(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		hold Hold
+		want error
+	}{
+		{"authorized", Hold{Status: HoldStatusAuthorized, ExpiresAt: now.Add(time.Minute)}, nil},
+		{"expired", Hold{Status: HoldStatusAuthorized, ExpiresAt: now.Add(-time.Minute)}, ErrorHoldExpired},
+		{"expires right now", Hold{Status: HoldStatusAuthorized, ExpiresAt: now}, ErrorHoldExpired},
+		{"captured", Hold{Status: HoldStatusCaptured, ExpiresAt: now.Add(time.Minute)}, ErrorHoldClosed},
+		{"voided", Hold{Status: HoldStatusVoided, ExpiresAt: now.Add(time.Minute)}, ErrorHoldClosed},
+		{"closed wins over expiry", Hold{Status: HoldStatusExpired, ExpiresAt: now.Add(-time.Minute)}, ErrorHoldClosed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := checkHoldOpen(tt.hold, now); !errors.Is(err, tt.want) {
+				t.Errorf("checkHoldOpen() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestCaptureSplit(t *testing.T) {
+	tests := []struct {
+		name         string
+		held         float64
+		sum          float64
+		wantCaptured float64
+		wantReleased float64
+		wantErr      error
+	}{
+		{"full", 100, 100, 100, 0, nil},
+		{"zero means full", 100, 0, 100, 0, nil},
+		{"partial", 100, 30, 30, 70, nil},
+		{"more than held", 100, 100.5, 0, 0, ErrorHoldAmount},
+		{"negative", 100, -1, 0, 0, ErrorHoldAmount},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			captured, released, err := captureSplit(Hold{Sum: tt.held}, tt.sum)
+			if !errors.Is(err, tt.wantErr) || captured != tt.wantCaptured || released != tt.wantReleased {
+				t.Errorf("captureSplit() = %v, %v, %v, want %v, %v, %v",
+					captured, released, err, tt.wantCaptured, tt.wantReleased, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheckWithdrawBalance(t *testing.T) {
+	tests := []struct {
+		balance float64
+		sum     float64
+		want    error
+	}{
+		{100, 50, nil},
+		{100, 100, nil},
+		{100, 100.01, ErrorUserBalanceWithdraw},
+		{0, 1, ErrorUserBalanceWithdraw},
+		{-10, 1, ErrorUserBalanceWithdraw},
+	}
+	for _, tt := range tests {
+		if err := checkWithdrawBalance(tt.balance, tt.sum); !errors.Is(err, tt.want) {
+			t.Errorf("checkWithdrawBalance(%v, %v) = %v, want %v", tt.balance, tt.sum, err, tt.want)
+		}
+	}
+}
+
+// TestHolds проходит жизненный цикл резерва на настоящей базе, см. newTestDatabase.
+func TestHolds(t *testing.T) {
+	d := newTestDatabase(t)
+	day := 24 * time.Hour
+	longAgo := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	type step func(t *testing.T, userID int, hold Hold) (Hold, error)
+	capture := func(sum float64) step {
+		return func(t *testing.T, userID int, hold Hold) (Hold, error) { return d.CaptureHold(userID, hold.ID, sum) }
+	}
+	void := func(t *testing.T, userID int, hold Hold) (Hold, error) { return d.VoidHold(userID, hold.ID) }
+	expire := func(t *testing.T, userID int, hold Hold) (Hold, error) {
+		if _, err := d.ExpireHolds(); err != nil {
+			return hold, err
+		}
+		holds, err := d.GetHoldsByUserID(userID, 1)
+		if err != nil || len(holds) == 0 {
+			t.Fatalf("GetHoldsByUserID() = %v, %v", holds, err)
+		}
+		return holds[0], nil
+	}
+
+	tests := []struct {
+		name          string
+		sum           float64
+		expiresAt     time.Time
+		steps         []step
+		wantErrs      []error
+		wantStatus    string
+		wantBalance   UserBalance
+		wantRemaining []float64
+	}{
+		{"partial capture restores lots LIFO", 80, time.Now().Add(time.Hour),
+			[]step{capture(50)}, []error{nil}, HoldStatusCaptured,
+			UserBalance{Balance: 50, Withdrawn: 50}, []float64{10, 40}},
+		{"full capture", 100, time.Now().Add(time.Hour),
+			[]step{capture(0)}, []error{nil}, HoldStatusCaptured,
+			UserBalance{Withdrawn: 100}, []float64{0, 0}},
+		{"capture after expiry", 80, longAgo,
+			[]step{capture(80), void}, []error{ErrorHoldExpired, nil}, HoldStatusVoided,
+			UserBalance{Balance: 100}, []float64{60, 40}},
+		{"double capture", 80, time.Now().Add(time.Hour),
+			[]step{capture(80), capture(80)}, []error{nil, ErrorHoldClosed}, HoldStatusCaptured,
+			UserBalance{Balance: 20, Withdrawn: 80}, []float64{0, 20}},
+		{"void after capture", 80, time.Now().Add(time.Hour),
+			[]step{capture(30), void}, []error{nil, ErrorHoldClosed}, HoldStatusCaptured,
+			UserBalance{Balance: 70, Withdrawn: 30}, []float64{30, 40}},
+		{"void", 80, time.Now().Add(time.Hour),
+			[]step{void, void}, []error{nil, ErrorHoldClosed}, HoldStatusVoided,
+			UserBalance{Balance: 100}, []float64{60, 40}},
+		{"expire returns points", 80, longAgo,
+			[]step{expire}, []error{nil}, HoldStatusExpired,
+			UserBalance{Balance: 100}, []float64{60, 40}},
+		{"expired hold is not captured later", 80, longAgo,
+			[]step{expire, capture(80)}, []error{nil, ErrorHoldClosed}, HoldStatusExpired,
+			UserBalance{Balance: 100}, []float64{60, 40}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userID := newTestUser(t, d, testDBLot(60, 10*day), testDBLot(40, 30*day))
+			hold, err := d.CreateHold(Hold{UserID: userID, OrderNumber: testNumber(), Sum: tt.sum, ExpiresAt: tt.expiresAt})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := testBalance(t, d, userID); got.Balance != 100-tt.sum || got.Held != tt.sum {
+				t.Fatalf("balance after hold %+v", got)
+			}
+
+			for i, step := range tt.steps {
+				next, err := step(t, userID, hold)
+				if !errors.Is(err, tt.wantErrs[i]) {
+					t.Fatalf("step %d: error = %v, want %v", i, err, tt.wantErrs[i])
+				}
+				if err == nil {
+					hold = next
+				}
+			}
+
+			if hold.Status != tt.wantStatus {
+				t.Errorf("status = %s, want %s", hold.Status, tt.wantStatus)
+			}
+			if got := testBalance(t, d, userID); got != tt.wantBalance {
+				t.Errorf("balance = %+v, want %+v", got, tt.wantBalance)
+			}
+			if got := testRemaining(t, d, userID); !reflect.DeepEqual(got, tt.wantRemaining) {
+				t.Errorf("lots remaining = %v, want %v", got, tt.wantRemaining)
+			}
+		})
+	}
+}
+
+// TestFullBalance проверяет, что списание и резерв одинаково разрешают потратить весь баланс.
+func TestFullBalance(t *testing.T) {
+	d := newTestDatabase(t)
+	tests := []struct {
+		name string
+		sum  float64
+		want error
+	}{
+		{"whole balance", 100, nil},
+		{"more than balance", 100.5, ErrorUserBalanceWithdraw},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userID := newTestUser(t, d, testDBLot(100, 0))
+			if err := d.CreateUserWithdraw(userID, testNumber(), tt.sum); !errors.Is(err, tt.want) {
+				t.Errorf("CreateUserWithdraw() = %v, want %v", err, tt.want)
+			}
+
+			userID = newTestUser(t, d, testDBLot(100, 0))
+			_, err := d.CreateHold(Hold{UserID: userID, OrderNumber: testNumber(), Sum: tt.sum, ExpiresAt: time.Now().Add(time.Hour)})
+			if !errors.Is(err, tt.want) {
+				t.Errorf("CreateHold() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package storage
+
+import (
+	"errors"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v4"
+	"time"
+)
+
+func (d *Database) GetUserIDByIdentity(issuer, subject string) (userID int, err error) {
+	sql := "SELECT user_id FROM user_identities WHERE issuer = $1 AND subject = $2"
+	err = d.pgx.QueryRow(d.ctx, sql, issuer, subject).Scan(&userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return userID, ErrorIdentityNotFound
+	}
+	return userID, err
+}
+
+func (d *Database) LinkUserIdentity(userID int, issuer, subject string) (err error) {
+	sql := "INSERT INTO user_identities (user_id,issuer,subject,created_at) VALUES ($1, $2, $3, $4)"
+	_, err = d.pgx.Exec(d.ctx, sql, userID, issuer, subject, time.Now().UTC())
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
+		return ErrorIdentityLinked
+	}
+	return err
+}
+
+// CreateUserWithIdentity создаёт пользователя при первом входе через внешнего провайдера.
+func (d *Database) CreateUserWithIdentity(user UserTable, issuer, subject string) (userID int, err error) {
+
+	tx, err := d.pgx.Begin(d.ctx)
+	if err != nil {
+		return userID, err
+	}
+	defer func() {
+		if err == nil {
+			_ = tx.Commit(d.ctx)
+		} else {
+			_ = tx.Rollback(d.ctx)
+		}
+	}()
+
+	if user.Role == "" {
+		user.Role = UserRoleUser
+	}
+
+	s1 := "INSERT INTO users (login,password,role) VALUES ($1, $2, $3) RETURNING id"
+	err = tx.QueryRow(d.ctx, s1, user.Login, GetMD5Hash(user.Password), user.Role).Scan(&userID)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
+			return userID, ErrorUserAlreadyExists
+		}
+		return userID, err
+	}
+
+	s2 := "INSERT INTO user_identities (user_id,issuer,subject,created_at) VALUES ($1, $2, $3, $4)"
+	_, err = tx.Exec(d.ctx, s2, userID, issuer, subject, time.Now().UTC())
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
+			return userID, ErrorIdentityLinked
+		}
+		return userID, err
+	}
+
+	return userID, err
+}
@@ -1,14 +1,78 @@
 package storage
 
+import (
+	"context"
+	"time"
+)
+
 type Interface interface {
 	CreateUser(user UserTable) (userID int, err error)
 	GetUserIDByCredentials(login, password string) (userID int, err error)
+	GetUserByID(userID int) (user UserTable, err error)
+	GetUserIDByLogin(login string) (userID int, err error)
+	CheckUserPassword(userID int, password string) (err error)
+	UpdateUserPassword(userID int, password string) (sessionVersion int, err error)
+	CreatePasswordResetToken(userID int, token string, expiresAt time.Time) (err error)
+	GetLoginByResetToken(token string) (login string, err error)
+	ResetPasswordByToken(token, password string) (userID int, err error)
+	SetUserRole(userID int, role string) (err error)
+	CountUsersByRole(role string) (count int, err error)
+	SearchUsersByLogin(query string, limit int) (users []UserTable, err error)
+	NormalizeLogins(normalize func(string) string) (changes []LoginChange, err error)
+	SetUserFrozen(userID int, frozen bool) (err error)
+	AdjustUserBalance(adjustment BalanceAdjustment) (err error)
+	SetOrderStatus(number, status string) (err error)
+	CreateAuditEntry(entry AuditEntry) (err error)
+	GetAuditLog(targetUserID int, limit int) (entries []AuditEntry, err error)
 	CreateOrder(order OrderTable) (err error)
+	CreateOrders(userID int, numbers []string) (results []OrderUploadResult, err error)
 	UpdateOrderByNumber(number string, order OrderTable) (err error)
 	GetOrderByNumber(number string) (order OrderTable, err error)
 	GetProcessingOrderList() (orders []OrderTable, err error)
-	GetOrdersByUserID(userID int) (orders []OrderTable, err error)
+	GetOrdersByUserID(userID int, filter ListFilter) (orders []OrderTable, err error)
+	GetOrderStatusHistory(orderID int) (events []OrderStatusEvent, err error)
+	ListenOrderEvents(ctx context.Context, notices chan<- OrderEventNotice) (err error)
+	GetOrderEventsByUserID(userID, afterSeq, limit int) (events []OrderStatusEvent, err error)
+	GetLastOrderEventID(userID int) (eventID int, err error)
 	GetUserBalance(userID int) (userBalance UserBalance, err error)
+	GetStatement(userID int, from, to *time.Time) (statement Statement, err error)
+	ExportHistory(userID int, from, to *time.Time, fn func(row ExportRow) error) (err error)
 	CreateUserWithdraw(userID int, orderNumber string, sum float64) (err error)
-	GetWithdrawListByUserID(userID int) (withdrawals []WithdrawalTable, err error)
+	GetWithdrawListByUserID(userID int, filter ListFilter) (withdrawals []WithdrawalTable, err error)
+	CancelUserWithdraw(cancel WithdrawalCancel) (withdrawal WithdrawalTable, err error)
+	CreateHold(hold Hold) (result Hold, err error)
+	GetHoldsByUserID(userID int, limit int) (holds []Hold, err error)
+	CaptureHold(userID, id int, sum float64) (hold Hold, err error)
+	VoidHold(userID, id int) (hold Hold, err error)
+	ExpireHolds() (expired int, err error)
+	GetExpiringPoints(userID int, until time.Time) (lots []AccrualLot, err error)
+	ExpirePoints() (expired int, err error)
+	MaturePoints() (matured int, err error)
+	ReturnOrder(ret OrderReturn) (result OrderReturn, err error)
+	CreateAPIKey(key APIKey, secret string) (id int, err error)
+	GetAPIKeysByUserID(userID int) (keys []APIKey, err error)
+	RevokeAPIKey(userID, id int) (err error)
+	UseAPIKey(secret string) (key APIKey, err error)
+	CreateWebhook(hook Webhook) (id int, err error)
+	GetWebhooksByUserID(userID int) (hooks []Webhook, err error)
+	GetWebhook(userID, id int) (hook Webhook, err error)
+	DeleteWebhook(userID, id int) (err error)
+	GetWebhookDeliveries(webhookID, limit int) (deliveries []WebhookDelivery, err error)
+	ClaimWebhookDeliveries(limit int, lease time.Duration) (deliveries []WebhookDelivery, err error)
+	UpdateWebhookDelivery(delivery WebhookDelivery) (err error)
+	GetUserIDByIdentity(issuer, subject string) (userID int, err error)
+	LinkUserIdentity(userID int, issuer, subject string) (err error)
+	CreateUserWithIdentity(user UserTable, issuer, subject string) (userID int, err error)
+	CreateLoginEvent(event LoginEvent) (newDevice bool, err error)
+	GetLoginEventsByUserID(userID int, limit int) (events []LoginEvent, err error)
+	GetUserTOTP(userID int) (userTOTP UserTOTP, err error)
+	SetUserTOTPSecret(userID int, secret string) (err error)
+	EnableUserTOTP(userID int, recoveryCodes []string) (err error)
+	DisableUserTOTP(userID int) (err error)
+	UseUserTOTPStep(userID int, step int64) (err error)
+	AddUserTOTPFailure(userID int, windowStart, now time.Time) (failures int, err error)
+	LockUserTOTP(userID int, until time.Time) (err error)
+	ResetUserTOTPFailures(userID int) (err error)
+	UseUserRecoveryCode(userID int, code string) (err error)
+	GetOrCreateAppSecret(name, value string) (stored string, err error)
 }
@@ -0,0 +1,125 @@
+package storage
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// LoginChange — логин, изменённый при нормализации. Conflict — нормализованный логин достался
+// другому пользователю, и этот получил логин с суффиксом "#<id>".
+type LoginChange struct {
+	UserID   int
+	OldLogin string
+	NewLogin string
+	Conflict bool
+}
+
+// planLoginNormalization решает, какие логины изменить. При совпадении нормализованный логин
+// остаётся за тем, кто уже записан в нормализованном виде (только он и мог входить после перехода
+// на нормализацию), иначе за самым старым; остальные получают "<логин>#<id>". Новые логины
+// не совпадают ни с одним текущим, поэтому изменения можно применять в любом порядке,
+// не нарушая уникальности users.login.
+func planLoginNormalization(users []UserTable, normalize func(string) string) (changes []LoginChange) {
+	users = append([]UserTable(nil), users...)
+	sort.SliceStable(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+
+	taken := make(map[string]bool, len(users))
+	groups := map[string][]UserTable{}
+	var order []string
+	for _, u := range users {
+		taken[u.Login] = true
+		norm := normalize(u.Login)
+		if _, ok := groups[norm]; !ok {
+			order = append(order, norm)
+		}
+		groups[norm] = append(groups[norm], u)
+	}
+
+	for _, norm := range order {
+		group := groups[norm]
+		winner := 0
+		for i, u := range group {
+			if u.Login == norm {
+				winner = i
+				break
+			}
+		}
+
+		for i, u := range group {
+			if u.Login == norm {
+				continue
+			}
+			change := LoginChange{UserID: u.ID, OldLogin: u.Login, NewLogin: norm}
+			if i != winner || taken[norm] {
+				change.Conflict = true
+				change.NewLogin = fmt.Sprintf("%s#%d", norm, u.ID)
+				for n := 2; taken[change.NewLogin]; n++ {
+					change.NewLogin = fmt.Sprintf("%s#%d-%d", norm, u.ID, n)
+				}
+			}
+			taken[change.NewLogin] = true
+			changes = append(changes, change)
+		}
+	}
+	return changes
+}
+
+// NormalizeLogins приводит логины всех пользователей к normalize (policy.NormalizeLogin) одной
+// транзакцией, см. planLoginNormalization. Переименования из-за совпадений пишутся в login_conflicts.
+// На время миграции таблица users закрыта для изменений, чтение не блокируется.
+func (d *Database) NormalizeLogins(normalize func(string) string) (changes []LoginChange, err error) {
+
+	tx, err := d.pgx.Begin(d.ctx)
+	if err != nil {
+		return changes, err
+	}
+	defer func() {
+		if err == nil {
+			_ = tx.Commit(d.ctx)
+		} else {
+			_ = tx.Rollback(d.ctx)
+		}
+	}()
+
+	s1 := "LOCK TABLE users IN SHARE ROW EXCLUSIVE MODE"
+	if _, err = tx.Exec(d.ctx, s1); err != nil {
+		return changes, err
+	}
+
+	s2 := "SELECT id, login FROM users ORDER BY id"
+	rows, err := tx.Query(d.ctx, s2)
+	if err != nil {
+		return changes, err
+	}
+	var users []UserTable
+	for rows.Next() {
+		var u UserTable
+		if err = rows.Scan(&u.ID, &u.Login); err != nil {
+			rows.Close()
+			return changes, err
+		}
+		users = append(users, u)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return changes, err
+	}
+
+	changes = planLoginNormalization(users, normalize)
+	now := time.Now().UTC()
+	s3 := "UPDATE users SET login=$1 WHERE id=$2"
+	s4 := "INSERT INTO login_conflicts (user_id,old_login,new_login,created_at) VALUES ($1, $2, $3, $4)"
+	for _, v := range changes {
+		if _, err = tx.Exec(d.ctx, s3, v.NewLogin, v.UserID); err != nil {
+			return changes, err
+		}
+		if !v.Conflict {
+			continue
+		}
+		if _, err = tx.Exec(d.ctx, s4, v.UserID, v.OldLogin, v.NewLogin, now); err != nil {
+			return changes, err
+		}
+	}
+	return changes, nil
+}
@@ -0,0 +1,52 @@
+package storage
+
+import (
+	"github.com/rainset/gophermart/internal/policy"
+	"reflect"
+	"testing"
+)
+
+func TestPlanLoginNormalization(t *testing.T) {
+	tests := []struct {
+		name  string
+		users []UserTable
+		want  []LoginChange
+	}{
+		{"already normalized", []UserTable{{ID: 1, Login: "alice"}, {ID: 2, Login: "bob"}}, nil},
+		{"normalized in place",
+			[]UserTable{{ID: 1, Login: " Alice"}, {ID: 2, Login: "ＢＯＢ"}, {ID: 3, Login: "Straße"}},
+			[]LoginChange{{1, " Alice", "alice", false}, {2, "ＢＯＢ", "bob", false}, {3, "Straße", "strasse", false}}},
+		{"normalized login wins",
+			[]UserTable{{ID: 1, Login: "Alice"}, {ID: 2, Login: "alice"}},
+			[]LoginChange{{1, "Alice", "alice#1", true}}},
+		{"otherwise the oldest wins",
+			[]UserTable{{ID: 3, Login: "ALICE"}, {ID: 1, Login: "Alice"}},
+			[]LoginChange{{1, "Alice", "alice", false}, {3, "ALICE", "alice#3", true}}},
+		{"suffix already taken",
+			[]UserTable{{ID: 1, Login: "bob"}, {ID: 2, Login: "Bob"}, {ID: 3, Login: "bob#2"}},
+			[]LoginChange{{2, "Bob", "bob#2-2", true}}},
+		{"rerun changes nothing",
+			[]UserTable{{ID: 1, Login: "alice"}, {ID: 3, Login: "alice#3"}}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := planLoginNormalization(tt.users, policy.NormalizeLogin)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("planLoginNormalization() = %+v, want %+v", got, tt.want)
+			}
+
+			// новые логины уникальны и не совпадают с текущими, иначе UPDATE упрётся в users.login UNIQUE
+			current := map[string]bool{}
+			for _, u := range tt.users {
+				current[u.Login] = true
+			}
+			seen := map[string]bool{}
+			for _, v := range got {
+				if current[v.NewLogin] || seen[v.NewLogin] {
+					t.Errorf("login %q assigned twice", v.NewLogin)
+				}
+				seen[v.NewLogin] = true
+			}
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package storage
+
+import (
+	"time"
+)
+
+const (
+	LoginMethodPassword string = "password"
+	LoginMethod2FA             = "2fa"
+	LoginMethodOIDC            = "oidc"
+	LoginMethodRegister        = "register"
+)
+
+type LoginEvent struct {
+	ID          int
+	UserID      int
+	Login       string
+	Success     bool
+	Method      string
+	Reason      string
+	IP          string
+	UserAgent   string
+	Fingerprint string
+	NewDevice   bool
+	CreatedAt   time.Time
+}
+
+// CreateLoginEvent записывает попытку входа. Успешный вход с отпечатком устройства,
+// которого не было среди прошлых успешных входов пользователя, помечается как новое устройство.
+// Самый первый вход новым не считается.
+func (d *Database) CreateLoginEvent(event LoginEvent) (newDevice bool, err error) {
+
+	if event.Success && event.UserID > 0 {
+		var hasHistory, knownDevice bool
+		s1 := `SELECT EXISTS(SELECT 1 FROM login_events WHERE user_id=$1 AND success),
+			EXISTS(SELECT 1 FROM login_events WHERE user_id=$1 AND success AND fingerprint=$2)`
+		err = d.pgx.QueryRow(d.ctx, s1, event.UserID, event.Fingerprint).Scan(&hasHistory, &knownDevice)
+		if err != nil {
+			return false, err
+		}
+		newDevice = hasHistory && !knownDevice
+	}
+
+	s2 := `INSERT INTO login_events (user_id,login,success,method,reason,ip,user_agent,fingerprint,new_device,created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`
+	_, err = d.pgx.Exec(d.ctx, s2, nullableID(event.UserID), event.Login, event.Success, event.Method, event.Reason,
+		event.IP, event.UserAgent, event.Fingerprint, newDevice, time.Now().UTC())
+
+	return newDevice, err
+}
+
+func (d *Database) GetLoginEventsByUserID(userID int, limit int) (events []LoginEvent, err error) {
+	sql := `SELECT id,user_id,login,success,method,reason,ip,user_agent,fingerprint,new_device,created_at FROM login_events
+		WHERE user_id = $1 ORDER BY created_at DESC, id DESC LIMIT $2`
+	rows, err := d.pgx.Query(d.ctx, sql, userID, limit)
+	if err != nil {
+		return events, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var event LoginEvent
+		err = rows.Scan(&event.ID, &event.UserID, &event.Login, &event.Success, &event.Method, &event.Reason,
+			&event.IP, &event.UserAgent, &event.Fingerprint, &event.NewDevice, &event.CreatedAt)
+		if err != nil {
+			return events, err
+		}
+		events = append(events, event)
+	}
+	if err = rows.Err(); err != nil {
+		return events, err
+	}
+
+	return events, err
+}
@@ -0,0 +1,380 @@
+package storage
+
+import (
+	"github.com/jackc/pgx/v4"
+	"math"
+	"sort"
+	"time"
+)
+
+const pointsExpiryBatch = 100
+
+// AccrualLot — партия баллов от одного начисления. Списания расходуют партии в порядке сгорания (FIFO),
+// непотраченный остаток сгорает в ExpiresAt. Баллы вне партий (начисленные до появления сгорания,
+// ручные корректировки) не сгорают. Партия с Pending лежит в users.pending и до AvailableAt не тратится.
+type AccrualLot struct {
+	ID          int
+	UserID      int
+	OrderNumber string
+	Amount      float64
+	Remaining   float64
+	CreatedAt   time.Time
+	ExpiresAt   *time.Time // nil — бессрочно
+	AvailableAt *time.Time // nil — доступна сразу
+	Pending     bool
+}
+
+type PointsExpiration struct {
+	ID          int
+	UserID      int
+	LotID       int
+	OrderNumber string
+	Amount      float64
+	CreatedAt   time.Time
+}
+
+// createAccrualLot заводит партию под начисление. Вызывается под блокировкой строки пользователя.
+func (d *Database) createAccrualLot(q execer, lot AccrualLot) (err error) {
+	sql := "INSERT INTO accrual_lots (user_id,order_number,amount,remaining,created_at,expires_at,available_at,pending) VALUES ($1, $2, $3, $3, $4, $5, $6, $7)"
+	_, err = q.Exec(d.ctx, sql, lot.UserID, lot.OrderNumber, lot.Amount, lot.CreatedAt, lot.ExpiresAt, lot.AvailableAt, lot.Pending)
+	return err
+}
+
+// lotChange — изменение остатка партии при списании или возврате баллов.
+type lotChange struct {
+	LotID int
+	Delta float64
+}
+
+// fifoLess задаёт порядок сгорания партий: раньше сгорающие первыми, бессрочные в конце.
+func fifoLess(a, b AccrualLot) bool {
+	switch {
+	case a.ExpiresAt == nil && b.ExpiresAt == nil:
+		return a.ID < b.ID
+	case a.ExpiresAt == nil:
+		return false
+	case b.ExpiresAt == nil:
+		return true
+	case !a.ExpiresAt.Equal(*b.ExpiresAt):
+		return a.ExpiresAt.Before(*b.ExpiresAt)
+	}
+	return a.ID < b.ID
+}
+
+// consumeLots раскладывает списание sum по партиям в порядке сгорания (FIFO).
+// Что не покрыто партиями, списывается с баллов вне партий.
+func consumeLots(lots []AccrualLot, sum float64) (changes []lotChange) {
+	lots = append([]AccrualLot(nil), lots...)
+	sort.SliceStable(lots, func(i, j int) bool { return fifoLess(lots[i], lots[j]) })
+
+	left := sum
+	for _, lot := range lots {
+		if left <= 0 {
+			break
+		}
+		take := math.Min(lot.Remaining, left)
+		if take <= 0 {
+			continue
+		}
+		changes = append(changes, lotChange{lot.ID, -take})
+		left -= take
+	}
+	return changes
+}
+
+// restoreLots раскладывает возврат sum по партиям в обратном порядке (LIFO): первыми заполняются
+// бессрочные и позже сгорающие, чтобы вернувшиеся баллы не сгорели раньше потраченных.
+// Что не поместилось, остаётся на балансе бессрочно.
+func restoreLots(lots []AccrualLot, sum float64) (changes []lotChange) {
+	lots = append([]AccrualLot(nil), lots...)
+	sort.SliceStable(lots, func(i, j int) bool { return fifoLess(lots[j], lots[i]) })
+
+	left := sum
+	for _, lot := range lots {
+		if left <= 0 {
+			break
+		}
+		put := math.Min(lot.Amount-lot.Remaining, left)
+		if put <= 0 {
+			continue
+		}
+		changes = append(changes, lotChange{lot.ID, put})
+		left -= put
+	}
+	return changes
+}
+
+// activeLots читает несгоревшие и доступные партии пользователя с условием на остаток.
+// Вызывается под блокировкой строки пользователя, поэтому сами партии не блокируются.
+func (d *Database) activeLots(tx pgx.Tx, userID int, remainingCond string) (lots []AccrualLot, err error) {
+	sql := `SELECT id, amount, remaining, expires_at FROM accrual_lots
+		WHERE user_id = $1 AND expired_at IS NULL AND NOT pending AND ` + remainingCond
+	rows, err := tx.Query(d.ctx, sql, userID)
+	if err != nil {
+		return lots, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var lot AccrualLot
+		if err = rows.Scan(&lot.ID, &lot.Amount, &lot.Remaining, &lot.ExpiresAt); err != nil {
+			return lots, err
+		}
+		lots = append(lots, lot)
+	}
+	return lots, rows.Err()
+}
+
+func (d *Database) applyLotChanges(tx pgx.Tx, changes []lotChange) (err error) {
+	sql := "UPDATE accrual_lots SET remaining = remaining + $1 WHERE id = $2"
+	for _, v := range changes {
+		if _, err = tx.Exec(d.ctx, sql, v.Delta, v.LotID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// consumeAccrualLots расходует sum из партий пользователя, начиная с ближайших к сгоранию.
+func (d *Database) consumeAccrualLots(tx pgx.Tx, userID int, sum float64) (err error) {
+	lots, err := d.activeLots(tx, userID, "remaining > 0")
+	if err != nil {
+		return err
+	}
+	return d.applyLotChanges(tx, consumeLots(lots, sum))
+}
+
+// restoreAccrualLots возвращает sum в партии в обратном порядке (LIFO) — при отмене списания или резерва.
+func (d *Database) restoreAccrualLots(tx pgx.Tx, userID int, sum float64) (err error) {
+	lots, err := d.activeLots(tx, userID, "remaining < amount")
+	if err != nil {
+		return err
+	}
+	return d.applyLotChanges(tx, restoreLots(lots, sum))
+}
+
+// GetExpiringPoints возвращает партии с остатком, которые сгорят до until.
+func (d *Database) GetExpiringPoints(userID int, until time.Time) (lots []AccrualLot, err error) {
+	sql := `SELECT id,user_id,order_number,amount,remaining,created_at,expires_at FROM accrual_lots
+		WHERE user_id = $1 AND remaining > 0 AND expired_at IS NULL AND NOT pending AND expires_at < $2
+		ORDER BY expires_at, id`
+	rows, err := d.pgx.Query(d.ctx, sql, userID, until)
+	if err != nil {
+		return lots, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var lot AccrualLot
+		err = rows.Scan(&lot.ID, &lot.UserID, &lot.OrderNumber, &lot.Amount, &lot.Remaining, &lot.CreatedAt, &lot.ExpiresAt)
+		if err != nil {
+			return lots, err
+		}
+		lots = append(lots, lot)
+	}
+	if err = rows.Err(); err != nil {
+		return lots, err
+	}
+	return lots, err
+}
+
+// ExpirePoints списывает остатки сгоревших партий и пишет по каждой запись в point_expirations.
+// Пользователи обрабатываются по одному в отдельных транзакциях.
+func (d *Database) ExpirePoints() (expired int, err error) {
+	sql := "SELECT DISTINCT user_id FROM accrual_lots WHERE expired_at IS NULL AND NOT pending AND expires_at <= $1 LIMIT $2"
+	for {
+		userIDs, err := d.dueLotUsers(sql)
+		if err != nil {
+			return expired, err
+		}
+
+		for _, userID := range userIDs {
+			n, err := d.expireUserPoints(userID)
+			if err != nil {
+				return expired, err
+			}
+			expired += n
+		}
+		if len(userIDs) < pointsExpiryBatch {
+			return expired, nil
+		}
+	}
+}
+
+// dueLotUsers выбирает пользователей, у которых есть партии для фоновой задачи.
+func (d *Database) dueLotUsers(sql string) (userIDs []int, err error) {
+	rows, err := d.pgx.Query(d.ctx, sql, time.Now().UTC(), pointsExpiryBatch)
+	if err != nil {
+		return userIDs, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var userID int
+		if err = rows.Scan(&userID); err != nil {
+			return userIDs, err
+		}
+		userIDs = append(userIDs, userID)
+	}
+	return userIDs, rows.Err()
+}
+
+// MaturePoints переводит партии с прошедшим периодом ожидания из pending в доступный баланс.
+func (d *Database) MaturePoints() (matured int, err error) {
+	sql := "SELECT DISTINCT user_id FROM accrual_lots WHERE pending AND available_at <= $1 LIMIT $2"
+	for {
+		userIDs, err := d.dueLotUsers(sql)
+		if err != nil {
+			return matured, err
+		}
+
+		for _, userID := range userIDs {
+			n, err := d.matureUserPoints(userID)
+			if err != nil {
+				return matured, err
+			}
+			matured += n
+		}
+		if len(userIDs) < pointsExpiryBatch {
+			return matured, nil
+		}
+	}
+}
+
+func (d *Database) matureUserPoints(userID int) (matured int, err error) {
+
+	tx, err := d.pgx.Begin(d.ctx)
+	if err != nil {
+		return matured, err
+	}
+	defer func() {
+		if err == nil {
+			_ = tx.Commit(d.ctx)
+		} else {
+			_ = tx.Rollback(d.ctx)
+		}
+	}()
+
+	s1 := "SELECT id FROM users WHERE id=$1 FOR UPDATE"
+	err = tx.QueryRow(d.ctx, s1, userID).Scan(&userID)
+	if err != nil {
+		return matured, err
+	}
+
+	now := time.Now().UTC()
+	s2 := `UPDATE accrual_lots SET pending = false WHERE user_id = $1 AND pending AND available_at <= $2
+		RETURNING order_number, remaining`
+	rows, err := tx.Query(d.ctx, s2, userID, now)
+	if err != nil {
+		return matured, err
+	}
+	var lots []AccrualLot
+	for rows.Next() {
+		var lot AccrualLot
+		if err = rows.Scan(&lot.OrderNumber, &lot.Remaining); err != nil {
+			rows.Close()
+			return matured, err
+		}
+		lots = append(lots, lot)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return matured, err
+	}
+
+	for _, lot := range lots {
+		err = d.creditAccrual(tx, userID, lot.OrderNumber, lot.Remaining, true, now)
+		if err != nil {
+			return matured, err
+		}
+		matured++
+	}
+	if matured > 0 {
+		err = d.notifyBalanceChanged(tx, userID)
+	}
+	return matured, err
+}
+
+func (d *Database) expireUserPoints(userID int) (expired int, err error) {
+
+	tx, err := d.pgx.Begin(d.ctx)
+	if err != nil {
+		return expired, err
+	}
+	defer func() {
+		if err == nil {
+			_ = tx.Commit(d.ctx)
+		} else {
+			_ = tx.Rollback(d.ctx)
+		}
+	}()
+
+	var balance float64
+	s1 := "SELECT balance FROM users WHERE id=$1 LIMIT 1 FOR UPDATE"
+	err = tx.QueryRow(d.ctx, s1, userID).Scan(&balance)
+	if err != nil {
+		return expired, err
+	}
+
+	now := time.Now().UTC()
+	s2 := `UPDATE accrual_lots SET expired_at = $2 WHERE user_id = $1 AND expired_at IS NULL AND NOT pending AND expires_at <= $2
+		RETURNING id, order_number, remaining`
+	rows, err := tx.Query(d.ctx, s2, userID, now)
+	if err != nil {
+		return expired, err
+	}
+	var lots []AccrualLot
+	for rows.Next() {
+		var lot AccrualLot
+		if err = rows.Scan(&lot.ID, &lot.OrderNumber, &lot.Remaining); err != nil {
+			rows.Close()
+			return expired, err
+		}
+		lots = append(lots, lot)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return expired, err
+	}
+
+	var total float64
+	for _, lot := range lots {
+		// партии не могут превышать баланс, но баланс мог уйти в минус через корректировки
+		amount := lot.Remaining
+		if amount > balance-total {
+			amount = balance - total
+		}
+		if amount <= 0 {
+			continue
+		}
+		total += amount
+
+		s3 := "INSERT INTO point_expirations (user_id,lot_id,order_number,amount,created_at) VALUES ($1, $2, $3, $4, $5)"
+		_, err = tx.Exec(d.ctx, s3, userID, lot.ID, lot.OrderNumber, amount, now)
+		if err != nil {
+			return expired, err
+		}
+		expired++
+	}
+
+	s4 := "UPDATE accrual_lots SET remaining = 0 WHERE user_id = $1 AND expired_at = $2"
+	_, err = tx.Exec(d.ctx, s4, userID, now)
+	if err != nil {
+		return expired, err
+	}
+
+	if total > 0 {
+		s5 := "UPDATE users SET balance=balance-$1 WHERE id=$2"
+		_, err = tx.Exec(d.ctx, s5, total, userID)
+		if err != nil {
+			return expired, err
+		}
+
+		err = d.notifyBalanceChanged(tx, userID)
+		if err != nil {
+			return expired, err
+		}
+	}
+
+	return expired, err
+}
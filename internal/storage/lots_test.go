@@ -0,0 +1,111 @@
+package storage
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func testLot(id int, amount, remaining float64, expiresIn time.Duration) AccrualLot {
+	lot := AccrualLot{ID: id, Amount: amount, Remaining: remaining}
+	if expiresIn != 0 {
+		expiresAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC).Add(expiresIn)
+		lot.ExpiresAt = &expiresAt
+	}
+	return lot
+}
+
+func TestConsumeLots(t *testing.T) {
+	day := 24 * time.Hour
+	tests := []struct {
+		name string
+		lots []AccrualLot
+		sum  float64
+		want []lotChange
+	}{
+		{"nearest expiry first",
+			[]AccrualLot{testLot(1, 100, 100, 30*day), testLot(2, 100, 100, 10*day)}, 50,
+			[]lotChange{{2, -50}}},
+		{"spills into the next lot",
+			[]AccrualLot{testLot(1, 100, 100, 30*day), testLot(2, 100, 40, 10*day)}, 60,
+			[]lotChange{{2, -40}, {1, -20}}},
+		{"lots without expiry go last",
+			[]AccrualLot{testLot(1, 100, 100, 0), testLot(2, 100, 100, 30*day)}, 150,
+			[]lotChange{{2, -100}, {1, -50}}},
+		{"same expiry by id",
+			[]AccrualLot{testLot(3, 10, 10, day), testLot(2, 10, 10, day)}, 15,
+			[]lotChange{{2, -10}, {3, -5}}},
+		{"sum beyond lots",
+			[]AccrualLot{testLot(1, 100, 30, day), testLot(2, 100, 20, 0)}, 80,
+			[]lotChange{{1, -30}, {2, -20}}},
+		{"spent lots skipped",
+			[]AccrualLot{testLot(1, 100, 0, day), testLot(2, 100, 100, 2*day)}, 10,
+			[]lotChange{{2, -10}}},
+		{"nothing to consume", []AccrualLot{testLot(1, 100, 100, day)}, 0, nil},
+		{"no lots", nil, 10, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := consumeLots(tt.lots, tt.sum); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("consumeLots() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRestoreLots(t *testing.T) {
+	day := 24 * time.Hour
+	tests := []struct {
+		name string
+		lots []AccrualLot
+		sum  float64
+		want []lotChange
+	}{
+		{"latest expiry first",
+			[]AccrualLot{testLot(1, 100, 0, 10*day), testLot(2, 100, 0, 30*day)}, 50,
+			[]lotChange{{2, 50}}},
+		{"lots without expiry first",
+			[]AccrualLot{testLot(1, 100, 0, 30*day), testLot(2, 100, 50, 0)}, 80,
+			[]lotChange{{2, 50}, {1, 30}}},
+		{"same expiry by id descending",
+			[]AccrualLot{testLot(2, 10, 0, day), testLot(3, 10, 0, day)}, 15,
+			[]lotChange{{3, 10}, {2, 5}}},
+		{"fills only the spent part",
+			[]AccrualLot{testLot(1, 100, 90, day), testLot(2, 100, 100, 2*day)}, 50,
+			[]lotChange{{1, 10}}},
+		{"sum beyond lots",
+			[]AccrualLot{testLot(1, 100, 70, day)}, 100,
+			[]lotChange{{1, 30}}},
+		{"nothing to restore", []AccrualLot{testLot(1, 100, 0, day)}, 0, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := restoreLots(tt.lots, tt.sum); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("restoreLots() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestConsumeRestoreRoundTrip проверяет, что возврат списания восстанавливает партии как были.
+func TestConsumeRestoreRoundTrip(t *testing.T) {
+	day := 24 * time.Hour
+	lots := []AccrualLot{testLot(1, 100, 100, 30*day), testLot(2, 50, 50, 10*day), testLot(3, 20, 20, 0)}
+	want := append([]AccrualLot(nil), lots...)
+
+	apply := func(changes []lotChange) {
+		for _, c := range changes {
+			for i := range lots {
+				if lots[i].ID == c.LotID {
+					lots[i].Remaining += c.Delta
+				}
+			}
+		}
+	}
+	apply(consumeLots(lots, 120))
+	apply(restoreLots(lots, 120))
+
+	if !reflect.DeepEqual(lots, want) {
+		t.Errorf("lots after round trip = %+v, want %+v", lots, want)
+	}
+}
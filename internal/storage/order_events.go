@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/jackc/pgx/v4"
+	"log"
+)
+
+const orderEventsChannel = "order_events"
+
+// OrderEventNotice приходит через LISTEN/NOTIFY после фиксации перехода статуса заказа
+// (EventID — его номер в потоке пользователя) или изменения баланса без перехода (тогда EventID = 0).
+type OrderEventNotice struct {
+	UserID  int `json:"user_id"`
+	EventID int `json:"event_id"`
+}
+
+// notifyBalanceChanged будит потоки пользователя после изменения баланса, не связанного с заказом:
+// списаний, резервов, сгорания и созревания баллов, корректировок. Уходит при фиксации транзакции.
+func (d *Database) notifyBalanceChanged(q execer, userID int) (err error) {
+	sql := "SELECT pg_notify($1, json_build_object('user_id', $2::integer, 'event_id', 0)::text)"
+	_, err = q.Exec(d.ctx, sql, orderEventsChannel, userID)
+	return err
+}
+
+// ListenOrderEvents держит отдельное соединение с LISTEN order_events и передаёт уведомления
+// в notices, пока не отменён ctx или не оборвалось соединение.
+func (d *Database) ListenOrderEvents(ctx context.Context, notices chan<- OrderEventNotice) (err error) {
+	conn, err := pgx.Connect(ctx, d.dsn)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = conn.Close(context.Background())
+	}()
+
+	_, err = conn.Exec(ctx, "LISTEN "+orderEventsChannel)
+	if err != nil {
+		return err
+	}
+
+	for {
+		notification, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		var notice OrderEventNotice
+		if err = json.Unmarshal([]byte(notification.Payload), &notice); err != nil {
+			log.Println("DB: order event payload: ", err)
+			continue
+		}
+		select {
+		case notices <- notice:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// GetOrderEventsByUserID возвращает переходы статусов заказов пользователя с номером (Seq) больше afterSeq.
+// Номера идут в порядке фиксации, поэтому событие, закоммиченное позже соседних, не окажется позади позиции потока.
+func (d *Database) GetOrderEventsByUserID(userID, afterSeq, limit int) (events []OrderStatusEvent, err error) {
+	sql := `SELECT h.id,h.user_seq,h.order_id,o.number,h.status,h.accrual,h.source,h.created_at FROM order_status_history h
+		JOIN orders o ON o.id = h.order_id
+		WHERE o.user_id = $1 AND h.user_seq > $2 ORDER BY h.user_seq LIMIT $3`
+	rows, err := d.pgx.Query(d.ctx, sql, userID, afterSeq, limit)
+	if err != nil {
+		return events, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var event OrderStatusEvent
+		err = rows.Scan(&event.ID, &event.Seq, &event.OrderID, &event.Number, &event.Status, &event.Accrual, &event.Source, &event.CreatedAt)
+		if err != nil {
+			return events, err
+		}
+		events = append(events, event)
+	}
+	if err = rows.Err(); err != nil {
+		return events, err
+	}
+
+	return events, err
+}
+
+// GetLastOrderEventID возвращает номер последнего события в потоке заказов пользователя.
+func (d *Database) GetLastOrderEventID(userID int) (eventID int, err error) {
+	sql := "SELECT order_event_seq FROM users WHERE id = $1"
+	err = d.pgx.QueryRow(d.ctx, sql, userID).Scan(&eventID)
+	return eventID, err
+}
@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+// TestOrderEventsCommitOrder проверяет на настоящей базе (см. newTestDatabase), что поток заказов
+// не пропускает событие, транзакция которого началась раньше, а зафиксирована позже соседней.
+func TestOrderEventsCommitOrder(t *testing.T) {
+	d := newTestDatabase(t)
+	userID := newTestUser(t, d)
+
+	numbers := []string{testNumber(), testNumber()}
+	if _, err := d.CreateOrders(userID, numbers); err != nil {
+		t.Fatal(err)
+	}
+	var orders []OrderTable
+	for _, number := range numbers {
+		order, err := d.GetOrderByNumber(number)
+		if err != nil {
+			t.Fatal(err)
+		}
+		orders = append(orders, order)
+	}
+
+	lastID, err := d.GetLastOrderEventID(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lastID != 2 {
+		t.Fatalf("GetLastOrderEventID() = %d after upload, want 2", lastID)
+	}
+
+	// первая транзакция пишет событие и держит его незафиксированным, вторая ждёт её
+	slow, err := d.pgx.Begin(d.ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = slow.Rollback(d.ctx) }()
+	event := OrderStatusEvent{OrderID: orders[0].ID, Number: orders[0].Number, Status: OrderStatusProcessing,
+		Source: OrderSourceAccrual, CreatedAt: time.Now().UTC()}
+	if err = d.createOrderStatusEvent(slow, userID, event); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		fast, err := d.pgx.Begin(d.ctx)
+		if err != nil {
+			done <- err
+			return
+		}
+		event := OrderStatusEvent{OrderID: orders[1].ID, Number: orders[1].Number, Status: OrderStatusProcessing,
+			Source: OrderSourceAccrual, CreatedAt: time.Now().UTC()}
+		if err = d.createOrderStatusEvent(fast, userID, event); err != nil {
+			_ = fast.Rollback(d.ctx)
+			done <- err
+			return
+		}
+		done <- fast.Commit(d.ctx)
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	if err = slow.Commit(d.ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err = <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	events, err := d.GetOrderEventsByUserID(userID, lastID, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || events[0].Seq != 3 || events[1].Seq != 4 ||
+		events[0].Number != orders[0].Number || events[1].Number != orders[1].Number {
+		t.Fatalf("events after %d = %+v", lastID, events)
+	}
+	if lastID, err = d.GetLastOrderEventID(userID); err != nil || lastID != 4 {
+		t.Errorf("GetLastOrderEventID() = %d, %v, want 4", lastID, err)
+	}
+}
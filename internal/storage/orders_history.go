@@ -0,0 +1,104 @@
+package storage
+
+import (
+	"context"
+	"github.com/jackc/pgconn"
+	"time"
+)
+
+const (
+	OrderSourceUpload  string = "upload"
+	OrderSourceAccrual        = "accrual"
+	OrderSourceAdmin          = "admin"
+	OrderSourceReturn         = "return"
+)
+
+type OrderStatusEvent struct {
+	ID        int
+	Seq       int // номер события в потоке заказов пользователя, см. createOrderStatusEvent
+	OrderID   int
+	Number    string
+	Status    string
+	Accrual   float64
+	Source    string
+	CreatedAt time.Time
+}
+
+type execer interface {
+	Exec(ctx context.Context, sql string, arguments ...interface{}) (pgconn.CommandTag, error)
+}
+
+// createOrderStatusEvent пишет переход в историю, уведомляет слушателей канала order_events
+// и ставит в очередь вебхуки о финальных статусах. Уведомление уходит при фиксации транзакции.
+// Номер события в потоке пользователя (user_seq) берётся из users.order_event_seq: строка пользователя
+// остаётся заблокированной до фиксации, так что события с большим номером не становятся видны раньше.
+func (d *Database) createOrderStatusEvent(q execer, userID int, event OrderStatusEvent) (err error) {
+	sql := `WITH seq AS (
+			UPDATE users SET order_event_seq = order_event_seq + 1 WHERE id = $7 RETURNING order_event_seq
+		),
+		event AS (
+			INSERT INTO order_status_history (order_id,status,accrual,source,created_at,user_seq)
+			SELECT $1, $2, $3, $4, $5, order_event_seq FROM seq
+			RETURNING user_seq
+		)
+		SELECT pg_notify($6, json_build_object('user_id', $7::integer, 'event_id', user_seq)::text) FROM event`
+	_, err = q.Exec(d.ctx, sql, event.OrderID, event.Status, event.Accrual, event.Source, event.CreatedAt, orderEventsChannel, userID)
+	if err != nil {
+		return err
+	}
+
+	webhookEvent := ""
+	switch event.Status {
+	case OrderStatusProcessed:
+		webhookEvent = WebhookEventOrderProcessed
+	case OrderStatusInvalid:
+		webhookEvent = WebhookEventOrderInvalid
+	case OrderStatusReturned:
+		webhookEvent = WebhookEventOrderReturned
+	default:
+		return nil
+	}
+	return d.enqueueWebhookEvent(q, userID, webhookEvent, map[string]interface{}{
+		"number":  event.Number,
+		"status":  event.Status,
+		"accrual": event.Accrual,
+	}, event.CreatedAt)
+}
+
+// isFinalOrderStatus сообщает, что по заказу больше не ходят в систему начислений и не меняют начисление.
+func isFinalOrderStatus(status string) bool {
+	return status == OrderStatusProcessed || status == OrderStatusInvalid || status == OrderStatusReturned
+}
+
+// orderProcessedAt возвращает время завершения обработки для финальных статусов и nil для остальных.
+func orderProcessedAt(status string, now time.Time) *time.Time {
+	if status == OrderStatusProcessed || status == OrderStatusInvalid {
+		return &now
+	}
+	return nil
+}
+
+func (d *Database) GetOrderStatusHistory(orderID int) (events []OrderStatusEvent, err error) {
+	sql := `SELECT h.id,h.order_id,o.number,h.status,h.accrual,h.source,h.created_at FROM order_status_history h
+		JOIN orders o ON o.id = h.order_id
+		WHERE h.order_id = $1 ORDER BY h.created_at, h.id`
+	rows, err := d.pgx.Query(d.ctx, sql, orderID)
+	if err != nil {
+		return events, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var event OrderStatusEvent
+		err = rows.Scan(&event.ID, &event.OrderID, &event.Number, &event.Status, &event.Accrual, &event.Source, &event.CreatedAt)
+		if err != nil {
+			return events, err
+		}
+		events = append(events, event)
+	}
+	if err = rows.Err(); err != nil {
+		return events, err
+	}
+
+	return events, err
+}
@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"errors"
+	"github.com/jackc/pgx/v4"
+	"time"
+)
+
+func (d *Database) GetUserIDByLogin(login string) (userID int, err error) {
+	sql := "SELECT id FROM users WHERE login = $1"
+	err = d.pgx.QueryRow(d.ctx, sql, login).Scan(&userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return userID, ErrorUserNotFound
+	}
+	return userID, err
+}
+
+func (d *Database) CheckUserPassword(userID int, password string) (err error) {
+	var hash = GetMD5Hash(password)
+	var id int
+	sql := "SELECT id FROM users WHERE id = $1 AND password = $2"
+	err = d.pgx.QueryRow(d.ctx, sql, userID, hash).Scan(&id)
+	if err != nil {
+		return ErrorUserCredentials
+	}
+	return nil
+}
+
+// UpdateUserPassword меняет пароль и увеличивает версию сессий,
+// после чего ранее выданные сессии перестают действовать.
+func (d *Database) UpdateUserPassword(userID int, password string) (sessionVersion int, err error) {
+	var hash = GetMD5Hash(password)
+	sql := "UPDATE users SET password=$1,session_version=session_version+1 WHERE id=$2 RETURNING session_version"
+	err = d.pgx.QueryRow(d.ctx, sql, hash, userID).Scan(&sessionVersion)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return sessionVersion, ErrorUserNotFound
+	}
+	return sessionVersion, err
+}
+
+func (d *Database) CreatePasswordResetToken(userID int, token string, expiresAt time.Time) (err error) {
+	sql := "INSERT INTO password_reset_tokens (user_id,token_hash,expires_at) VALUES ($1, $2, $3)"
+	_, err = d.pgx.Exec(d.ctx, sql, userID, GetSHA256Hash(token), expiresAt.UTC())
+	return err
+}
+
+// GetLoginByResetToken возвращает логин владельца действующего токена сброса, чтобы проверить новый пароль
+// по политике до того, как токен будет погашен.
+func (d *Database) GetLoginByResetToken(token string) (login string, err error) {
+	sql := `SELECT u.login FROM password_reset_tokens t JOIN users u ON u.id = t.user_id
+		WHERE t.token_hash = $1 AND t.used_at IS NULL AND t.expires_at > $2`
+	err = d.pgx.QueryRow(d.ctx, sql, GetSHA256Hash(token), time.Now().UTC()).Scan(&login)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return login, ErrorResetTokenInvalid
+	}
+	return login, err
+}
+
+func (d *Database) ResetPasswordByToken(token, password string) (userID int, err error) {
+
+	tx, err := d.pgx.Begin(d.ctx)
+	if err != nil {
+		return userID, err
+	}
+	defer func() {
+		if err == nil {
+			_ = tx.Commit(d.ctx)
+		} else {
+			_ = tx.Rollback(d.ctx)
+		}
+	}()
+
+	now := time.Now().UTC()
+
+	s1 := "UPDATE password_reset_tokens SET used_at=$1 WHERE token_hash=$2 AND used_at IS NULL AND expires_at > $1 RETURNING user_id"
+	err = tx.QueryRow(d.ctx, s1, now, GetSHA256Hash(token)).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return userID, ErrorResetTokenInvalid
+		}
+		return userID, err
+	}
+
+	s2 := "UPDATE users SET password=$1,session_version=session_version+1 WHERE id=$2"
+	_, err = tx.Exec(d.ctx, s2, GetMD5Hash(password), userID)
+	if err != nil {
+		return userID, err
+	}
+
+	// остальные токены пользователя больше не нужны
+	s3 := "UPDATE password_reset_tokens SET used_at=$1 WHERE user_id=$2 AND used_at IS NULL"
+	_, err = tx.Exec(d.ctx, s3, now, userID)
+
+	return userID, err
+}
@@ -0,0 +1,241 @@
+package storage
+
+import (
+	"errors"
+	"github.com/jackc/pgx/v4"
+	"math"
+	"time"
+)
+
+// OrderReturn — возврат покупки и откат начисления за неё. Начисление сначала забирается из pending,
+// затем из неистраченного остатка партии заказа; сгоревшая часть партии уже ушла с баланса и повторно
+// не списывается. Остальное (истраченные баллы) снимается с баланса, а чего не хватает, уходит
+// в минус (AllowNegative) или в долг, который гасится из следующих начислений.
+type OrderReturn struct {
+	ID            int
+	UserID        int
+	OrderNumber   string
+	Amount        float64 // сколько баллов откатить, 0 — всё, что ещё не откачено
+	ActorID       int
+	Reason        string
+	AllowNegative bool
+	CreatedAt     time.Time
+
+	// заполняются ReturnOrder
+	FromPending float64
+	FromExpired float64 // зачтено сгоревшими баллами партии заказа
+	FromBalance float64
+	Debt        float64
+	Returned    float64 // всего откачено по заказу
+}
+
+type ClawbackRecovery struct {
+	ID          int
+	UserID      int
+	OrderNumber string
+	Amount      float64
+	CreatedAt   time.Time
+}
+
+// ReturnOrder переводит заказ в RETURNED и откатывает начисление целиком или частично.
+// Повторные частичные возвраты возможны, пока откачено меньше начисленного.
+func (d *Database) ReturnOrder(ret OrderReturn) (result OrderReturn, err error) {
+	result = ret
+
+	tx, err := d.pgx.Begin(d.ctx)
+	if err != nil {
+		return result, err
+	}
+	defer func() {
+		if err == nil {
+			_ = tx.Commit(d.ctx)
+		} else {
+			_ = tx.Rollback(d.ctx)
+		}
+	}()
+
+	var orderID int
+	var status string
+	var accrual, returned float64
+	s1 := "SELECT id, user_id, status, accrual, returned FROM orders WHERE number = $1 FOR UPDATE"
+	err = tx.QueryRow(d.ctx, s1, ret.OrderNumber).Scan(&orderID, &result.UserID, &status, &accrual, &returned)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return result, ErrorOrderNotFound
+	}
+	if err != nil {
+		return result, err
+	}
+	if status != OrderStatusProcessed && (status != OrderStatusReturned || returned >= accrual) {
+		return result, ErrorOrderNotReturnable
+	}
+	left := accrual - returned
+	if result.Amount == 0 {
+		result.Amount = left
+	}
+	if result.Amount > left {
+		return result, ErrorReturnAmount
+	}
+
+	var balance float64
+	s2 := "SELECT balance FROM users WHERE id=$1 LIMIT 1 FOR UPDATE"
+	err = tx.QueryRow(d.ctx, s2, result.UserID).Scan(&balance)
+	if err != nil {
+		return result, err
+	}
+
+	// сгоревшая партия тоже нужна: её сгоревшая часть зачитывается в возврат
+	var lot AccrualLot
+	s3 := "SELECT id, remaining, pending FROM accrual_lots WHERE user_id = $1 AND order_number = $2 ORDER BY id LIMIT 1 FOR UPDATE"
+	err = tx.QueryRow(d.ctx, s3, result.UserID, ret.OrderNumber).Scan(&lot.ID, &lot.Remaining, &lot.Pending)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return result, err
+	}
+
+	var expired float64
+	if lot.ID != 0 {
+		s4 := `SELECT (SELECT COALESCE(SUM(amount), 0) FROM point_expirations WHERE lot_id = $1)
+			- (SELECT COALESCE(SUM(from_expired), 0) FROM order_returns WHERE order_id = $2)`
+		err = tx.QueryRow(d.ctx, s4, lot.ID, orderID).Scan(&expired)
+		if err != nil {
+			return result, err
+		}
+	}
+
+	split := splitClawback(result.Amount, lot, expired, balance, ret.AllowNegative)
+	result.FromPending, result.FromExpired, result.FromBalance, result.Debt = split.FromPending, split.FromExpired, split.FromBalance, split.Debt
+
+	// несозревшее начисление просто уменьшается, в выписку оно ещё не попало
+	if split.FromPending > 0 {
+		s5 := "UPDATE accrual_lots SET amount=amount-$1, remaining=remaining-$1 WHERE id=$2"
+		_, err = tx.Exec(d.ctx, s5, split.FromPending, lot.ID)
+		if err != nil {
+			return result, err
+		}
+	}
+	// первыми забираются баллы этого же заказа, остальное — из прочих партий по порядку сгорания
+	if split.FromOwn > 0 {
+		s6 := "UPDATE accrual_lots SET remaining=remaining-$1 WHERE id=$2"
+		_, err = tx.Exec(d.ctx, s6, split.FromOwn, lot.ID)
+		if err != nil {
+			return result, err
+		}
+	}
+	if split.FromBalance > split.FromOwn {
+		err = d.consumeAccrualLots(tx, result.UserID, split.FromBalance-split.FromOwn)
+		if err != nil {
+			return result, err
+		}
+	}
+
+	s7 := "UPDATE users SET pending=pending-$1, balance=balance-$2, clawback_debt=clawback_debt+$3 WHERE id=$4"
+	_, err = tx.Exec(d.ctx, s7, result.FromPending, result.FromBalance, result.Debt, result.UserID)
+	if err != nil {
+		return result, err
+	}
+
+	result.CreatedAt = time.Now().UTC()
+	result.Returned = returned + result.Amount
+	s8 := "UPDATE orders SET status=$1, returned=$2 WHERE id=$3"
+	_, err = tx.Exec(d.ctx, s8, OrderStatusReturned, result.Returned, orderID)
+	if err != nil {
+		return result, err
+	}
+
+	s9 := `INSERT INTO order_returns (user_id,order_id,order_number,amount,from_pending,from_expired,from_balance,debt,actor_id,reason,created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) RETURNING id`
+	err = tx.QueryRow(d.ctx, s9, result.UserID, orderID, ret.OrderNumber, result.Amount, result.FromPending, result.FromExpired,
+		result.FromBalance, result.Debt, nullableID(ret.ActorID), ret.Reason, result.CreatedAt).Scan(&result.ID)
+	if err != nil {
+		return result, err
+	}
+
+	err = d.createOrderStatusEvent(tx, result.UserID, OrderStatusEvent{
+		OrderID:   orderID,
+		Number:    ret.OrderNumber,
+		Status:    OrderStatusReturned,
+		Accrual:   -result.Amount,
+		Source:    OrderSourceReturn,
+		CreatedAt: result.CreatedAt,
+	})
+	return result, err
+}
+
+// clawbackSplit — из чего складывается откат начисления, см. splitClawback.
+type clawbackSplit struct {
+	FromPending float64
+	FromOwn     float64 // часть FromBalance из неистраченного остатка партии заказа
+	FromExpired float64
+	FromBalance float64
+	Debt        float64
+}
+
+// splitClawback раскладывает откат amount: pending партии заказа, её неистраченный остаток,
+// ещё не зачтённые сгоревшие баллы (expired), остальное — с баланса. Без allowNegative с баланса
+// снимается не больше, чем на нём есть, нехватка становится долгом.
+func splitClawback(amount float64, lot AccrualLot, expired, balance float64, allowNegative bool) (split clawbackSplit) {
+	rest := amount
+	if lot.Pending {
+		split.FromPending = math.Min(rest, lot.Remaining)
+		rest -= split.FromPending
+	} else {
+		split.FromOwn = math.Min(rest, math.Max(lot.Remaining, 0))
+		rest -= split.FromOwn
+	}
+
+	split.FromExpired = math.Min(rest, math.Max(expired, 0))
+	rest -= split.FromExpired
+
+	split.FromBalance = split.FromOwn + rest
+	if !allowNegative {
+		split.FromBalance = math.Min(split.FromBalance, math.Max(balance, 0))
+		split.FromOwn = math.Min(split.FromOwn, split.FromBalance)
+	}
+	split.Debt = amount - split.FromPending - split.FromExpired - split.FromBalance
+	return split
+}
+
+// splitAccrualCredit делит зачисленное начисление amount: covered закрыло минус баланса
+// (balance — баланс уже после зачисления), recovered гасит долг по возвратам debt.
+func splitAccrualCredit(amount, balance, debt float64) (covered, recovered float64) {
+	covered = math.Min(math.Max(amount-balance, 0), amount)
+	recovered = math.Min(amount-covered, debt)
+	return covered, recovered
+}
+
+// creditAccrual зачисляет ставшее доступным начисление на баланс (из pending, если fromPending).
+// Если баланс в минусе после возврата, начисление сначала закрывает минус; если есть долг
+// по возвратам, он гасится из начисления. Погашенная часть списывается и из партий.
+func (d *Database) creditAccrual(tx pgx.Tx, userID int, orderNumber string, amount float64, fromPending bool, now time.Time) (err error) {
+	pending := 0.0
+	if fromPending {
+		pending = amount
+	}
+
+	var balance, debt float64
+	s1 := "UPDATE users SET balance=balance+$1, pending=pending-$2 WHERE id=$3 RETURNING balance, clawback_debt"
+	err = tx.QueryRow(d.ctx, s1, amount, pending, userID).Scan(&balance, &debt)
+	if err != nil {
+		return err
+	}
+
+	covered, recovered := splitAccrualCredit(amount, balance, debt)
+
+	if recovered > 0 {
+		s2 := "UPDATE users SET balance=balance-$1, clawback_debt=clawback_debt-$1 WHERE id=$2"
+		_, err = tx.Exec(d.ctx, s2, recovered, userID)
+		if err != nil {
+			return err
+		}
+
+		s3 := "INSERT INTO clawback_recoveries (user_id,order_number,amount,created_at) VALUES ($1, $2, $3, $4)"
+		_, err = tx.Exec(d.ctx, s3, userID, orderNumber, recovered, now)
+		if err != nil {
+			return err
+		}
+	}
+
+	if covered+recovered > 0 {
+		return d.consumeAccrualLots(tx, userID, covered+recovered)
+	}
+	return nil
+}
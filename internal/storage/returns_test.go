@@ -0,0 +1,143 @@
+package storage
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSplitClawback(t *testing.T) {
+	tests := []struct {
+		name          string
+		amount        float64
+		lot           AccrualLot
+		expired       float64
+		balance       float64
+		allowNegative bool
+		want          clawbackSplit
+	}{
+		{"unspent lot", 100, AccrualLot{Remaining: 100}, 0, 500, false,
+			clawbackSplit{FromOwn: 100, FromBalance: 100}},
+		{"pending lot", 100, AccrualLot{Remaining: 100, Pending: true}, 0, 500, false,
+			clawbackSplit{FromPending: 100}},
+		{"partly spent lot", 100, AccrualLot{Remaining: 30}, 0, 500, false,
+			clawbackSplit{FromOwn: 30, FromBalance: 100}},
+		{"expired points not taken again", 100, AccrualLot{}, 40, 500, false,
+			clawbackSplit{FromExpired: 40, FromBalance: 60}},
+		{"shortfall becomes debt", 100, AccrualLot{}, 0, 25, false,
+			clawbackSplit{FromBalance: 25, Debt: 75}},
+		{"shortfall with negative balance allowed", 100, AccrualLot{}, 0, 25, true,
+			clawbackSplit{FromBalance: 100}},
+		{"balance already negative", 50, AccrualLot{}, 0, -10, false,
+			clawbackSplit{Debt: 50}},
+		{"own remainder above balance", 50, AccrualLot{Remaining: 50}, 0, 20, false,
+			clawbackSplit{FromOwn: 20, FromBalance: 20, Debt: 30}},
+		{"partial return", 25, AccrualLot{Remaining: 100}, 0, 500, false,
+			clawbackSplit{FromOwn: 25, FromBalance: 25}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := splitClawback(tt.amount, tt.lot, tt.expired, tt.balance, tt.allowNegative)
+			if got != tt.want {
+				t.Errorf("splitClawback() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitAccrualCredit(t *testing.T) {
+	tests := []struct {
+		name          string
+		amount        float64
+		balance       float64
+		debt          float64
+		wantCovered   float64
+		wantRecovered float64
+	}{
+		{"positive balance, no debt", 100, 300, 0, 0, 0},
+		{"closes negative balance", 100, 70, 0, 30, 0},
+		{"balance stays negative", 100, -50, 0, 100, 0},
+		{"recovers debt", 100, 300, 40, 0, 40},
+		{"debt above accrual", 100, 300, 250, 0, 100},
+		{"negative balance and debt", 100, 70, 50, 30, 50},
+		{"nothing left for debt", 100, -50, 50, 100, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			covered, recovered := splitAccrualCredit(tt.amount, tt.balance, tt.debt)
+			if covered != tt.wantCovered || recovered != tt.wantRecovered {
+				t.Errorf("splitAccrualCredit(%v, %v, %v) = %v, %v, want %v, %v",
+					tt.amount, tt.balance, tt.debt, covered, recovered, tt.wantCovered, tt.wantRecovered)
+			}
+		})
+	}
+}
+
+// TestMaturePoints проверяет перевод начислений из pending в доступный баланс на настоящей базе,
+// см. newTestDatabase: созревшее начисление сначала закрывает минус баланса, потом гасит долг по возвратам.
+func TestMaturePoints(t *testing.T) {
+	d := newTestDatabase(t)
+	past := time.Now().UTC().Add(-time.Minute)
+	future := time.Now().UTC().Add(time.Hour)
+	pendingLot := func(amount float64, availableAt time.Time) AccrualLot {
+		return AccrualLot{Amount: amount, Pending: true, AvailableAt: &availableAt}
+	}
+
+	tests := []struct {
+		name          string
+		lots          []AccrualLot
+		balance       float64
+		debt          float64
+		wantBalance   UserBalance
+		wantRemaining []float64
+		wantRecovered float64
+	}{
+		{"matures into balance", []AccrualLot{{Amount: 100}, pendingLot(50, past)}, 100, 0,
+			UserBalance{Balance: 150}, []float64{100, 50}, 0},
+		{"not yet available", []AccrualLot{{Amount: 100}, pendingLot(50, future)}, 100, 0,
+			UserBalance{Balance: 100, Pending: 50}, []float64{100, 50}, 0},
+		{"only due lots mature", []AccrualLot{pendingLot(30, past), pendingLot(50, future)}, 0, 0,
+			UserBalance{Balance: 30, Pending: 50}, []float64{30, 50}, 0},
+		{"pays clawback debt", []AccrualLot{pendingLot(50, past)}, 0, 30,
+			UserBalance{Balance: 20}, []float64{20}, 30},
+		{"debt above accrual", []AccrualLot{pendingLot(50, past)}, 0, 80,
+			UserBalance{Debt: 30}, []float64{0}, 50},
+		{"closes negative balance", []AccrualLot{pendingLot(50, past)}, -20, 0,
+			UserBalance{Balance: 30}, []float64{30}, 0},
+		{"negative balance and debt", []AccrualLot{pendingLot(50, past)}, -20, 10,
+			UserBalance{Balance: 20}, []float64{20}, 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userID := newTestUser(t, d, tt.lots...)
+			sql := "UPDATE users SET balance=$1, clawback_debt=$2 WHERE id=$3"
+			if _, err := d.pgx.Exec(d.ctx, sql, tt.balance, tt.debt, userID); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := d.MaturePoints(); err != nil {
+				t.Fatal(err)
+			}
+			// повторный запуск ничего не меняет
+			if _, err := d.MaturePoints(); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := testBalance(t, d, userID); got != tt.wantBalance {
+				t.Errorf("balance = %+v, want %+v", got, tt.wantBalance)
+			}
+			if got := testRemaining(t, d, userID); !reflect.DeepEqual(got, tt.wantRemaining) {
+				t.Errorf("lots remaining = %v, want %v", got, tt.wantRemaining)
+			}
+
+			var recovered float64
+			sql = "SELECT COALESCE(SUM(amount), 0) FROM clawback_recoveries WHERE user_id=$1"
+			if err := d.pgx.QueryRow(d.ctx, sql, userID).Scan(&recovered); err != nil {
+				t.Fatal(err)
+			}
+			if recovered != tt.wantRecovered {
+				t.Errorf("recovered = %v, want %v", recovered, tt.wantRecovered)
+			}
+		})
+	}
+}
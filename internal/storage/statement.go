@@ -0,0 +1,105 @@
+package storage
+
+import (
+	"time"
+)
+
+const (
+	StatementLineAccrual     string = "accrual"
+	StatementLineWithdrawal         = "withdrawal"
+	StatementLineAdjustment         = "adjustment"
+	StatementLineRefund             = "refund"
+	StatementLineExpiry             = "expiry"
+	StatementLineReturn             = "return"
+	StatementLineRecovery           = "recovery"
+	StatementLineHold               = "hold"
+	StatementLineHoldRelease        = "hold_release"
+)
+
+type StatementLine struct {
+	At      time.Time
+	Type    string
+	Ref     string // номер заказа или причина корректировки
+	Amount  float64
+	Balance float64 // баланс после операции
+}
+
+type Statement struct {
+	Opening float64
+	Closing float64
+	Lines   []StatementLine
+}
+
+// statementLinesSQL собирает все движения баланса пользователя $1: начисления в момент, когда они стали доступны
+// (заказы до появления партий — по времени обработки, ожидающие начисления не попадают),
+// списания, возвраты отменённых списаний, сгорание баллов, откаты начислений за возвращённые покупки
+// (только снятое с баланса) и погашение долга по ним, ручные корректировки администраторов
+// (без них выписка не сходится с балансом), резервы и их закрытие. Резерв снимает всю сумму с баланса
+// при создании и возвращает её при закрытии; списанная часть идёт отдельной строкой withdrawal с тем же временем,
+// поэтому у возврата резерва kind меньше, чем у списания.
+const statementLinesSQL = `WITH lines AS (
+		SELECT COALESCE(o.processed_at, o.uploaded_at) AS at, 1 AS kind, o.id, o.number AS ref, o.accrual AS amount FROM orders o
+		WHERE o.user_id = $1 AND o.status = 'PROCESSED' AND o.accrual > 0
+			AND NOT EXISTS (SELECT 1 FROM accrual_lots l WHERE l.user_id = o.user_id AND l.order_number = o.number)
+		UNION ALL
+		SELECT COALESCE(available_at, created_at), 1, id, order_number, amount FROM accrual_lots
+		WHERE user_id = $1 AND NOT pending
+		UNION ALL
+		SELECT processed_at, 2, id, order_number, -sum FROM withdrawals WHERE user_id = $1
+		UNION ALL
+		SELECT created_at, 3, id, reason, amount FROM balance_adjustments WHERE user_id = $1
+		UNION ALL
+		SELECT cancelled_at, 4, id, order_number, sum FROM withdrawals WHERE user_id = $1 AND cancelled_at IS NOT NULL
+		UNION ALL
+		SELECT created_at, 5, id, order_number, -amount FROM point_expirations WHERE user_id = $1
+		UNION ALL
+		SELECT created_at, 6, id, order_number, -from_balance FROM order_returns WHERE user_id = $1 AND from_balance > 0
+		UNION ALL
+		SELECT created_at, 7, id, order_number, -amount FROM clawback_recoveries WHERE user_id = $1
+		UNION ALL
+		SELECT created_at, 8, id, order_number, -sum FROM withdrawal_holds WHERE user_id = $1
+		UNION ALL
+		SELECT closed_at, 0, id, order_number, sum FROM withdrawal_holds WHERE user_id = $1 AND closed_at IS NOT NULL
+	),
+	running AS (
+		SELECT at, kind, id, ref, amount, SUM(amount) OVER (ORDER BY at, kind, id ROWS UNBOUNDED PRECEDING) AS balance FROM lines
+	)`
+
+var statementLineTypes = map[int]string{0: StatementLineHoldRelease, 1: StatementLineAccrual, 2: StatementLineWithdrawal, 3: StatementLineAdjustment,
+	4: StatementLineRefund, 5: StatementLineExpiry, 6: StatementLineReturn, 7: StatementLineRecovery, 8: StatementLineHold}
+
+// GetStatement возвращает выписку за период [from, to), nil — без ограничения с этой стороны.
+func (d *Database) GetStatement(userID int, from, to *time.Time) (statement Statement, err error) {
+	s1 := statementLinesSQL + ` SELECT COALESCE(SUM(amount), 0) FROM lines WHERE $2::timestamptz IS NOT NULL AND at < $2`
+	err = d.pgx.QueryRow(d.ctx, s1, userID, from).Scan(&statement.Opening)
+	if err != nil {
+		return statement, err
+	}
+
+	s2 := statementLinesSQL + ` SELECT at, kind, ref, amount, balance FROM running
+		WHERE ($2::timestamptz IS NULL OR at >= $2) AND ($3::timestamptz IS NULL OR at < $3)
+		ORDER BY at, kind, id`
+	rows, err := d.pgx.Query(d.ctx, s2, userID, from, to)
+	if err != nil {
+		return statement, err
+	}
+	defer rows.Close()
+
+	statement.Closing = statement.Opening
+	for rows.Next() {
+		var line StatementLine
+		var kind int
+		err = rows.Scan(&line.At, &kind, &line.Ref, &line.Amount, &line.Balance)
+		if err != nil {
+			return statement, err
+		}
+		line.Type = statementLineTypes[kind]
+		statement.Closing = line.Balance
+		statement.Lines = append(statement.Lines, line)
+	}
+	if err = rows.Err(); err != nil {
+		return statement, err
+	}
+
+	return statement, err
+}
@@ -0,0 +1,60 @@
+package storage
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestStatementHolds проверяет на настоящей базе (см. newTestDatabase), что выписка сходится
+// с балансом на каждом шаге жизни резерва.
+func TestStatementHolds(t *testing.T) {
+	d := newTestDatabase(t)
+
+	tests := []struct {
+		name      string
+		close     func(userID int, hold Hold) error
+		wantTypes []string
+	}{
+		{"open", func(int, Hold) error { return nil },
+			[]string{StatementLineAccrual, StatementLineHold}},
+		{"captured", func(userID int, hold Hold) error {
+			_, err := d.CaptureHold(userID, hold.ID, 50)
+			return err
+		}, []string{StatementLineAccrual, StatementLineHold, StatementLineHoldRelease, StatementLineWithdrawal}},
+		{"voided", func(userID int, hold Hold) error {
+			_, err := d.VoidHold(userID, hold.ID)
+			return err
+		}, []string{StatementLineAccrual, StatementLineHold, StatementLineHoldRelease}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userID := newTestUser(t, d, testDBLot(100, 0))
+			hold, err := d.CreateHold(Hold{UserID: userID, OrderNumber: testNumber(), Sum: 80, ExpiresAt: time.Now().Add(time.Hour)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = tt.close(userID, hold); err != nil {
+				t.Fatal(err)
+			}
+
+			statement, err := d.GetStatement(userID, nil, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if balance := testBalance(t, d, userID); statement.Closing != balance.Balance {
+				t.Errorf("closing balance = %v, want %v", statement.Closing, balance.Balance)
+			}
+			var types []string
+			for _, line := range statement.Lines {
+				types = append(types, line.Type)
+				if line.Balance < 0 {
+					t.Errorf("negative running balance in %+v", line)
+				}
+			}
+			if !reflect.DeepEqual(types, tt.wantTypes) {
+				t.Errorf("line types = %v, want %v", types, tt.wantTypes)
+			}
+		})
+	}
+}
@@ -8,33 +8,53 @@ import (
 	"github.com/jackc/pgconn"
 	"github.com/jackc/pgerrcode"
 	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
 	"log"
 	"os"
 	"time"
 )
 
+// Database работает через пул соединений: фоновые задачи, потоки и обработчики выполняются параллельно,
+// а одно pgx.Conn нельзя использовать из нескольких горутин.
 type Database struct {
-	pgx *pgx.Conn
+	pgx *pgxpool.Pool
 	ctx context.Context
+	dsn string
 }
 
 type UserTable struct {
 	ID        int
 	Login     string
 	Password  string
+	Role      string
+	Frozen    bool
 	Balance   float64
 	Withdrawn float64
+
+	SessionVersion int
 }
 
 type UserBalance struct {
-	Balance   float64
+	Balance   float64 // доступно для списания
 	Withdrawn float64
+	Held      float64 // зарезервировано под оплату, см. Hold
+	Pending   float64 // начислено, но ещё не доступно, см. AccrualLot
+	Debt      float64 // долг по возвратам, гасится из следующих начислений, см. ReturnOrder
 }
 
 const (
-	OrderStatusNew       string = "NEW"       // — заказ загружен в систему, но не попал в обработку;
-	OrderStatusInvalid          = "INVALID"   //INVALID — система расчёта вознаграждений отказала в расчёте;
-	OrderStatusProcessed        = "PROCESSED" //PROCESSED — данные по заказу проверены и информация о расчёте успешно получена.
+	UserRoleUser     string = "user"
+	UserRoleSupport         = "support"
+	UserRoleMerchant        = "merchant"
+	UserRoleAdmin           = "admin"
+)
+
+const (
+	OrderStatusNew        string = "NEW"        // — заказ загружен в систему, но не попал в обработку;
+	OrderStatusProcessing        = "PROCESSING" // PROCESSING — вознаграждение за заказ рассчитывается;
+	OrderStatusInvalid           = "INVALID"    //INVALID — система расчёта вознаграждений отказала в расчёте;
+	OrderStatusProcessed         = "PROCESSED"  //PROCESSED — данные по заказу проверены и информация о расчёте успешно получена.
+	OrderStatusReturned          = "RETURNED"   // RETURNED — покупку вернули, начисление откачено целиком или частично.
 )
 
 type OrderTable struct {
@@ -43,20 +63,45 @@ type OrderTable struct {
 	Number     string
 	Status     string
 	Accrual    float64
+	Returned   float64 // откачено по возвратам, см. ReturnOrder
 	UploadedAt time.Time
+
+	ProcessedAt *time.Time // nil, пока заказ не получил финальный статус
+
+	// только для UpdateOrderByNumber
+	AccrualExpiresAt   *time.Time // срок сгорания начисления, nil — бессрочно
+	AccrualAvailableAt *time.Time // до этого момента начисление лежит в pending, nil — доступно сразу
+}
+
+// ListFilter ограничивает выборку списков заказов и списаний.
+// Записи идут от новых к старым, After — ключ последней записи предыдущей страницы.
+type ListFilter struct {
+	Statuses []string   // пусто — любые статусы
+	From     *time.Time // включительно
+	To       *time.Time // не включительно
+	After    *ListCursor
+	Limit    int
+}
+
+type ListCursor struct {
+	At time.Time
+	ID int
 }
 
 type WithdrawalTable struct {
-	ID          int
-	UserID      int
-	OrderNumber string
-	Sum         float64
-	ProcessedAt time.Time
+	ID           int
+	UserID       int
+	OrderNumber  string
+	Sum          float64
+	Status       string
+	ProcessedAt  time.Time
+	CancelledAt  *time.Time
+	CancelReason string
 }
 
 func New(dataSourceName string) *Database {
 	ctx := context.Background()
-	db, err := pgx.Connect(ctx, dataSourceName)
+	db, err := pgxpool.Connect(ctx, dataSourceName)
 	if err != nil {
 		panic(err)
 	}
@@ -71,10 +116,11 @@ func New(dataSourceName string) *Database {
 	return &Database{
 		pgx: db,
 		ctx: ctx,
+		dsn: dataSourceName,
 	}
 }
 
-func CreateTables(ctx context.Context, db *pgx.Conn) error {
+func CreateTables(ctx context.Context, db *pgxpool.Pool) error {
 	c, ioErr := os.ReadFile("migrations/tables.sql")
 	if ioErr != nil {
 		log.Println("OS: read file tables: ", ioErr)
@@ -97,8 +143,11 @@ func GetMD5Hash(text string) string {
 
 func (d *Database) CreateUser(user UserTable) (userID int, err error) {
 	var hash = GetMD5Hash(user.Password)
-	sql := "INSERT INTO users (login,password) VALUES ($1, $2) RETURNING id"
-	err = d.pgx.QueryRow(d.ctx, sql, user.Login, hash).Scan(&userID)
+	if user.Role == "" {
+		user.Role = UserRoleUser
+	}
+	sql := "INSERT INTO users (login,password,role) VALUES ($1, $2, $3) RETURNING id"
+	err = d.pgx.QueryRow(d.ctx, sql, user.Login, hash, user.Role).Scan(&userID)
 	if err != nil {
 		var pgErr *pgconn.PgError
 		if errors.As(err, &pgErr) {
@@ -120,10 +169,50 @@ func (d *Database) GetUserIDByCredentials(login, password string) (userID int, e
 	return userID, err
 }
 
+func (d *Database) GetUserByID(userID int) (user UserTable, err error) {
+	sql := "SELECT id,login,role,frozen,balance,withdrawn,session_version FROM users WHERE id = $1 LIMIT 1"
+	err = d.pgx.QueryRow(d.ctx, sql, userID).Scan(&user.ID, &user.Login, &user.Role, &user.Frozen, &user.Balance, &user.Withdrawn, &user.SessionVersion)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return user, ErrorUserNotFound
+	}
+	return user, err
+}
+
+func (d *Database) SetUserRole(userID int, role string) (err error) {
+	sql := "UPDATE users SET role=$1 WHERE id=$2"
+	tag, err := d.pgx.Exec(d.ctx, sql, role, userID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrorUserNotFound
+	}
+	return nil
+}
+
+func (d *Database) CountUsersByRole(role string) (count int, err error) {
+	sql := "SELECT count(*) FROM users WHERE role = $1"
+	err = d.pgx.QueryRow(d.ctx, sql, role).Scan(&count)
+	return count, err
+}
+
 func (d *Database) CreateOrder(order OrderTable) (err error) {
 	var ID int
-	sql := "INSERT INTO orders (user_id,number,status,uploaded_at) VALUES ($1, $2, $3, $4) ON CONFLICT (number) DO NOTHING RETURNING id"
-	err = d.pgx.QueryRow(d.ctx, sql, order.UserID, order.Number, order.Status, time.Now().UTC()).Scan(&ID)
+	sql := `WITH inserted AS (
+			INSERT INTO orders (user_id,number,status,uploaded_at) VALUES ($1, $2, $3, $4)
+			ON CONFLICT (number) DO NOTHING
+			RETURNING id, status, uploaded_at
+		),
+		seq AS (
+			UPDATE users SET order_event_seq = order_event_seq + 1 WHERE id = $1 AND EXISTS (SELECT 1 FROM inserted)
+			RETURNING order_event_seq
+		),
+		history AS (
+			INSERT INTO order_status_history (order_id,status,accrual,source,created_at,user_seq)
+			SELECT id, status, 0, $5, uploaded_at, order_event_seq FROM inserted, seq
+		)
+		SELECT id FROM inserted`
+	err = d.pgx.QueryRow(d.ctx, sql, order.UserID, order.Number, order.Status, time.Now().UTC(), OrderSourceUpload).Scan(&ID)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return ErrorOrderAlreadyExists
@@ -132,9 +221,64 @@ func (d *Database) CreateOrder(order OrderTable) (err error) {
 	return err
 }
 
+type OrderUploadResult struct {
+	Number  string
+	UserID  int  // владелец заказа после загрузки
+	Created bool // заказ добавлен этим запросом
+}
+
+// CreateOrders загружает пачку номеров одним запросом и для каждого номера сообщает,
+// добавлен ли он и кому принадлежит. Номера должны быть уникальны.
+func (d *Database) CreateOrders(userID int, numbers []string) (results []OrderUploadResult, err error) {
+	sql := `WITH input AS (SELECT unnest($2::text[]) AS number),
+		inserted AS (
+			INSERT INTO orders (user_id,number,status,uploaded_at)
+			SELECT $1, number, $3, $4 FROM input
+			ON CONFLICT (number) DO NOTHING
+			RETURNING id, number, user_id, status, uploaded_at
+		),
+		seq AS (
+			UPDATE users SET order_event_seq = order_event_seq + (SELECT count(*) FROM inserted)
+			WHERE id = $1 AND EXISTS (SELECT 1 FROM inserted)
+			RETURNING order_event_seq - (SELECT count(*) FROM inserted) AS base
+		),
+		history AS (
+			INSERT INTO order_status_history (order_id,status,accrual,source,created_at,user_seq)
+			SELECT id, status, 0, $5, uploaded_at, base + row_number() OVER (ORDER BY id) FROM inserted, seq
+		)
+		SELECT i.number, COALESCE(ins.user_id, o.user_id, 0), ins.number IS NOT NULL
+		FROM input i
+		LEFT JOIN inserted ins ON ins.number = i.number
+		LEFT JOIN orders o ON o.number = i.number`
+	rows, err := d.pgx.Query(d.ctx, sql, userID, numbers, OrderStatusNew, time.Now().UTC(), OrderSourceUpload)
+	if err != nil {
+		return results, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var result OrderUploadResult
+		err = rows.Scan(&result.Number, &result.UserID, &result.Created)
+		if err != nil {
+			return results, err
+		}
+		results = append(results, result)
+	}
+	if err = rows.Err(); err != nil {
+		return results, err
+	}
+
+	return results, err
+}
+
+// UpdateOrderByNumber сохраняет ответ системы начислений, зачисляет баллы
+// и пишет переход в историю статусов. Время загрузки заказа не меняется.
 func (d *Database) UpdateOrderByNumber(number string, order OrderTable) (err error) {
 
 	tx, err := d.pgx.Begin(d.ctx)
+	if err != nil {
+		return err
+	}
 	defer func() {
 		if err == nil {
 			_ = tx.Commit(d.ctx)
@@ -143,51 +287,91 @@ func (d *Database) UpdateOrderByNumber(number string, order OrderTable) (err err
 		}
 	}()
 
-	var balance float64
 	var orderID int
 	var userID int
+	var prevStatus string
 
-	if order.Accrual > 0 {
+	sql := "SELECT id, user_id, status FROM orders WHERE number = $1 FOR UPDATE"
+	err = tx.QueryRow(d.ctx, sql, number).Scan(&orderID, &userID, &prevStatus)
+	if err != nil {
+		return err
+	}
+	// повторный ответ системы начислений не должен начислить баллы второй раз или отменить возврат
+	if isFinalOrderStatus(prevStatus) {
+		return ErrorOrderFinal
+	}
 
-		sql := "SELECT u.id, u.balance, o.id FROM users u JOIN orders o ON u.id = o.user_id WHERE o.number = $1 LIMIT 1"
-		err = tx.QueryRow(d.ctx, sql, number).Scan(&userID, &balance, &orderID)
+	now := time.Now().UTC()
+	if order.Accrual > 0 {
+		pending := order.AccrualAvailableAt != nil && order.AccrualAvailableAt.After(now)
+		err = d.createAccrualLot(tx, AccrualLot{
+			UserID:      userID,
+			OrderNumber: number,
+			Amount:      order.Accrual,
+			CreatedAt:   now,
+			ExpiresAt:   order.AccrualExpiresAt,
+			AvailableAt: order.AccrualAvailableAt,
+			Pending:     pending,
+		})
 		if err != nil {
 			return err
 		}
 
-		resultBalance := balance + order.Accrual
-		sql = "UPDATE users SET balance=$1 WHERE id=$2"
-		_, err = tx.Exec(d.ctx, sql, resultBalance, userID)
-		if err != nil {
-			return err
+		if pending {
+			sql = "UPDATE users SET pending=pending+$1 WHERE id=$2"
+			_, err = tx.Exec(d.ctx, sql, order.Accrual, userID)
+		} else {
+			err = d.creditAccrual(tx, userID, number, order.Accrual, false, now)
 		}
-
-		sql = "UPDATE orders SET status=$1,accrual=$2,uploaded_at=$3 WHERE number=$4"
-		_, err = tx.Exec(d.ctx, sql, order.Status, order.Accrual, time.Now().UTC(), number)
 		if err != nil {
 			return err
 		}
+	}
 
-	} else {
-		sql := "UPDATE orders SET status=$1,uploaded_at=$3 WHERE number=$4"
-		_, err = tx.Exec(d.ctx, sql, order.Status, time.Now().UTC(), number)
-		if err != nil {
-			return err
-		}
+	sql = "UPDATE orders SET status=$1,accrual=$2,processed_at=$3 WHERE id=$4"
+	_, err = tx.Exec(d.ctx, sql, order.Status, order.Accrual, orderProcessedAt(order.Status, now), orderID)
+	if err != nil {
+		return err
+	}
+
+	if order.Status != prevStatus {
+		err = d.createOrderStatusEvent(tx, userID, OrderStatusEvent{
+			OrderID:   orderID,
+			Number:    number,
+			Status:    order.Status,
+			Accrual:   order.Accrual,
+			Source:    OrderSourceAccrual,
+			CreatedAt: now,
+		})
 	}
 
 	return err
 }
 
 func (d *Database) GetOrderByNumber(number string) (order OrderTable, err error) {
-	sql := "SELECT id,user_id,number,status,accrual,uploaded_at FROM orders WHERE number = $1"
-	err = d.pgx.QueryRow(d.ctx, sql, number).Scan(&order.ID, &order.UserID, &order.Number, &order.Status, &order.Accrual, &order.UploadedAt)
+	sql := "SELECT id,user_id,number,status,accrual,returned,uploaded_at,processed_at FROM orders WHERE number = $1"
+	err = d.pgx.QueryRow(d.ctx, sql, number).Scan(&order.ID, &order.UserID, &order.Number, &order.Status, &order.Accrual, &order.Returned, &order.UploadedAt, &order.ProcessedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return order, ErrorOrderNotFound
+	}
 	return order, err
 }
 
-func (d *Database) GetOrdersByUserID(userID int) (orders []OrderTable, err error) {
-	sql := "SELECT id,user_id,number,status,accrual,uploaded_at FROM orders WHERE user_id = $1 ORDER BY uploaded_at DESC"
-	rows, err := d.pgx.Query(d.ctx, sql, userID)
+func (d *Database) GetOrdersByUserID(userID int, filter ListFilter) (orders []OrderTable, err error) {
+	var afterAt *time.Time
+	var afterID int
+	if filter.After != nil {
+		afterAt, afterID = &filter.After.At, filter.After.ID
+	}
+
+	sql := `SELECT id,user_id,number,status,accrual,returned,uploaded_at,processed_at FROM orders
+		WHERE user_id = $1
+			AND (COALESCE(cardinality($2::text[]), 0) = 0 OR status = ANY($2))
+			AND ($3::timestamptz IS NULL OR uploaded_at >= $3)
+			AND ($4::timestamptz IS NULL OR uploaded_at < $4)
+			AND ($5::timestamptz IS NULL OR (uploaded_at, id) < ($5, $6))
+		ORDER BY uploaded_at DESC, id DESC LIMIT $7`
+	rows, err := d.pgx.Query(d.ctx, sql, userID, filter.Statuses, filter.From, filter.To, afterAt, afterID, filter.Limit)
 	if err != nil {
 		return orders, err
 	}
@@ -195,7 +379,7 @@ func (d *Database) GetOrdersByUserID(userID int) (orders []OrderTable, err error
 
 	for rows.Next() {
 		var order OrderTable
-		err = rows.Scan(&order.ID, &order.UserID, &order.Number, &order.Status, &order.Accrual, &order.UploadedAt)
+		err = rows.Scan(&order.ID, &order.UserID, &order.Number, &order.Status, &order.Accrual, &order.Returned, &order.UploadedAt, &order.ProcessedAt)
 		if err != nil {
 			return orders, err
 		}
@@ -209,8 +393,8 @@ func (d *Database) GetOrdersByUserID(userID int) (orders []OrderTable, err error
 }
 
 func (d *Database) GetProcessingOrderList() (orders []OrderTable, err error) {
-	sql := "SELECT number,status FROM orders WHERE status NOT IN ($1,$2)"
-	rows, err := d.pgx.Query(d.ctx, sql, OrderStatusProcessed, OrderStatusInvalid)
+	sql := "SELECT number,status FROM orders WHERE status NOT IN ($1,$2,$3)"
+	rows, err := d.pgx.Query(d.ctx, sql, OrderStatusProcessed, OrderStatusInvalid, OrderStatusReturned)
 	if err != nil {
 		return orders, err
 	}
@@ -232,11 +416,19 @@ func (d *Database) GetProcessingOrderList() (orders []OrderTable, err error) {
 }
 
 func (d *Database) GetUserBalance(userID int) (userBalance UserBalance, err error) {
-	sql := "SELECT balance,withdrawn FROM users WHERE id = $1 LIMIT 1"
-	err = d.pgx.QueryRow(d.ctx, sql, userID).Scan(&userBalance.Balance, &userBalance.Withdrawn)
+	sql := "SELECT balance,withdrawn,held,pending,clawback_debt FROM users WHERE id = $1 LIMIT 1"
+	err = d.pgx.QueryRow(d.ctx, sql, userID).Scan(&userBalance.Balance, &userBalance.Withdrawn, &userBalance.Held, &userBalance.Pending, &userBalance.Debt)
 	return userBalance, err
 }
 
+// checkWithdrawBalance — общее правило для списаний и резервов: можно потратить весь баланс, но не больше.
+func checkWithdrawBalance(balance, sum float64) error {
+	if balance-sum < 0 {
+		return ErrorUserBalanceWithdraw
+	}
+	return nil
+}
+
 func (d *Database) CreateUserWithdraw(userID int, orderNumber string, sum float64) (err error) {
 
 	tx, err := d.pgx.Begin(d.ctx)
@@ -251,17 +443,18 @@ func (d *Database) CreateUserWithdraw(userID int, orderNumber string, sum float6
 	var balance float64
 	var withdrawn float64
 
-	s1 := "SELECT balance,withdrawn FROM users WHERE id=$1 LIMIT 1"
+	// блокировка строки: баланс параллельно меняют резервы и отмены списаний
+	s1 := "SELECT balance,withdrawn FROM users WHERE id=$1 LIMIT 1 FOR UPDATE"
 	err = tx.QueryRow(d.ctx, s1, userID).Scan(&balance, &withdrawn)
 	if err != nil {
 		return err
 	}
 
+	if err = checkWithdrawBalance(balance, sum); err != nil {
+		return err
+	}
 	resultSum := balance - sum
 	resultWithdrawn := withdrawn + sum
-	if resultSum <= 0 {
-		return ErrorUserBalanceWithdraw
-	}
 
 	s2 := "UPDATE users SET balance=$1,withdrawn=$2 WHERE id=$3"
 	_, err = tx.Exec(d.ctx, s2, resultSum, resultWithdrawn, userID)
@@ -269,8 +462,12 @@ func (d *Database) CreateUserWithdraw(userID int, orderNumber string, sum float6
 		return err
 	}
 
-	s3 := "INSERT INTO withdrawals (user_id,order_number,sum,processed_at) VALUES ($1, $2, $3, $4)"
-	_, err = tx.Exec(d.ctx, s3, userID, orderNumber, sum, time.Now().UTC())
+	err = d.consumeAccrualLots(tx, userID, sum)
+	if err != nil {
+		return err
+	}
+
+	_, err = d.insertWithdrawal(tx, userID, orderNumber, sum, time.Now().UTC())
 	if err != nil {
 		return err
 	}
@@ -278,9 +475,40 @@ func (d *Database) CreateUserWithdraw(userID int, orderNumber string, sum float6
 	return err
 }
 
-func (d *Database) GetWithdrawListByUserID(userID int) (withdrawals []WithdrawalTable, err error) {
-	sql := "SELECT id,user_id,order_number,sum, processed_at FROM withdrawals WHERE user_id = $1 ORDER BY processed_at DESC"
-	rows, err := d.pgx.Query(d.ctx, sql, userID)
+// insertWithdrawal записывает списание и событие для вебхуков, баланс меняет вызывающий.
+func (d *Database) insertWithdrawal(tx pgx.Tx, userID int, orderNumber string, sum float64, now time.Time) (id int, err error) {
+	sql := "INSERT INTO withdrawals (user_id,order_number,sum,status,processed_at) VALUES ($1, $2, $3, $4, $5) RETURNING id"
+	err = tx.QueryRow(d.ctx, sql, userID, orderNumber, sum, WithdrawalStatusCompleted, now).Scan(&id)
+	if err != nil {
+		return id, err
+	}
+
+	err = d.enqueueWebhookEvent(tx, userID, WebhookEventWithdrawalCreated, map[string]interface{}{
+		"order":        orderNumber,
+		"sum":          sum,
+		"processed_at": now,
+	}, now)
+	if err != nil {
+		return id, err
+	}
+	return id, d.notifyBalanceChanged(tx, userID)
+}
+
+func (d *Database) GetWithdrawListByUserID(userID int, filter ListFilter) (withdrawals []WithdrawalTable, err error) {
+	var afterAt *time.Time
+	var afterID int
+	if filter.After != nil {
+		afterAt, afterID = &filter.After.At, filter.After.ID
+	}
+
+	sql := `SELECT id,user_id,order_number,sum,status,processed_at,cancelled_at,cancel_reason FROM withdrawals
+		WHERE user_id = $1
+			AND ($2::timestamptz IS NULL OR processed_at >= $2)
+			AND ($3::timestamptz IS NULL OR processed_at < $3)
+			AND ($4::timestamptz IS NULL OR (processed_at, id) < ($4, $5))
+			AND (COALESCE(cardinality($7::text[]), 0) = 0 OR status = ANY($7))
+		ORDER BY processed_at DESC, id DESC LIMIT $6`
+	rows, err := d.pgx.Query(d.ctx, sql, userID, filter.From, filter.To, afterAt, afterID, filter.Limit, filter.Statuses)
 	if err != nil {
 		return withdrawals, err
 	}
@@ -288,7 +516,8 @@ func (d *Database) GetWithdrawListByUserID(userID int) (withdrawals []Withdrawal
 
 	for rows.Next() {
 		var withdrawn WithdrawalTable
-		err := rows.Scan(&withdrawn.ID, &withdrawn.UserID, &withdrawn.OrderNumber, &withdrawn.Sum, &withdrawn.ProcessedAt)
+		err := rows.Scan(&withdrawn.ID, &withdrawn.UserID, &withdrawn.OrderNumber, &withdrawn.Sum, &withdrawn.Status,
+			&withdrawn.ProcessedAt, &withdrawn.CancelledAt, &withdrawn.CancelReason)
 		if err != nil {
 			return withdrawals, err
 		}
@@ -0,0 +1,155 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+)
+
+type UserTOTP struct {
+	Login       string
+	Secret      string
+	Enabled     bool
+	LockedUntil time.Time // до этого момента второй шаг входа закрыт после серии неверных кодов
+}
+
+func GetSHA256Hash(text string) string {
+	hash := sha256.Sum256([]byte(text))
+	return hex.EncodeToString(hash[:])
+}
+
+func (d *Database) GetUserTOTP(userID int) (userTOTP UserTOTP, err error) {
+	var lockedUntil *time.Time
+	sql := "SELECT login,totp_secret,totp_enabled,totp_locked_until FROM users WHERE id = $1 LIMIT 1"
+	err = d.pgx.QueryRow(d.ctx, sql, userID).Scan(&userTOTP.Login, &userTOTP.Secret, &userTOTP.Enabled, &lockedUntil)
+	if lockedUntil != nil {
+		userTOTP.LockedUntil = *lockedUntil
+	}
+	return userTOTP, err
+}
+
+// AddUserTOTPFailure учитывает неверный код второго шага входа и возвращает число неудач подряд.
+// Неудачи раньше windowStart забываются. Счётчик хранится у пользователя, поэтому повторный вход его не сбрасывает.
+func (d *Database) AddUserTOTPFailure(userID int, windowStart, now time.Time) (failures int, err error) {
+	sql := `UPDATE users SET totp_failures = CASE WHEN totp_failed_at > $1 THEN totp_failures + 1 ELSE 1 END, totp_failed_at = $2
+		WHERE id = $3 RETURNING totp_failures`
+	err = d.pgx.QueryRow(d.ctx, sql, windowStart, now, userID).Scan(&failures)
+	return failures, err
+}
+
+// LockUserTOTP закрывает второй шаг входа до until и обнуляет счётчик неудач.
+func (d *Database) LockUserTOTP(userID int, until time.Time) (err error) {
+	sql := "UPDATE users SET totp_locked_until=$1,totp_failures=0 WHERE id=$2"
+	_, err = d.pgx.Exec(d.ctx, sql, until, userID)
+	return err
+}
+
+func (d *Database) ResetUserTOTPFailures(userID int) (err error) {
+	sql := "UPDATE users SET totp_failures=0 WHERE id=$1 AND totp_failures<>0"
+	_, err = d.pgx.Exec(d.ctx, sql, userID)
+	return err
+}
+
+// SetUserTOTPSecret сохраняет секрет для ещё не подтверждённой настройки 2FA.
+func (d *Database) SetUserTOTPSecret(userID int, secret string) (err error) {
+	sql := "UPDATE users SET totp_secret=$1,totp_last_step=0 WHERE id=$2 AND totp_enabled=false"
+	tag, err := d.pgx.Exec(d.ctx, sql, secret, userID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrorTOTPAlreadyEnabled
+	}
+	return nil
+}
+
+func (d *Database) EnableUserTOTP(userID int, recoveryCodes []string) (err error) {
+
+	tx, err := d.pgx.Begin(d.ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err == nil {
+			_ = tx.Commit(d.ctx)
+		} else {
+			_ = tx.Rollback(d.ctx)
+		}
+	}()
+
+	sql := "UPDATE users SET totp_enabled=true WHERE id=$1 AND totp_secret<>''"
+	tag, err := tx.Exec(d.ctx, sql, userID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrorTOTPNotEnrolled
+	}
+
+	sql = "DELETE FROM totp_recovery_codes WHERE user_id=$1"
+	_, err = tx.Exec(d.ctx, sql, userID)
+	if err != nil {
+		return err
+	}
+
+	sql = "INSERT INTO totp_recovery_codes (user_id,code_hash) VALUES ($1, $2)"
+	for _, code := range recoveryCodes {
+		_, err = tx.Exec(d.ctx, sql, userID, GetSHA256Hash(code))
+		if err != nil {
+			return err
+		}
+	}
+
+	return err
+}
+
+func (d *Database) DisableUserTOTP(userID int) (err error) {
+
+	tx, err := d.pgx.Begin(d.ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err == nil {
+			_ = tx.Commit(d.ctx)
+		} else {
+			_ = tx.Rollback(d.ctx)
+		}
+	}()
+
+	sql := "UPDATE users SET totp_secret='',totp_enabled=false,totp_last_step=0 WHERE id=$1"
+	_, err = tx.Exec(d.ctx, sql, userID)
+	if err != nil {
+		return err
+	}
+
+	sql = "DELETE FROM totp_recovery_codes WHERE user_id=$1"
+	_, err = tx.Exec(d.ctx, sql, userID)
+	return err
+}
+
+// UseUserTOTPStep запоминает использованный шаг TOTP, чтобы один и тот же код
+// нельзя было предъявить повторно.
+func (d *Database) UseUserTOTPStep(userID int, step int64) (err error) {
+	sql := "UPDATE users SET totp_last_step=$1 WHERE id=$2 AND totp_last_step < $1"
+	tag, err := d.pgx.Exec(d.ctx, sql, step, userID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrorTOTPCodeReused
+	}
+	return nil
+}
+
+func (d *Database) UseUserRecoveryCode(userID int, code string) (err error) {
+	sql := "UPDATE totp_recovery_codes SET used_at=$1 WHERE user_id=$2 AND code_hash=$3 AND used_at IS NULL"
+	tag, err := d.pgx.Exec(d.ctx, sql, time.Now().UTC(), userID, GetSHA256Hash(code))
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrorRecoveryCodeInvalid
+	}
+	return nil
+}
@@ -0,0 +1,207 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/jackc/pgx/v4"
+	"time"
+)
+
+const (
+	WebhookEventOrderProcessed      string = "order.processed"
+	WebhookEventOrderInvalid               = "order.invalid"
+	WebhookEventOrderReturned              = "order.returned"
+	WebhookEventWithdrawalCreated          = "withdrawal.created"
+	WebhookEventWithdrawalCancelled        = "withdrawal.cancelled"
+)
+
+var WebhookEvents = []string{WebhookEventOrderProcessed, WebhookEventOrderInvalid, WebhookEventOrderReturned, WebhookEventWithdrawalCreated, WebhookEventWithdrawalCancelled}
+
+const (
+	WebhookDeliveryPending   string = "pending"
+	WebhookDeliveryDelivered        = "delivered"
+	WebhookDeliveryFailed           = "failed"
+)
+
+type Webhook struct {
+	ID        int
+	UserID    int
+	URL       string
+	Secret    string // нужен в открытом виде для подписи доставок
+	Events    []string
+	CreatedAt time.Time
+}
+
+type WebhookDelivery struct {
+	ID             int
+	WebhookID      int
+	Event          string
+	Payload        string
+	Status         string
+	Attempts       int
+	NextAttemptAt  time.Time
+	LastStatusCode int
+	LastError      string
+	CreatedAt      time.Time
+	DeliveredAt    *time.Time
+
+	URL    string // заполняются при захвате доставки на отправку
+	Secret string
+}
+
+type webhookPayload struct {
+	Event     string      `json:"event"`
+	CreatedAt time.Time   `json:"created_at"`
+	Data      interface{} `json:"data"`
+}
+
+// enqueueWebhookEvent кладёт событие в outbox для всех подписанных вебхуков пользователя
+// в той же транзакции, что и само изменение.
+func (d *Database) enqueueWebhookEvent(q execer, userID int, event string, data interface{}, now time.Time) (err error) {
+	payload, err := json.Marshal(webhookPayload{Event: event, CreatedAt: now, Data: data})
+	if err != nil {
+		return err
+	}
+	sql := `INSERT INTO webhook_deliveries (webhook_id,event,payload,status,attempts,next_attempt_at,created_at)
+		SELECT id, $2, $3, $4, 0, $5, $5 FROM webhooks
+		WHERE user_id = $1 AND deleted_at IS NULL AND $2 = ANY(events)`
+	_, err = q.Exec(d.ctx, sql, userID, event, string(payload), WebhookDeliveryPending, now)
+	return err
+}
+
+func (d *Database) CreateWebhook(hook Webhook) (id int, err error) {
+	sql := "INSERT INTO webhooks (user_id,url,secret,events,created_at) VALUES ($1, $2, $3, $4, $5) RETURNING id"
+	err = d.pgx.QueryRow(d.ctx, sql, hook.UserID, hook.URL, hook.Secret, hook.Events, hook.CreatedAt).Scan(&id)
+	return id, err
+}
+
+func (d *Database) GetWebhooksByUserID(userID int) (hooks []Webhook, err error) {
+	sql := "SELECT id,user_id,url,events,created_at FROM webhooks WHERE user_id = $1 AND deleted_at IS NULL ORDER BY created_at DESC"
+	rows, err := d.pgx.Query(d.ctx, sql, userID)
+	if err != nil {
+		return hooks, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var hook Webhook
+		err = rows.Scan(&hook.ID, &hook.UserID, &hook.URL, &hook.Events, &hook.CreatedAt)
+		if err != nil {
+			return hooks, err
+		}
+		hooks = append(hooks, hook)
+	}
+	if err = rows.Err(); err != nil {
+		return hooks, err
+	}
+
+	return hooks, err
+}
+
+func (d *Database) GetWebhook(userID, id int) (hook Webhook, err error) {
+	sql := "SELECT id,user_id,url,events,created_at FROM webhooks WHERE id = $1 AND user_id = $2 AND deleted_at IS NULL"
+	err = d.pgx.QueryRow(d.ctx, sql, id, userID).Scan(&hook.ID, &hook.UserID, &hook.URL, &hook.Events, &hook.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return hook, ErrorWebhookNotFound
+	}
+	return hook, err
+}
+
+// DeleteWebhook отключает вебхук, его неотправленные доставки помечаются неудачными.
+func (d *Database) DeleteWebhook(userID, id int) (err error) {
+
+	tx, err := d.pgx.Begin(d.ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err == nil {
+			_ = tx.Commit(d.ctx)
+		} else {
+			_ = tx.Rollback(d.ctx)
+		}
+	}()
+
+	now := time.Now().UTC()
+	s1 := "UPDATE webhooks SET deleted_at=$1 WHERE id=$2 AND user_id=$3 AND deleted_at IS NULL"
+	tag, err := tx.Exec(d.ctx, s1, now, id, userID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrorWebhookNotFound
+	}
+
+	s2 := "UPDATE webhook_deliveries SET status=$1,last_error=$2 WHERE webhook_id=$3 AND status=$4"
+	_, err = tx.Exec(d.ctx, s2, WebhookDeliveryFailed, "webhook deleted", id, WebhookDeliveryPending)
+	return err
+}
+
+func (d *Database) GetWebhookDeliveries(webhookID, limit int) (deliveries []WebhookDelivery, err error) {
+	sql := `SELECT id,webhook_id,event,payload,status,attempts,next_attempt_at,last_status_code,last_error,created_at,delivered_at
+		FROM webhook_deliveries WHERE webhook_id = $1 ORDER BY created_at DESC, id DESC LIMIT $2`
+	rows, err := d.pgx.Query(d.ctx, sql, webhookID, limit)
+	if err != nil {
+		return deliveries, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var delivery WebhookDelivery
+		err = rows.Scan(&delivery.ID, &delivery.WebhookID, &delivery.Event, &delivery.Payload, &delivery.Status, &delivery.Attempts,
+			&delivery.NextAttemptAt, &delivery.LastStatusCode, &delivery.LastError, &delivery.CreatedAt, &delivery.DeliveredAt)
+		if err != nil {
+			return deliveries, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	if err = rows.Err(); err != nil {
+		return deliveries, err
+	}
+
+	return deliveries, err
+}
+
+// ClaimWebhookDeliveries забирает доставки, время которых пришло, и откладывает их на lease,
+// чтобы другие экземпляры сервиса не отправили их повторно. Если экземпляр упадёт,
+// доставка вернётся в очередь по истечении lease. lease должен покрывать отправку всех
+// забранных доставок подряд, иначе последние из них успеют забрать повторно.
+func (d *Database) ClaimWebhookDeliveries(limit int, lease time.Duration) (deliveries []WebhookDelivery, err error) {
+	now := time.Now().UTC()
+	sql := `UPDATE webhook_deliveries d SET next_attempt_at = $2
+		FROM webhooks w
+		WHERE w.id = d.webhook_id AND d.id IN (
+			SELECT id FROM webhook_deliveries WHERE status = $3 AND next_attempt_at <= $1
+			ORDER BY next_attempt_at LIMIT $4 FOR UPDATE SKIP LOCKED
+		)
+		RETURNING d.id,d.webhook_id,d.event,d.payload,d.status,d.attempts,d.created_at,w.url,w.secret`
+	rows, err := d.pgx.Query(d.ctx, sql, now, now.Add(lease), WebhookDeliveryPending, limit)
+	if err != nil {
+		return deliveries, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var delivery WebhookDelivery
+		err = rows.Scan(&delivery.ID, &delivery.WebhookID, &delivery.Event, &delivery.Payload, &delivery.Status,
+			&delivery.Attempts, &delivery.CreatedAt, &delivery.URL, &delivery.Secret)
+		if err != nil {
+			return deliveries, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	if err = rows.Err(); err != nil {
+		return deliveries, err
+	}
+
+	return deliveries, err
+}
+
+// UpdateWebhookDelivery сохраняет результат попытки отправки.
+func (d *Database) UpdateWebhookDelivery(delivery WebhookDelivery) (err error) {
+	sql := `UPDATE webhook_deliveries SET status=$1,attempts=$2,next_attempt_at=$3,last_status_code=$4,last_error=$5,delivered_at=$6
+		WHERE id=$7`
+	_, err = d.pgx.Exec(d.ctx, sql, delivery.Status, delivery.Attempts, delivery.NextAttemptAt, delivery.LastStatusCode,
+		delivery.LastError, delivery.DeliveredAt, delivery.ID)
+	return err
+}
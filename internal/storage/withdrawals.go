@@ -0,0 +1,104 @@
+package storage
+
+import (
+	"errors"
+	"github.com/jackc/pgx/v4"
+	"time"
+)
+
+const (
+	WithdrawalStatusCompleted string = "COMPLETED" // баллы списаны
+	WithdrawalStatusCancelled        = "CANCELLED" // списание отменено, баллы возвращены
+)
+
+// WithdrawalCancel — отмена списания пользователем или возврат баллов администратором.
+type WithdrawalCancel struct {
+	UserID      int
+	OrderNumber string
+	ActorID     int // администратор, оформивший возврат; 0 — отмена самим пользователем
+	Reason      string
+	Window      time.Duration // отменить можно не позже Window после списания, 0 — без ограничения
+}
+
+// checkWithdrawalCancel проверяет, что списание ещё можно отменить.
+func checkWithdrawalCancel(withdrawal WithdrawalTable, window time.Duration, now time.Time) error {
+	if withdrawal.Status == WithdrawalStatusCancelled {
+		return ErrorWithdrawalCancelled
+	}
+	if window > 0 && now.Sub(withdrawal.ProcessedAt) > window {
+		return ErrorWithdrawalCancelWindow
+	}
+	return nil
+}
+
+// CancelUserWithdraw возвращает баллы последнего списания в счёт заказа и помечает его CANCELLED.
+// Списание остаётся в истории, возврат отражается отдельной строкой в выписке.
+func (d *Database) CancelUserWithdraw(cancel WithdrawalCancel) (withdrawal WithdrawalTable, err error) {
+
+	tx, err := d.pgx.Begin(d.ctx)
+	if err != nil {
+		return withdrawal, err
+	}
+	defer func() {
+		if err == nil {
+			_ = tx.Commit(d.ctx)
+		} else {
+			_ = tx.Rollback(d.ctx)
+		}
+	}()
+
+	// сначала действующее списание, иначе последнее отменённое — чтобы ответить, что оно уже отменено
+	s1 := `SELECT id,user_id,order_number,sum,status,processed_at FROM withdrawals
+		WHERE user_id = $1 AND order_number = $2
+		ORDER BY status = $3 DESC, processed_at DESC, id DESC LIMIT 1 FOR UPDATE`
+	err = tx.QueryRow(d.ctx, s1, cancel.UserID, cancel.OrderNumber, WithdrawalStatusCompleted).Scan(&withdrawal.ID, &withdrawal.UserID,
+		&withdrawal.OrderNumber, &withdrawal.Sum, &withdrawal.Status, &withdrawal.ProcessedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return withdrawal, ErrorWithdrawalNotFound
+	}
+	if err != nil {
+		return withdrawal, err
+	}
+	now := time.Now().UTC()
+	if err = checkWithdrawalCancel(withdrawal, cancel.Window, now); err != nil {
+		return withdrawal, err
+	}
+
+	s2 := "UPDATE withdrawals SET status=$1,cancelled_at=$2,cancel_reason=$3,cancelled_by=$4 WHERE id=$5"
+	_, err = tx.Exec(d.ctx, s2, WithdrawalStatusCancelled, now, cancel.Reason, nullableID(cancel.ActorID), withdrawal.ID)
+	if err != nil {
+		return withdrawal, err
+	}
+	withdrawal.Status = WithdrawalStatusCancelled
+	withdrawal.CancelledAt = &now
+	withdrawal.CancelReason = cancel.Reason
+
+	s3 := "UPDATE users SET balance=balance+$1,withdrawn=withdrawn-$1 WHERE id=$2"
+	_, err = tx.Exec(d.ctx, s3, withdrawal.Sum, cancel.UserID)
+	if err != nil {
+		return withdrawal, err
+	}
+
+	err = d.restoreAccrualLots(tx, cancel.UserID, withdrawal.Sum)
+	if err != nil {
+		return withdrawal, err
+	}
+
+	err = d.notifyBalanceChanged(tx, cancel.UserID)
+	if err != nil {
+		return withdrawal, err
+	}
+
+	err = d.enqueueWebhookEvent(tx, cancel.UserID, WebhookEventWithdrawalCancelled, map[string]interface{}{
+		"order":        withdrawal.OrderNumber,
+		"sum":          withdrawal.Sum,
+		"processed_at": withdrawal.ProcessedAt,
+		"cancelled_at": now,
+		"reason":       cancel.Reason,
+	}, now)
+	if err != nil {
+		return withdrawal, err
+	}
+
+	return withdrawal, err
+}
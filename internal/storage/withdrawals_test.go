@@ -0,0 +1,119 @@
+package storage
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCheckWithdrawalCancel(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name       string
+		withdrawal WithdrawalTable
+		window     time.Duration
+		want       error
+	}{
+		{"within window", WithdrawalTable{Status: WithdrawalStatusCompleted, ProcessedAt: now.Add(-time.Minute)}, time.Hour, nil},
+		{"window edge", WithdrawalTable{Status: WithdrawalStatusCompleted, ProcessedAt: now.Add(-time.Hour)}, time.Hour, nil},
+		{"outside window", WithdrawalTable{Status: WithdrawalStatusCompleted, ProcessedAt: now.Add(-2 * time.Hour)}, time.Hour, ErrorWithdrawalCancelWindow},
+		{"no window", WithdrawalTable{Status: WithdrawalStatusCompleted, ProcessedAt: now.Add(-24 * time.Hour)}, 0, nil},
+		{"already cancelled", WithdrawalTable{Status: WithdrawalStatusCancelled, ProcessedAt: now}, time.Hour, ErrorWithdrawalCancelled},
+		{"cancelled outside window", WithdrawalTable{Status: WithdrawalStatusCancelled, ProcessedAt: now.Add(-2 * time.Hour)}, time.Hour, ErrorWithdrawalCancelled},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := checkWithdrawalCancel(tt.withdrawal, tt.window, now); !errors.Is(err, tt.want) {
+				t.Errorf("checkWithdrawalCancel() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+// TestCancelUserWithdraw проверяет отмену списания на настоящей базе, см. newTestDatabase.
+func TestCancelUserWithdraw(t *testing.T) {
+	d := newTestDatabase(t)
+	day := 24 * time.Hour
+
+	// списание 70 из партий 60 и 40 оставляет в них 0 и 30
+	restored := UserBalance{Balance: 100}
+	untouched := UserBalance{Balance: 30, Withdrawn: 70}
+
+	tests := []struct {
+		name          string
+		age           time.Duration // сколько прошло со списания
+		cancels       func(userID, otherID int, order string) []WithdrawalCancel
+		wantErrs      []error
+		wantBalance   UserBalance
+		wantRemaining []float64
+	}{
+		{"cancel restores balance and lots", 0,
+			func(userID, _ int, order string) []WithdrawalCancel {
+				return []WithdrawalCancel{{UserID: userID, OrderNumber: order, Window: time.Hour}}
+			},
+			[]error{nil}, restored, []float64{60, 40}},
+		{"outside the window", 2 * time.Hour,
+			func(userID, _ int, order string) []WithdrawalCancel {
+				return []WithdrawalCancel{{UserID: userID, OrderNumber: order, Window: time.Hour}}
+			},
+			[]error{ErrorWithdrawalCancelWindow}, untouched, []float64{0, 30}},
+		{"admin refund has no window", 2 * time.Hour,
+			func(userID, otherID int, order string) []WithdrawalCancel {
+				return []WithdrawalCancel{{UserID: userID, OrderNumber: order, ActorID: otherID, Reason: "refund"}}
+			},
+			[]error{nil}, restored, []float64{60, 40}},
+		{"cancel twice", 0,
+			func(userID, _ int, order string) []WithdrawalCancel {
+				cancel := WithdrawalCancel{UserID: userID, OrderNumber: order, Window: time.Hour}
+				return []WithdrawalCancel{cancel, cancel}
+			},
+			[]error{nil, ErrorWithdrawalCancelled}, restored, []float64{60, 40}},
+		{"another user's withdrawal", 0,
+			func(_, otherID int, order string) []WithdrawalCancel {
+				return []WithdrawalCancel{{UserID: otherID, OrderNumber: order, Window: time.Hour}}
+			},
+			[]error{ErrorWithdrawalNotFound}, untouched, []float64{0, 30}},
+		{"unknown order", 0,
+			func(userID, _ int, order string) []WithdrawalCancel {
+				return []WithdrawalCancel{{UserID: userID, OrderNumber: order + "0", Window: time.Hour}}
+			},
+			[]error{ErrorWithdrawalNotFound}, untouched, []float64{0, 30}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userID := newTestUser(t, d, testDBLot(60, 10*day), testDBLot(40, 30*day))
+			otherID := newTestUser(t, d, testDBLot(100, 0))
+			order := testNumber()
+			if err := d.CreateUserWithdraw(userID, order, 70); err != nil {
+				t.Fatal(err)
+			}
+			if tt.age > 0 {
+				sql := "UPDATE withdrawals SET processed_at=$1 WHERE user_id=$2"
+				if _, err := d.pgx.Exec(d.ctx, sql, time.Now().UTC().Add(-tt.age), userID); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			for i, cancel := range tt.cancels(userID, otherID, order) {
+				withdrawal, err := d.CancelUserWithdraw(cancel)
+				if !errors.Is(err, tt.wantErrs[i]) {
+					t.Fatalf("cancel %d: error = %v, want %v", i, err, tt.wantErrs[i])
+				}
+				if err == nil && (withdrawal.Status != WithdrawalStatusCancelled || withdrawal.Sum != 70) {
+					t.Errorf("cancel %d: withdrawal %+v", i, withdrawal)
+				}
+			}
+
+			if got := testBalance(t, d, userID); got != tt.wantBalance {
+				t.Errorf("balance = %+v, want %+v", got, tt.wantBalance)
+			}
+			if got := testRemaining(t, d, userID); !reflect.DeepEqual(got, tt.wantRemaining) {
+				t.Errorf("lots remaining = %v, want %v", got, tt.wantRemaining)
+			}
+			if got := testBalance(t, d, otherID); got != (UserBalance{Balance: 100}) {
+				t.Errorf("other user's balance = %+v", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package totp
+
+import (
+	"encoding/base32"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret — ключ SHA1 из приложения B RFC 6238 ("12345678901234567890") в base32.
+var rfcSecret = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+// TestCodeRFC6238 сверяет коды с тестовыми векторами RFC 6238 для SHA1.
+// В RFC коды из 8 цифр, здесь из 6: это последние 6 цифр того же значения.
+func TestCodeRFC6238(t *testing.T) {
+	tests := []struct {
+		unix int64
+		want string // в RFC
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+	for _, tt := range tests {
+		got, err := Code(rfcSecret, time.Unix(tt.unix, 0))
+		if err != nil {
+			t.Fatalf("Code(%d): %v", tt.unix, err)
+		}
+		if want := tt.want[len(tt.want)-Digits:]; got != want {
+			t.Errorf("Code(%d) = %s, want %s", tt.unix, got, want)
+		}
+	}
+}
+
+func TestCodeSecretFormat(t *testing.T) {
+	want, _ := Code(rfcSecret, time.Unix(59, 0))
+	for _, secret := range []string{
+		strings.ToLower(rfcSecret),
+		rfcSecret + "====",
+		"GEZD GNBV GY3T QOJQ GEZD GNBV GY3T QOJQ", // так секрет показывают для ручного ввода
+	} {
+		got, err := Code(secret, time.Unix(59, 0))
+		if err != nil || got != want {
+			t.Errorf("Code(%q) = %s, %v, want %s", secret, got, err, want)
+		}
+	}
+	if _, err := Code("not base32!", time.Unix(59, 0)); err == nil {
+		t.Error("Code with an invalid secret: no error")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0) // шаг 37037037, код 050471
+	step := Step(now)
+	prev, _ := Code(rfcSecret, now.Add(-Period*time.Second))
+	next, _ := Code(rfcSecret, now.Add(Period*time.Second))
+	stale, _ := Code(rfcSecret, now.Add(-2*Period*time.Second))
+
+	tests := []struct {
+		name     string
+		code     string
+		wantStep int64
+		wantOK   bool
+	}{
+		{"current", "050471", step, true},
+		{"spaces around", " 050471\n", step, true},
+		{"previous step", prev, step - 1, true},
+		{"next step", next, step + 1, true},
+		{"two steps old", stale, 0, false},
+		{"wrong", "123456", 0, false},
+		{"short", "50471", 0, false},
+		{"8 digits", "14050471", 0, false},
+		{"empty", "", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotStep, ok := Validate(rfcSecret, tt.code, now)
+			if ok != tt.wantOK || gotStep != tt.wantStep {
+				t.Errorf("Validate(%q) = %d, %v, want %d, %v", tt.code, gotStep, ok, tt.wantStep, tt.wantOK)
+			}
+		})
+	}
+
+	if _, ok := Validate("not base32!", "050471", now); ok {
+		t.Error("Validate with an invalid secret succeeded")
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	a, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := GenerateSecret()
+	key, err := decodeSecret(a)
+	if err != nil || len(key) != SecretSize {
+		t.Errorf("GenerateSecret() = %q: %d bytes, %v", a, len(key), err)
+	}
+	if a == b {
+		t.Error("GenerateSecret() returned the same secret twice")
+	}
+}
+
+func TestProvisioningURI(t *testing.T) {
+	u, err := url.Parse(ProvisioningURI("Gophermart", "alice", rfcSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Scheme != "otpauth" || u.Host != "totp" || u.Path != "/Gophermart:alice" {
+		t.Errorf("uri %s", u)
+	}
+	q := u.Query()
+	want := map[string]string{"secret": rfcSecret, "issuer": "Gophermart", "algorithm": "SHA1", "digits": "6", "period": "30"}
+	for k, v := range want {
+		if q.Get(k) != v {
+			t.Errorf("%s = %q, want %q", k, q.Get(k), v)
+		}
+	}
+}
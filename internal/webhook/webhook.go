@@ -0,0 +1,169 @@
+// Package webhook подписывает и отправляет уведомления на адреса, зарегистрированные пользователями.
+//
+// Тело запроса подписывается HMAC-SHA256 секретом вебхука от строки "<timestamp>.<body>",
+// подпись передаётся в заголовке X-Gophermart-Signature в виде "sha256=<hex>".
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	HeaderEvent     = "X-Gophermart-Event"
+	HeaderDelivery  = "X-Gophermart-Delivery"
+	HeaderTimestamp = "X-Gophermart-Timestamp"
+	HeaderSignature = "X-Gophermart-Signature"
+
+	signaturePrefix = "sha256="
+
+	backoffBase = 30 * time.Second
+	backoffMax  = 6 * time.Hour
+)
+
+var (
+	ErrorURL          = errors.New("webhook url must be an absolute http(s) url")
+	ErrorPrivateHost  = errors.New("webhook host resolves to a private address")
+	ErrorSignature    = errors.New("webhook signature mismatch")
+	ErrorTimestamp    = errors.New("webhook timestamp outside tolerance")
+	ErrorDeliveryHTTP = errors.New("webhook receiver returned non-2xx status")
+)
+
+// Sign возвращает значение заголовка подписи.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return signaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify проверяет подпись на стороне получателя. Запросы старше tolerance отклоняются,
+// чтобы перехваченную доставку нельзя было повторить.
+func Verify(secret string, header http.Header, body []byte, tolerance time.Duration, now time.Time) error {
+	timestamp, err := strconv.ParseInt(header.Get(HeaderTimestamp), 10, 64)
+	if err != nil {
+		return ErrorTimestamp
+	}
+	if d := now.Sub(time.Unix(timestamp, 0)); d > tolerance || d < -tolerance {
+		return ErrorTimestamp
+	}
+	if !hmac.Equal([]byte(header.Get(HeaderSignature)), []byte(Sign(secret, timestamp, body))) {
+		return ErrorSignature
+	}
+	return nil
+}
+
+// Backoff возвращает паузу перед следующей попыткой после attempt неудачных: 30с, 1м, 2м... но не больше 6ч.
+func Backoff(attempt int) time.Duration {
+	d := backoffBase
+	for i := 1; i < attempt && d < backoffMax; i++ {
+		d *= 2
+	}
+	if d > backoffMax {
+		d = backoffMax
+	}
+	return d
+}
+
+func isPrivateIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsMulticast()
+}
+
+// ValidateURL проверяет адрес при регистрации вебхука. Имена хостов проверяются позже,
+// при соединении, потому что DNS может поменяться.
+func ValidateURL(raw string, allowPrivate bool) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return ErrorURL
+	}
+	if allowPrivate {
+		return nil
+	}
+	if strings.EqualFold(u.Hostname(), "localhost") {
+		return ErrorPrivateHost
+	}
+	if ip := net.ParseIP(u.Hostname()); ip != nil && isPrivateIP(ip) {
+		return ErrorPrivateHost
+	}
+	return nil
+}
+
+type Delivery struct {
+	ID      int
+	Event   string
+	Payload []byte
+}
+
+type Client struct {
+	http *http.Client
+}
+
+// NewClient создаёт клиент доставки. Без allowPrivate соединения с внутренними адресами
+// запрещены на уровне сокета, переходы по редиректам не выполняются.
+func NewClient(timeout time.Duration, allowPrivate bool) *Client {
+	dialer := &net.Dialer{Timeout: timeout}
+	if !allowPrivate {
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || isPrivateIP(ip) {
+				return ErrorPrivateHost
+			}
+			return nil
+		}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+
+	return &Client{http: &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}}
+}
+
+// Send отправляет доставку и возвращает код ответа получателя.
+// Ошибкой считается всё, кроме ответа 2xx.
+func (c *Client) Send(ctx context.Context, target, secret string, d Delivery) (statusCode int, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(d.Payload))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Gophermart-Webhook/1.0")
+	req.Header.Set(HeaderEvent, d.Event)
+	req.Header.Set(HeaderDelivery, strconv.Itoa(d.ID))
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(HeaderSignature, Sign(secret, timestamp, d.Payload))
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	_ = resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("%w: %d", ErrorDeliveryHTTP, resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,184 @@
+package webhook
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSign(t *testing.T) {
+	tests := []struct {
+		secret    string
+		timestamp int64
+		body      string
+		want      string
+	}{
+		// echo -n '1700000000.{"event":"order.processed"}' | openssl dgst -sha256 -hmac secret
+		{"secret", 1700000000, `{"event":"order.processed"}`, "sha256=72bc88175ee04ab1dc7920d68159ea12673d969c95646f773ea186080944b90b"},
+		{"secret", 1700000000, "", "sha256=" + sha256HMAC("secret", "1700000000.")},
+		{"other", 1700000001, `{}`, "sha256=" + sha256HMAC("other", "1700000001.{}")},
+	}
+	for _, tt := range tests {
+		if got := Sign(tt.secret, tt.timestamp, []byte(tt.body)); got != tt.want {
+			t.Errorf("Sign(%q, %d, %q) = %q, want %q", tt.secret, tt.timestamp, tt.body, got, tt.want)
+		}
+	}
+}
+
+func sha256HMAC(secret, message string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(message))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestVerify(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	body := []byte(`{"event":"order.processed","data":{"number":"12345678903"}}`)
+
+	header := func(secret string, timestamp int64, body []byte) http.Header {
+		h := http.Header{}
+		h.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+		h.Set(HeaderSignature, Sign(secret, timestamp, body))
+		return h
+	}
+
+	tests := []struct {
+		name   string
+		header http.Header
+		body   []byte
+		want   error
+	}{
+		{"valid", header("secret", now.Unix(), body), body, nil},
+		{"clock skew within tolerance", header("secret", now.Unix()+60, body), body, nil},
+		{"wrong secret", header("other", now.Unix(), body), body, ErrorSignature},
+		{"tampered body", header("secret", now.Unix(), body), []byte(`{"event":"order.invalid"}`), ErrorSignature},
+		{"expired", header("secret", now.Add(-10*time.Minute).Unix(), body), body, ErrorTimestamp},
+		{"from the future", header("secret", now.Add(10*time.Minute).Unix(), body), body, ErrorTimestamp},
+		{"no timestamp", http.Header{HeaderSignature: []string{Sign("secret", now.Unix(), body)}}, body, ErrorTimestamp},
+		{"no signature", http.Header{HeaderTimestamp: []string{strconv.FormatInt(now.Unix(), 10)}}, body, ErrorSignature},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Verify("secret", tt.header, tt.body, 5*time.Minute, now)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("Verify() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{0, 30 * time.Second},
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{10, 512 * 30 * time.Second},
+		{11, backoffMax},
+		{100, backoffMax},
+	}
+	for _, tt := range tests {
+		if got := Backoff(tt.attempt); got != tt.want {
+			t.Errorf("Backoff(%d) = %v, want %v", tt.attempt, got, tt.want)
+		}
+	}
+}
+
+func TestValidateURL(t *testing.T) {
+	tests := []struct {
+		url          string
+		allowPrivate bool
+		want         error
+	}{
+		{"https://example.com/hook", false, nil},
+		{"http://example.com:8080/hook", false, nil},
+		{"ftp://example.com/hook", false, ErrorURL},
+		{"/hook", false, ErrorURL},
+		{"https://localhost/hook", false, ErrorPrivateHost},
+		{"https://127.0.0.1/hook", false, ErrorPrivateHost},
+		{"https://10.1.2.3/hook", false, ErrorPrivateHost},
+		{"https://[::1]/hook", false, ErrorPrivateHost},
+		{"https://169.254.169.254/latest", false, ErrorPrivateHost},
+		{"https://127.0.0.1/hook", true, nil},
+	}
+	for _, tt := range tests {
+		if err := ValidateURL(tt.url, tt.allowPrivate); !errors.Is(err, tt.want) {
+			t.Errorf("ValidateURL(%q, %v) = %v, want %v", tt.url, tt.allowPrivate, err, tt.want)
+		}
+	}
+}
+
+// TestClientSend проверяет, что получатель может проверить подпись доставки через Verify.
+func TestClientSend(t *testing.T) {
+	payload := []byte(`{"event":"order.processed","data":{"number":"12345678903"}}`)
+
+	var verifyErr error
+	var gotEvent, gotDelivery string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		verifyErr = Verify("secret", r.Header, body, time.Minute, time.Now())
+		gotEvent, gotDelivery = r.Header.Get(HeaderEvent), r.Header.Get(HeaderDelivery)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	client := NewClient(time.Second, true)
+	statusCode, err := client.Send(context.Background(), server.URL, "secret", Delivery{ID: 42, Event: "order.processed", Payload: payload})
+	if err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	if statusCode != http.StatusNoContent {
+		t.Errorf("Send() status = %d, want %d", statusCode, http.StatusNoContent)
+	}
+	if verifyErr != nil {
+		t.Errorf("receiver Verify() = %v", verifyErr)
+	}
+	if gotEvent != "order.processed" || gotDelivery != "42" {
+		t.Errorf("headers event=%q delivery=%q", gotEvent, gotDelivery)
+	}
+}
+
+func TestClientSendErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/redirect":
+			http.Redirect(w, r, "/ok", http.StatusFound)
+		case "/ok":
+			w.WriteHeader(http.StatusOK)
+		default:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	client := NewClient(time.Second, true)
+	d := Delivery{ID: 1, Event: "order.processed", Payload: []byte(`{}`)}
+
+	statusCode, err := client.Send(context.Background(), server.URL+"/fail", "secret", d)
+	if !errors.Is(err, ErrorDeliveryHTTP) || statusCode != http.StatusServiceUnavailable {
+		t.Errorf("non-2xx: Send() = %d, %v", statusCode, err)
+	}
+
+	// редиректы не выполняются: иначе через них можно попасть на внутренний адрес
+	statusCode, err = client.Send(context.Background(), server.URL+"/redirect", "secret", d)
+	if !errors.Is(err, ErrorDeliveryHTTP) || statusCode != http.StatusFound {
+		t.Errorf("redirect: Send() = %d, %v", statusCode, err)
+	}
+
+	// httptest слушает на 127.0.0.1, без allowPrivate соединение запрещается на уровне сокета
+	statusCode, err = NewClient(time.Second, false).Send(context.Background(), server.URL+"/ok", "secret", d)
+	if !errors.Is(err, ErrorPrivateHost) || statusCode != 0 {
+		t.Errorf("private host: Send() = %d, %v", statusCode, err)
+	}
+}
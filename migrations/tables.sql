@@ -23,3 +23,284 @@ CREATE TABLE IF NOT EXISTS withdrawals (
                                       sum double precision NOT NULL,
                                       processed_at timestamptz NOT NULL
 );
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_secret text NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_enabled boolean NOT NULL DEFAULT false;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_last_step bigint NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS totp_recovery_codes (
+                                      id serial PRIMARY KEY,
+                                      user_id    integer NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+                                      code_hash text NOT NULL,
+                                      used_at timestamptz
+);
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS role text NOT NULL DEFAULT 'user';
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS frozen boolean NOT NULL DEFAULT false;
+
+CREATE TABLE IF NOT EXISTS balance_adjustments (
+                                      id serial PRIMARY KEY,
+                                      user_id    integer NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+                                      actor_id   integer REFERENCES users(id) ON DELETE SET NULL,
+                                      amount double precision NOT NULL,
+                                      reason text NOT NULL,
+                                      created_at timestamptz NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS admin_audit_log (
+                                      id serial PRIMARY KEY,
+                                      actor_id   integer REFERENCES users(id) ON DELETE SET NULL,
+                                      action text NOT NULL,
+                                      target_user_id integer,
+                                      target text NOT NULL DEFAULT '',
+                                      details text NOT NULL DEFAULT '',
+                                      status integer NOT NULL,
+                                      created_at timestamptz NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS admin_audit_log_target_user_idx ON admin_audit_log (target_user_id, created_at DESC);
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS session_version integer NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS password_reset_tokens (
+                                      id serial PRIMARY KEY,
+                                      user_id    integer NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+                                      token_hash text NOT NULL UNIQUE,
+                                      expires_at timestamptz NOT NULL,
+                                      used_at timestamptz
+);
+
+CREATE TABLE IF NOT EXISTS api_keys (
+                                      id serial PRIMARY KEY,
+                                      user_id    integer NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+                                      name text NOT NULL,
+                                      prefix text NOT NULL,
+                                      key_hash text NOT NULL UNIQUE,
+                                      scopes text[] NOT NULL,
+                                      created_at timestamptz NOT NULL,
+                                      last_used_at timestamptz,
+                                      revoked_at timestamptz
+);
+
+CREATE TABLE IF NOT EXISTS user_identities (
+                                      id serial PRIMARY KEY,
+                                      user_id    integer NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+                                      issuer text NOT NULL,
+                                      subject text NOT NULL,
+                                      created_at timestamptz NOT NULL,
+                                      UNIQUE (issuer, subject)
+);
+
+CREATE TABLE IF NOT EXISTS login_events (
+                                      id serial PRIMARY KEY,
+                                      user_id    integer REFERENCES users(id) ON DELETE CASCADE,
+                                      login text NOT NULL,
+                                      success boolean NOT NULL,
+                                      method text NOT NULL,
+                                      reason text NOT NULL DEFAULT '',
+                                      ip text NOT NULL,
+                                      user_agent text NOT NULL,
+                                      fingerprint text NOT NULL,
+                                      new_device boolean NOT NULL DEFAULT false,
+                                      created_at timestamptz NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS login_events_user_idx ON login_events (user_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS login_events_fingerprint_idx ON login_events (user_id, fingerprint) WHERE success;
+
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS processed_at timestamptz;
+
+-- раньше uploaded_at перезаписывался при каждой смене статуса, для завершённых заказов это и есть время обработки
+UPDATE orders SET processed_at = uploaded_at WHERE processed_at IS NULL AND status IN ('PROCESSED', 'INVALID');
+
+CREATE TABLE IF NOT EXISTS order_status_history (
+                                      id serial PRIMARY KEY,
+                                      order_id   integer NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+                                      status text NOT NULL,
+                                      accrual double precision NOT NULL DEFAULT 0,
+                                      source text NOT NULL,
+                                      created_at timestamptz NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS order_status_history_order_idx ON order_status_history (order_id, created_at);
+
+-- заказы, загруженные до появления истории, получают одну запись с текущим статусом
+INSERT INTO order_status_history (order_id,status,accrual,source,created_at)
+SELECT o.id, o.status, o.accrual, 'upload', o.uploaded_at FROM orders o
+WHERE NOT EXISTS (SELECT 1 FROM order_status_history h WHERE h.order_id = o.id);
+
+CREATE INDEX IF NOT EXISTS orders_user_uploaded_idx ON orders (user_id, uploaded_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS orders_user_status_uploaded_idx ON orders (user_id, status, uploaded_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS withdrawals_user_processed_idx ON withdrawals (user_id, processed_at DESC, id DESC);
+
+CREATE TABLE IF NOT EXISTS webhooks (
+                                      id serial PRIMARY KEY,
+                                      user_id    integer NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+                                      url text NOT NULL,
+                                      secret text NOT NULL,
+                                      events text[] NOT NULL,
+                                      created_at timestamptz NOT NULL,
+                                      deleted_at timestamptz
+);
+
+CREATE INDEX IF NOT EXISTS webhooks_user_idx ON webhooks (user_id) WHERE deleted_at IS NULL;
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+                                      id serial PRIMARY KEY,
+                                      webhook_id integer NOT NULL REFERENCES webhooks(id) ON DELETE CASCADE,
+                                      event text NOT NULL,
+                                      payload text NOT NULL,
+                                      status text NOT NULL,
+                                      attempts integer NOT NULL DEFAULT 0,
+                                      next_attempt_at timestamptz NOT NULL,
+                                      last_status_code integer NOT NULL DEFAULT 0,
+                                      last_error text NOT NULL DEFAULT '',
+                                      created_at timestamptz NOT NULL,
+                                      delivered_at timestamptz
+);
+
+CREATE INDEX IF NOT EXISTS webhook_deliveries_pending_idx ON webhook_deliveries (next_attempt_at) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS webhook_deliveries_webhook_idx ON webhook_deliveries (webhook_id, created_at DESC);
+
+ALTER TABLE withdrawals ADD COLUMN IF NOT EXISTS status text NOT NULL DEFAULT 'COMPLETED';
+ALTER TABLE withdrawals ADD COLUMN IF NOT EXISTS cancelled_at timestamptz;
+ALTER TABLE withdrawals ADD COLUMN IF NOT EXISTS cancel_reason text NOT NULL DEFAULT '';
+ALTER TABLE withdrawals ADD COLUMN IF NOT EXISTS cancelled_by integer REFERENCES users(id) ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS withdrawals_user_order_idx ON withdrawals (user_id, order_number);
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS held double precision NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS withdrawal_holds (
+                                      id serial PRIMARY KEY,
+                                      user_id    integer NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+                                      order_number text NOT NULL,
+                                      sum double precision NOT NULL,
+                                      status text NOT NULL,
+                                      captured_sum double precision NOT NULL DEFAULT 0,
+                                      created_at timestamptz NOT NULL,
+                                      expires_at timestamptz NOT NULL,
+                                      closed_at timestamptz,
+                                      withdrawal_id integer REFERENCES withdrawals(id) ON DELETE SET NULL
+);
+
+CREATE INDEX IF NOT EXISTS withdrawal_holds_user_idx ON withdrawal_holds (user_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS withdrawal_holds_expires_idx ON withdrawal_holds (expires_at) WHERE status = 'AUTHORIZED';
+
+CREATE TABLE IF NOT EXISTS accrual_lots (
+                                      id serial PRIMARY KEY,
+                                      user_id    integer NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+                                      order_number text NOT NULL,
+                                      amount double precision NOT NULL,
+                                      remaining double precision NOT NULL,
+                                      created_at timestamptz NOT NULL,
+                                      expires_at timestamptz,
+                                      expired_at timestamptz
+);
+
+CREATE INDEX IF NOT EXISTS accrual_lots_user_idx ON accrual_lots (user_id, expires_at, id) WHERE expired_at IS NULL;
+CREATE INDEX IF NOT EXISTS accrual_lots_expires_idx ON accrual_lots (expires_at) WHERE expired_at IS NULL;
+
+CREATE TABLE IF NOT EXISTS point_expirations (
+                                      id serial PRIMARY KEY,
+                                      user_id    integer NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+                                      lot_id     integer NOT NULL REFERENCES accrual_lots(id) ON DELETE CASCADE,
+                                      order_number text NOT NULL,
+                                      amount double precision NOT NULL,
+                                      created_at timestamptz NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS point_expirations_user_idx ON point_expirations (user_id, created_at);
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS pending double precision NOT NULL DEFAULT 0;
+ALTER TABLE accrual_lots ADD COLUMN IF NOT EXISTS available_at timestamptz;
+ALTER TABLE accrual_lots ADD COLUMN IF NOT EXISTS pending boolean NOT NULL DEFAULT false;
+
+CREATE INDEX IF NOT EXISTS accrual_lots_pending_idx ON accrual_lots (available_at) WHERE pending;
+CREATE INDEX IF NOT EXISTS accrual_lots_order_idx ON accrual_lots (user_id, order_number);
+
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS returned double precision NOT NULL DEFAULT 0;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS clawback_debt double precision NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS order_returns (
+                                      id serial PRIMARY KEY,
+                                      user_id    integer NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+                                      order_id   integer NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+                                      order_number text NOT NULL,
+                                      amount double precision NOT NULL,
+                                      from_pending double precision NOT NULL DEFAULT 0,
+                                      from_balance double precision NOT NULL DEFAULT 0,
+                                      debt double precision NOT NULL DEFAULT 0,
+                                      actor_id   integer REFERENCES users(id) ON DELETE SET NULL,
+                                      reason text NOT NULL DEFAULT '',
+                                      created_at timestamptz NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS order_returns_user_idx ON order_returns (user_id, created_at);
+CREATE INDEX IF NOT EXISTS order_returns_order_idx ON order_returns (order_id);
+
+-- погашение долга по возвратам из новых начислений
+CREATE TABLE IF NOT EXISTS clawback_recoveries (
+                                      id serial PRIMARY KEY,
+                                      user_id    integer NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+                                      order_number text NOT NULL,
+                                      amount double precision NOT NULL,
+                                      created_at timestamptz NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS clawback_recoveries_user_idx ON clawback_recoveries (user_id, created_at);
+
+ALTER TABLE order_returns ADD COLUMN IF NOT EXISTS from_expired double precision NOT NULL DEFAULT 0;
+
+-- логины хранятся в виде policy.NormalizeLogin (NFKC + свёртка регистра). Старые записи приводит к нему
+-- gophermart-admin -normalize-logins, уникальность обеспечивает users.login UNIQUE.
+DROP INDEX IF EXISTS users_login_normalized_idx;
+DROP FUNCTION IF EXISTS normalize_login(text);
+
+-- логины, переименованные gophermart-admin -normalize-logins из-за совпадения с другим пользователем
+CREATE TABLE IF NOT EXISTS login_conflicts (
+                                      id serial PRIMARY KEY,
+                                      user_id    integer NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+                                      old_login text NOT NULL,
+                                      new_login text NOT NULL,
+                                      created_at timestamptz NOT NULL
+);
+
+-- неверные коды второго шага входа: счётчик подряд и блокировка после серии
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_failures integer NOT NULL DEFAULT 0;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_failed_at timestamptz;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_locked_until timestamptz;
+
+-- секреты, которые сервис создаёт сам, если они не заданы в окружении (ключ сессий без SESSION_KEYS)
+CREATE TABLE IF NOT EXISTS app_secrets (
+                                      name text PRIMARY KEY,
+                                      value text NOT NULL,
+                                      created_at timestamptz NOT NULL
+);
+
+-- номер события в потоке заказов пользователя. Выдаётся под блокировкой строки users, поэтому события
+-- пользователя фиксируются в порядке номеров, в отличие от serial-id истории
+ALTER TABLE users ADD COLUMN IF NOT EXISTS order_event_seq bigint NOT NULL DEFAULT 0;
+ALTER TABLE order_status_history ADD COLUMN IF NOT EXISTS user_seq bigint;
+
+WITH numbered AS (
+    SELECT h.id, o.user_id, u.order_event_seq + row_number() OVER (PARTITION BY o.user_id ORDER BY h.id) AS user_seq
+    FROM order_status_history h
+    JOIN orders o ON o.id = h.order_id
+    JOIN users u ON u.id = o.user_id
+    WHERE h.user_seq IS NULL
+), history AS (
+    UPDATE order_status_history h SET user_seq = n.user_seq FROM numbered n WHERE h.id = n.id
+)
+UPDATE users u SET order_event_seq = m.user_seq
+FROM (SELECT user_id, max(user_seq) AS user_seq FROM numbered GROUP BY user_id) m
+WHERE u.id = m.user_id;
+
+-- страницы выгрузки (storage.exportSources) читаются по (user_id, время строки, id) каждого источника
+CREATE INDEX IF NOT EXISTS orders_user_accrual_idx ON orders (user_id, COALESCE(processed_at, uploaded_at), id)
+    WHERE status = 'PROCESSED' AND accrual > 0;
+CREATE INDEX IF NOT EXISTS accrual_lots_user_available_idx ON accrual_lots (user_id, COALESCE(available_at, created_at), id)
+    WHERE NOT pending;
+CREATE INDEX IF NOT EXISTS withdrawals_user_cancelled_idx ON withdrawals (user_id, cancelled_at, id) WHERE cancelled_at IS NOT NULL;
+CREATE INDEX IF NOT EXISTS balance_adjustments_user_idx ON balance_adjustments (user_id, created_at, id);